@@ -0,0 +1,482 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// config is read from a toml or json file, ex:
+//
+//	# defaults of flags, the command line overrides them
+//	format = "downloads/%[default]"
+//
+//	# options of every service
+//	[options]
+//	quality = "best"
+//
+//	# options of one service, override the ones above
+//	[youtube]
+//	onlyAudio = "yes"
+//
+//	# archive sites of dead 4chan threads by board, comma separated, replace the default ones
+//	[fourchan-archives]
+//	tg = "https://archive.4plebs.org, https://desuarchive.org"
+//
+// json files have the same layout, with sections as objects
+type config struct {
+	format   string
+	cookies  string
+	archive  string
+	duration string
+	// options of services are scoped by their name like on the command line, ex: youtube.onlyAudio
+	options map[string]string
+	// by board, nil if there are none
+	fourchanArchives map[string][]string
+}
+
+// defaultConfigPaths returns paths of config files in the order they're looked for
+func defaultConfigPaths() []string {
+	configDir := os.Getenv("XDG_CONFIG_HOME")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		configDir = filepath.Join(home, ".config")
+	}
+
+	return []string{
+		filepath.Join(configDir, "piko", "config.toml"),
+		filepath.Join(configDir, "piko", "config.json"),
+	}
+}
+
+// loadConfig reads the config at path, or the first existing default one if path is empty.
+// Missing default configs aren't an error
+func loadConfig(path string) (config, error) {
+	if path == "" {
+		for _, defaultPath := range defaultConfigPaths() {
+			if _, err := os.Stat(defaultPath); err == nil {
+				path = defaultPath
+				break
+			}
+		}
+
+		if path == "" {
+			return config{options: map[string]string{}}, nil
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return config{}, err
+	}
+	defer file.Close()
+
+	var sections map[string]map[string]string
+	if filepath.Ext(path) == ".json" {
+		sections, err = parseJSONConfig(file)
+	} else {
+		sections, err = parseTOMLConfig(file)
+	}
+	if err != nil {
+		return config{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	c, err := newConfig(sections)
+	if err != nil {
+		return config{}, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return c, nil
+}
+
+// newConfig builds the config from values by section, "" is the top level
+func newConfig(sections map[string]map[string]string) (config, error) {
+	c := config{options: map[string]string{}}
+
+	for section, values := range sections {
+		for key, value := range values {
+			switch section {
+			case "":
+				switch key {
+				case "format":
+					c.format = value
+				case "cookies":
+					c.cookies = value
+				case "archive":
+					c.archive = value
+				case "duration":
+					if _, err := time.ParseDuration(value); err != nil {
+						return c, errors.New("duration must be a duration, ex: 90s, 1h30m")
+					}
+					c.duration = value
+				default:
+					return c, fmt.Errorf("unknown key %s, options go in the [options] section", key)
+				}
+			case "options":
+				c.options[key] = value
+			case "fourchan-archives":
+				if c.fourchanArchives == nil {
+					c.fourchanArchives = map[string][]string{}
+				}
+				c.fourchanArchives[key] = splitList(value)
+			default:
+				c.options[section+"."+key] = value
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// splitList splits a comma separated list, an empty value is an empty list
+func splitList(value string) []string {
+	list := []string{}
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+
+	return list
+}
+
+// parseTOMLConfig parses the subset of toml used by the config:
+// comments, [section] headers and key = value pairs with string, number or boolean values
+func parseTOMLConfig(reader io.Reader) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{"": {}}
+	section := ""
+
+	scanner := bufio.NewScanner(reader)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' {
+				return nil, fmt.Errorf("line %d: unterminated section header", lineNumber)
+			}
+
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if sections[section] == nil {
+				sections[section] = map[string]string{}
+			}
+			continue
+		}
+
+		keyValue := strings.SplitN(line, "=", 2)
+		if len(keyValue) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", lineNumber)
+		}
+
+		key := strings.Trim(strings.TrimSpace(keyValue[0]), `"`)
+		value, err := parseTOMLValue(strings.TrimSpace(keyValue[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+
+		sections[section][key] = value
+	}
+
+	return sections, scanner.Err()
+}
+
+func parseTOMLValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := closingQuote(value)
+		if end == -1 {
+			return "", errors.New("unterminated string")
+		}
+
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		// literal strings have no escapes
+		end := strings.Index(value[1:], "'")
+		if end == -1 {
+			return "", errors.New("unterminated string")
+		}
+
+		return value[1 : end+1], nil
+	}
+
+	// numbers and booleans, cut a trailing comment
+	value = strings.TrimSpace(strings.SplitN(value, "#", 2)[0])
+	if value == "" {
+		return "", errors.New("missing value")
+	}
+
+	return value, nil
+}
+
+// closingQuote returns the index of the quote closing the basic string at the beginning of value, or -1
+func closingQuote(value string) int {
+	for i := 1; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+
+	return -1
+}
+
+// parseJSONConfig parses an object with top level values and objects as sections
+func parseJSONConfig(reader io.Reader) (map[string]map[string]string, error) {
+	raw := map[string]interface{}{}
+	if err := json.NewDecoder(reader).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	sections := map[string]map[string]string{"": {}}
+	for key, value := range raw {
+		object, isObject := value.(map[string]interface{})
+		if !isObject {
+			sections[""][key] = fmt.Sprint(value)
+			continue
+		}
+
+		sections[key] = map[string]string{}
+		for optionKey, optionValue := range object {
+			sections[key][optionKey] = fmt.Sprint(optionValue)
+		}
+	}
+
+	return sections, nil
+}
+
+// givenOptions are options given by the user for one service
+type givenOptions struct {
+	values map[string]string
+	// keys scoped to this service, in its config section or like youtube.onlyAudio,
+	// these must be available
+	explicit map[string]bool
+	// keys given on the command line without a scope. They apply to every service,
+	// so only their values and typos of available keys are checked for each item.
+	// Unscoped config options are only checked against all items of the run, see runOptions
+	unscoped map[string]bool
+}
+
+// optionsFor merges options for the service, from lowest to highest precedence:
+// config options, config options of the service, user options, user options of the service
+func optionsFor(serviceName string, configOptions, userOptions map[string]string) givenOptions {
+	configGlobal, configScoped := scopeOptions(configOptions, serviceName)
+	userGlobal, userScoped := scopeOptions(userOptions, serviceName)
+
+	explicit := map[string]bool{}
+	for _, options := range []map[string]string{configScoped, userScoped} {
+		for key := range options {
+			explicit[key] = true
+		}
+	}
+
+	unscoped := map[string]bool{}
+	for key := range userGlobal {
+		if !explicit[key] {
+			unscoped[key] = true
+		}
+	}
+
+	return givenOptions{
+		values:   mergeStringMaps(configGlobal, configScoped, userGlobal, userScoped),
+		explicit: explicit,
+		unscoped: unscoped,
+	}
+}
+
+// scopeOptions splits options into ones for every service and ones scoped to serviceName,
+// with the scope cut off. Options scoped to other services are left out
+func scopeOptions(options map[string]string, serviceName string) (global, scoped map[string]string) {
+	global = map[string]string{}
+	scoped = map[string]string{}
+
+	for key, value := range options {
+		dot := strings.Index(key, ".")
+		if dot == -1 {
+			global[key] = value
+			continue
+		}
+
+		if strings.ToLower(key[:dot]) == serviceName {
+			scoped[key[dot+1:]] = value
+		}
+	}
+
+	return global, scoped
+}
+
+// validateScopes returns an error for every option scoped to a service that doesn't exist
+func validateScopes(options map[string]string, serviceNames []string) []error {
+	keys := []string{}
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	errs := []error{}
+	for _, key := range keys {
+		dot := strings.Index(key, ".")
+		if dot == -1 {
+			continue
+		}
+
+		scope := strings.ToLower(key[:dot])
+		if !containsString(serviceNames, scope) {
+			errs = append(errs, fmt.Errorf("unknown service %s of option %s%s", scope, key, suggestion(scope, serviceNames)))
+		}
+	}
+
+	return errs
+}
+
+// validateOptions returns an error for every explicit option that isn't available
+// and for every unscoped one with an invalid value or a key close to an available one,
+// suggesting the closest available key or value
+func validateOptions(available map[string][]string, given givenOptions) []error {
+	keys := []string{}
+	for key := range given.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	availableKeys := []string{}
+	for key := range available {
+		availableKeys = append(availableKeys, key)
+	}
+	sort.Strings(availableKeys)
+
+	errs := []error{}
+	for _, key := range keys {
+		if !given.explicit[key] && !given.unscoped[key] {
+			continue
+		}
+		value := given.values[key]
+
+		values, found := available[key]
+		if !found {
+			// unscoped options of other services are fine, typos of this one's aren't
+			if _, isTypo := closestString(key, availableKeys); given.explicit[key] || isTypo {
+				errs = append(errs, fmt.Errorf("unknown option %s%s", key, suggestion(key, availableKeys)))
+			}
+			continue
+		}
+
+		if !containsString(values, value) {
+			errs = append(errs, fmt.Errorf("invalid value %s of option %s%s", value, key, suggestion(value, values)))
+		}
+	}
+
+	return errs
+}
+
+// applicableOptions leaves out unscoped config options with values the item doesn't have,
+// so its defaults are used instead, ex: quality=best for items with only hd and sd
+func applicableOptions(available map[string][]string, given givenOptions) map[string]string {
+	options := map[string]string{}
+	for key, value := range given.values {
+		values, found := available[key]
+		if !given.explicit[key] && !given.unscoped[key] && found && !containsString(values, value) {
+			continue
+		}
+
+		options[key] = value
+	}
+
+	return options
+}
+
+// runOptions collects available options of all items of the run
+type runOptions map[string][]string
+
+func (r runOptions) add(available map[string][]string) {
+	for key, values := range available {
+		if _, found := r[key]; !found {
+			r[key] = []string{}
+		}
+		for _, value := range values {
+			if !containsString(r[key], value) {
+				r[key] = append(r[key], value)
+			}
+		}
+	}
+}
+
+// validateUnscoped returns an error for every unscoped option which no item of the run has
+func (r runOptions) validateUnscoped(options map[string]string) []error {
+	global, _ := scopeOptions(options, "")
+
+	explicit := map[string]bool{}
+	for key := range global {
+		explicit[key] = true
+	}
+
+	return validateOptions(r, givenOptions{values: global, explicit: explicit})
+}
+
+// unknownUnscoped returns an error for every unscoped command line option which no item of the run has.
+// Their values and typos were already checked for every item
+func (r runOptions) unknownUnscoped(options map[string]string) []error {
+	keys := []string{}
+	for key := range r {
+		keys = append(keys, key)
+	}
+
+	global, _ := scopeOptions(options, "")
+	unknown := map[string]string{}
+	for key, value := range global {
+		if _, found := r[key]; found {
+			continue
+		}
+		if _, isTypo := closestString(key, keys); isTypo {
+			continue
+		}
+
+		unknown[key] = value
+	}
+
+	return r.validateUnscoped(unknown)
+}
+
+// suggestion returns ", did you mean <closest>?" if one of candidates is close to str,
+// otherwise lists all candidates
+func suggestion(str string, candidates []string) string {
+	if len(candidates) == 0 {
+		return ", no options are available"
+	}
+
+	if closest, ok := closestString(str, candidates); ok {
+		return ", did you mean " + closest + "?"
+	}
+
+	return ", available: " + strings.Join(candidates, ", ")
+}
@@ -17,9 +17,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -27,6 +29,7 @@ import (
 
 	"github.com/mlvzk/piko"
 	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/feed"
 	"github.com/mlvzk/qtils/commandparser"
 	"github.com/mlvzk/qtils/commandparser/commandhelper"
 	"gopkg.in/cheggaaa/pb.v1"
@@ -35,11 +38,28 @@ import (
 var (
 	discoveryMode bool
 	stdoutMode    bool
+	watchMode     bool
+	strictOptions bool
 	formatStr     string
+	duration      string
+	cookiesPath   string
+	archivePath   string
+	batchFile     string
+	configPath    string
 	targets       []string
 	userOptions   = map[string]string{}
+	configOptions = map[string]string{}
+	userFilters   = map[string][]string{}
+	// option errors already logged, by service, so they're reported once
+	reportedOptionErrors = map[string]bool{}
+	// of items handled so far, unscoped options are checked against them at the end
+	handledOptions = runOptions{}
+	handledItems   int
 )
 
+// errInvalidOptions stops the run in strict options mode
+var errInvalidOptions = errors.New("invalid options")
+
 func handleArgv(argv []string) {
 	parser := commandparser.New()
 	helper := commandhelper.New()
@@ -51,28 +71,50 @@ func handleArgv(argv []string) {
 		"piko [urls...]",
 		"piko 'https://www.youtube.com/watch?v=dQw4w9WgXcQ'",
 		"piko 'https://www.youtube.com/watch?v=dQw4w9WgXcQ' --stdout | mpv -",
+		"piko 'https://www.twitch.tv/channel' --duration 1h --stdout | mpv -",
+		"piko --batch-file urls.txt",
+		"cat urls.txt | piko -",
 	)
 
-	parser.AddOption(helper.EatOption(
+	options := []commandhelper.OptionBuilder{
 		commandhelper.NewOption("help").Alias("h").Boolean().Description("Prints this page"),
-		commandhelper.
-			NewOption("format").
-			Alias("f").
-			Description(`File path format, ex: --format %[id].%[ext]. "id" and "ext" are meta tags(see --discover).
-Use %[default] to fill with default format, ex: downloads/%[default]`),
-		commandhelper.
-			NewOption("option").
-			Alias("o").
-			Arrayed().
-			ValidateBind(commandhelper.ValidateKeyValue("=")).
-			Description("Download options, ex: --option quality=best"),
+	}
+	options = append(options, targetOptions()...)
+	options = append(options,
 		commandhelper.
 			NewOption("discover").
 			Alias("d").
 			Boolean().
 			Description("Discovery mode, doesn't download anything, only outputs information"),
 		commandhelper.NewOption("stdout").Boolean().Description("Output download media to stdout"),
-	)...)
+		commandhelper.
+			NewOption("watch").
+			Alias("w").
+			Boolean().
+			Description("Keep watching targets for new media, ex: 4chan threads until they are archived"),
+		commandhelper.
+			NewOption("duration").
+			ValidateBind(validateDuration).
+			Description("Stop recording live streams after this much media, ex: --duration 1h30m"),
+		commandhelper.
+			NewOption("cookies").
+			Description("Cookies file in the netscape format, for content that requires login, ex: --cookies cookies.txt"),
+		commandhelper.
+			NewOption("archive").
+			Description(`File of downloaded podcast episodes, they're skipped on re-runs. "none" disables it, ex: --archive none`),
+		commandhelper.
+			NewOption("batch-file").
+			Description(`File with targets, one per line, "-" reads from stdin. Blank lines and lines starting with # are skipped.
+Lines can override --format, --option and --filter, ex: https://imgur.com/a/abc -o quality=best`),
+		commandhelper.
+			NewOption("strict-options").
+			Boolean().
+			Description("Stop on the first option which isn't available for an item, instead of only warning"),
+		commandhelper.
+			NewOption("config").
+			Description("Config file with default flags and options, ~/.config/piko/config.toml or config.json if not set"),
+	)
+	parser.AddOption(helper.EatOption(options...)...)
 
 	cmd, err := parser.Parse(argv)
 	if err != nil {
@@ -80,7 +122,7 @@ Use %[default] to fill with default format, ex: downloads/%[default]`),
 		os.Exit(1)
 	}
 
-	if cmd.Booleans["help"] || len(cmd.Positionals) == 0 {
+	if cmd.Booleans["help"] || (len(cmd.Positionals) == 0 && cmd.Args["batch-file"] == "") {
 		fmt.Print(helper.Help())
 		os.Exit(1)
 	}
@@ -97,13 +139,16 @@ Use %[default] to fill with default format, ex: downloads/%[default]`),
 	formatStr = cmd.Args["format"]
 	discoveryMode = cmd.Booleans["discover"]
 	stdoutMode = cmd.Booleans["stdout"]
+	watchMode = cmd.Booleans["watch"]
+	strictOptions = cmd.Booleans["strict-options"]
+	duration = cmd.Args["duration"]
+	cookiesPath = cmd.Args["cookies"]
+	archivePath = cmd.Args["archive"]
+	batchFile = cmd.Args["batch-file"]
+	configPath = cmd.Args["config"]
 
-	for _, option := range cmd.Arrayed["option"] {
-		keyValue := strings.Split(option, "=")
-		key, value := keyValue[0], keyValue[1]
-
-		userOptions[key] = value
-	}
+	parseOptions(cmd.Arrayed["option"], userOptions)
+	parseFilters(cmd.Arrayed["filter"], userFilters)
 
 	targets = cmd.Positionals
 }
@@ -114,7 +159,127 @@ func main() {
 	// if tests were run in main_test
 	handleArgv(os.Args)
 
-	services := piko.GetAllServices()
+	c, err := loadConfig(configPath)
+	if err != nil {
+		log.Printf("Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+	// the command line takes precedence
+	if formatStr == "" {
+		formatStr = c.format
+	}
+	if cookiesPath == "" {
+		cookiesPath = c.cookies
+	}
+	if duration == "" {
+		duration = c.duration
+	}
+	if archivePath == "" {
+		archivePath = c.archive
+	}
+	switch archivePath {
+	case "":
+		archivePath = feed.DefaultArchivePath()
+	case "none":
+		archivePath = ""
+	}
+	configOptions = c.options
+
+	var jar http.CookieJar
+	if cookiesPath != "" {
+		jar, err = service.LoadCookies(cookiesPath)
+		if err != nil {
+			log.Printf("Error loading cookies: %v; path: '%v'\n", err, cookiesPath)
+			os.Exit(1)
+		}
+	}
+
+	defaults := job{
+		format:  formatStr,
+		options: userOptions,
+		filters: userFilters,
+	}
+
+	// stdin can be read only once, the second read would silently yield no targets
+	stdinReads := 0
+	for _, target := range targets {
+		if target == "-" {
+			stdinReads++
+		}
+	}
+	if batchFile == "-" {
+		stdinReads++
+	}
+	if stdinReads > 1 {
+		log.Println(`Targets can be read from stdin only once, give either "-" or --batch-file -`)
+		os.Exit(1)
+	}
+
+	jobs := []job{}
+	for _, target := range targets {
+		if target != "-" {
+			jobs = append(jobs, defaults.withTarget(target))
+			continue
+		}
+
+		batchJobs, err := readBatch(os.Stdin, defaults)
+		if err != nil {
+			log.Printf("Error reading targets from stdin: %v\n", err)
+			os.Exit(1)
+		}
+		jobs = append(jobs, batchJobs...)
+	}
+
+	if batchFile != "" {
+		var (
+			batchReader io.Reader = os.Stdin
+			file        *os.File
+			err         error
+		)
+		if batchFile != "-" {
+			file, err = os.Open(batchFile)
+			if err != nil {
+				log.Printf("Error opening batch file: %v\n", err)
+				os.Exit(1)
+			}
+			batchReader = file
+		}
+
+		batchJobs, err := readBatch(batchReader, defaults)
+		// closed right away, deferring it would be skipped by os.Exit
+		if file != nil {
+			file.Close()
+		}
+		if err != nil {
+			log.Printf("Error reading batch file: %v; path: '%v'\n", err, batchFile)
+			os.Exit(1)
+		}
+		jobs = append(jobs, batchJobs...)
+	}
+
+	services := piko.GetAllServicesWith(piko.Settings{
+		Cookies:          jar,
+		FeedArchive:      archivePath,
+		FourchanArchives: c.fourchanArchives,
+	})
+
+	serviceNames := []string{}
+	for _, s := range services {
+		serviceNames = append(serviceNames, serviceName(s))
+	}
+	scopeErrs := validateScopes(mergeStringMaps(configOptions, userOptions), serviceNames)
+	for _, j := range jobs {
+		scopeErrs = append(scopeErrs, validateScopes(j.options, serviceNames)...)
+	}
+	for _, err := range scopeErrs {
+		if !reportedOptionErrors[err.Error()] {
+			reportedOptionErrors[err.Error()] = true
+			log.Printf("Option error: %v\n", err)
+		}
+	}
+	if strictOptions && len(scopeErrs) != 0 {
+		os.Exit(1)
+	}
 
 	// targets = append(targets, "https://boards.4channel.org/adv/thread/20765545/i-want-to-be-the-very-best-like-no-one-ever-was")
 	// targets = append(targets, "https://imgur.com/t/article13/EfY6CxU")
@@ -125,69 +290,165 @@ func main() {
 	// targets = append(targets, "https://twitter.com/deadprogram/status/1090554988768698368")
 	// targets = append(targets, "https://www.facebook.com/groups/veryblessedimages/permalink/478153699389793/")
 
-	for _, target := range targets {
-		if target == "" {
-			log.Println("Target can't be empty")
-			continue
+	errs := make([]error, len(jobs))
+	for index, j := range jobs {
+		errs[index] = handleTarget(services, j)
+
+		if errs[index] == errInvalidOptions {
+			for skipped := index + 1; skipped < len(jobs); skipped++ {
+				errs[skipped] = errors.New("skipped because of invalid options")
+			}
+			break
+		}
+	}
+
+	// nothing to check the options against if there were no items
+	unscopedErrs := []error{}
+	if handledItems != 0 {
+		unscopedErrs = handledOptions.validateUnscoped(configOptions)
+		for _, j := range jobs {
+			unscopedErrs = append(unscopedErrs, handledOptions.unknownUnscoped(j.options)...)
+		}
+	}
+	for _, err := range unscopedErrs {
+		if !reportedOptionErrors[err.Error()] {
+			reportedOptionErrors[err.Error()] = true
+			log.Printf("Option error: %v\n", err)
 		}
+	}
 
-		var foundAnyService bool
-		for _, s := range services {
-			if !s.IsValidTarget(target) {
-				continue
+	// a summary is only useful for more than one target, the error of one was just logged
+	if len(jobs) > 1 {
+		log.Println("Summary:")
+		for index, j := range jobs {
+			if errs[index] != nil {
+				log.Printf("\tFAILED %s: %v\n", j.target, errs[index])
+			} else {
+				log.Printf("\tOK %s\n", j.target)
 			}
+		}
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			os.Exit(1)
+		}
+	}
+	if strictOptions && len(unscopedErrs) != 0 {
+		os.Exit(1)
+	}
+}
+
+// handleTarget downloads all items of the target, returning the first error
+func handleTarget(services []service.Service, j job) error {
+	target := j.target
+	if target == "" {
+		log.Println("Target can't be empty")
+		return errors.New("Target can't be empty")
+	}
+
+	for _, s := range services {
+		if !s.IsValidTarget(target) {
+			continue
+		}
+
+		log.Printf("Found valid service: %s\n", reflect.TypeOf(s).Name())
+		iterator, err := fetchItems(s, target)
+		if err != nil {
+			log.Printf("failed to fetch items: %v; target: %v\n", err, target)
+			return err
+		}
 
-			foundAnyService = true
-			log.Printf("Found valid service: %s\n", reflect.TypeOf(s).Name())
-			iterator, err := s.FetchItems(target)
+		var firstErr error
+		for !iterator.HasEnded() {
+			// some items might still be returned with an error
+			items, err := iterator.Next()
 			if err != nil {
-				log.Printf("failed to fetch items: %v; target: %v\n", err, target)
-				break
+				log.Printf("Iteration error: %v; target: %v\n", err, target)
+				if firstErr == nil {
+					firstErr = err
+				}
 			}
 
-			for !iterator.HasEnded() {
-				items, err := iterator.Next()
-				if err != nil {
-					log.Printf("Iteration error: %v; target: %v\n", err, target)
-					continue
+			for _, item := range items {
+				err := handleItem(s, item, j)
+				if err == errInvalidOptions {
+					return err
 				}
-
-				for _, item := range items {
-					handleItem(s, item)
+				if err != nil && firstErr == nil {
+					firstErr = err
 				}
 			}
-
-			break
-		}
-		if !foundAnyService {
-			log.Printf("Error: Couldn't find a valid service for url '%s'. Your link is probably unsupported.\n", target)
 		}
+
+		return firstErr
+	}
+
+	log.Printf("Error: Couldn't find a valid service for url '%s'. Your link is probably unsupported.\n", target)
+	return errors.New("Couldn't find a valid service")
+}
+
+func fetchItems(s service.Service, target string) (service.ServiceIterator, error) {
+	if !watchMode {
+		return s.FetchItems(target)
 	}
+
+	watcher, ok := s.(service.Watcher)
+	if !ok {
+		log.Printf("Service %s doesn't support watching, fetching once\n", reflect.TypeOf(s).Name())
+		return s.FetchItems(target)
+	}
+
+	return watcher.WatchItems(target)
 }
 
-func handleItem(s service.Service, item service.Item) {
+func handleItem(s service.Service, item service.Item, j job) error {
+	if !matchesFilters(item.Meta, j.filters) {
+		return nil
+	}
+
+	handledItems++
+	handledOptions.add(item.AvailableOptions)
+
 	if discoveryMode {
 		log.Println("Item:\n" + prettyPrintItem(item))
-		return
+		return nil
 	}
 
-	options := mergeStringMaps(item.DefaultOptions, userOptions)
+	given := optionsFor(serviceName(s), configOptions, j.options)
+	optionErrs := validateOptions(item.AvailableOptions, given)
+	for _, err := range optionErrs {
+		report := serviceName(s) + ": " + err.Error()
+		if !reportedOptionErrors[report] {
+			reportedOptionErrors[report] = true
+			log.Printf("Option error(%s): %v\n", reflect.TypeOf(s).Name(), err)
+		}
+	}
+	if strictOptions && len(optionErrs) != 0 {
+		return errInvalidOptions
+	}
+
+	options := mergeStringMaps(item.DefaultOptions, applicableOptions(item.AvailableOptions, given))
+	if duration != "" {
+		options["duration"] = duration
+	}
 
 	reader, err := s.Download(item.Meta, options)
 	if err != nil {
 		log.Printf("Download error: %v, item: %+v\n", err, item)
-		return
+		return err
 	}
 	defer tryClose(reader)
+	download := reader
 
 	if stdoutMode {
-		io.Copy(os.Stdout, reader)
-		return
+		_, err = io.Copy(os.Stdout, reader)
+		return err
 	}
 
 	nameFormat := item.DefaultName
-	if formatStr != "" {
-		nameFormat = strings.Replace(formatStr, "%[default]", item.DefaultName, -1)
+	if j.format != "" {
+		nameFormat = strings.Replace(j.format, "%[default]", item.DefaultName, -1)
 	}
 	name := format(nameFormat, item.Meta)
 
@@ -204,19 +465,39 @@ func handleItem(s service.Service, item service.Item) {
 	err = os.MkdirAll(dir, os.ModePerm)
 	if err != nil {
 		log.Printf("Error creating directory: %v; dir: '%v'\n", err, dir)
-		return
+		return err
 	}
 
 	file, err := os.Create(name)
 	if err != nil {
 		log.Printf("Error creating file: %v, name: %v\n", err, name)
-		return
+		return err
 	}
 	defer file.Close()
 
 	_, err = io.Copy(file, reader)
 	if err != nil {
 		log.Printf("Error copying from source to file: %v, item: %+v", err, item)
-		return
+
+		// incomplete or corrupt files, ex: MD5 mismatch, shouldn't look like finished ones
+		file.Close()
+		if renameErr := os.Rename(name, name+".part"); renameErr != nil {
+			log.Printf("Error renaming the incomplete file: %v, name: %v\n", renameErr, name)
+		}
+		return err
+	}
+
+	// downloads are remembered, ex: by the podcast archive, only once they're on the disk
+	if err := file.Close(); err != nil {
+		log.Printf("Error closing file: %v, name: %v\n", err, name)
+		return err
 	}
+	if committer, ok := download.(service.Committer); ok {
+		if err := committer.Commit(); err != nil {
+			log.Printf("Error committing the download: %v, item: %+v\n", err, item)
+			return err
+		}
+	}
+
+	return nil
 }
@@ -17,7 +17,10 @@
 package main
 
 import (
+	"strings"
 	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
 )
 
 func TestFormat(t *testing.T) {
@@ -45,3 +48,396 @@ func TestFormat(t *testing.T) {
 		})
 	}
 }
+
+func TestMatchesFilters(t *testing.T) {
+	meta := map[string]string{"format": "VBR MP3", "source": "derivative"}
+
+	cases := []struct {
+		name     string
+		filters  map[string][]string
+		expected bool
+	}{
+		{"no filters", map[string][]string{}, true},
+		{"matching", map[string][]string{"format": {"VBR MP3"}}, true},
+		{"not matching", map[string][]string{"format": {"Flac"}}, false},
+		{"one of alternatives", map[string][]string{"format": {"Flac", "VBR MP3"}}, true},
+		{"all keys must match", map[string][]string{"format": {"VBR MP3"}, "source": {"original"}}, false},
+		{"missing key", map[string][]string{"md5": {"abc"}}, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if result := matchesFilters(meta, c.filters); result != c.expected {
+				t.Errorf("matchesFilters error, got: %v, expected: %v\n", result, c.expected)
+			}
+		})
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+	}{
+		{"https://example.com", []string{"https://example.com"}},
+		{"  a \tb  ", []string{"a", "b"}},
+		{`a -f "%[id] - %[title].%[ext]"`, []string{"a", "-f", "%[id] - %[title].%[ext]"}},
+		{`a --filter 'format=VBR MP3'`, []string{"a", "--filter", "format=VBR MP3"}},
+		{`a -o ""`, []string{"a", "-o", ""}},
+	}
+
+	for _, c := range cases {
+		args, err := splitArgs(c.line)
+		if err != nil {
+			t.Errorf("splitArgs error: %v, line: %v", err, c.line)
+			continue
+		}
+		if diff := pretty.Compare(args, c.expected); diff != "" {
+			t.Errorf("splitArgs diff, line: %v\n%s", c.line, diff)
+		}
+	}
+
+	if _, err := splitArgs(`a -f "unterminated`); err == nil {
+		t.Errorf("Expected an error for an unterminated quote")
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	defaults := job{
+		format:  "%[default]",
+		options: map[string]string{"quality": "best"},
+		filters: map[string][]string{"format": {"Flac"}},
+	}
+
+	input := `# comment
+https://a.example
+
+https://b.example -f b/%[default] -o quality=worst --filter "format=VBR MP3"
+  https://c.example https://d.example --option extra=a=b
+`
+
+	jobs, err := readBatch(strings.NewReader(input), defaults)
+	if err != nil {
+		t.Fatalf("readBatch error: %v", err)
+	}
+
+	expected := []job{
+		defaults.withTarget("https://a.example"),
+		{
+			target:  "https://b.example",
+			format:  "b/%[default]",
+			options: map[string]string{"quality": "worst"},
+			filters: map[string][]string{"format": {"VBR MP3"}},
+		},
+		{
+			target:  "https://c.example",
+			format:  "%[default]",
+			options: map[string]string{"quality": "best", "extra": "a=b"},
+			filters: map[string][]string{"format": {"Flac"}},
+		},
+		{
+			target:  "https://d.example",
+			format:  "%[default]",
+			options: map[string]string{"quality": "best", "extra": "a=b"},
+			filters: map[string][]string{"format": {"Flac"}},
+		},
+	}
+
+	if diff := pretty.Compare(jobs, expected); diff != "" {
+		t.Errorf("readBatch diff:\n%s", diff)
+	}
+
+	for _, line := range []string{"-o quality=best", "https://a.example -o quality", "https://a.example --unknown x"} {
+		if _, err := readBatch(strings.NewReader(line), defaults); err == nil {
+			t.Errorf("Expected an error for line: %v", line)
+		}
+	}
+}
+
+func TestParseTOMLConfig(t *testing.T) {
+	input := `# comment
+format = "downloads/%[default]" # trailing comment
+duration = '1h'
+
+[options]
+quality = "best"
+limit = 10
+
+[youtube]
+"onlyAudio" = "yes"
+`
+
+	sections, err := parseTOMLConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseTOMLConfig error: %v", err)
+	}
+
+	expected := map[string]map[string]string{
+		"":        {"format": "downloads/%[default]", "duration": "1h"},
+		"options": {"quality": "best", "limit": "10"},
+		"youtube": {"onlyAudio": "yes"},
+	}
+	if diff := pretty.Compare(sections, expected); diff != "" {
+		t.Errorf("parseTOMLConfig diff:\n%s", diff)
+	}
+
+	for _, line := range []string{"[options", "quality", `format = "unterminated`, "quality = "} {
+		if _, err := parseTOMLConfig(strings.NewReader(line)); err == nil {
+			t.Errorf("Expected an error for line: %v", line)
+		}
+	}
+}
+
+func TestParseJSONConfig(t *testing.T) {
+	input := `{"format": "%[default]", "options": {"quality": "best"}, "youtube": {"onlyAudio": "yes", "retries": 3},
+		"fourchan-archives": {"tg": "https://archive.4plebs.org, https://desuarchive.org", "g": ""}}`
+
+	sections, err := parseJSONConfig(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseJSONConfig error: %v", err)
+	}
+
+	c, err := newConfig(sections)
+	if err != nil {
+		t.Fatalf("newConfig error: %v", err)
+	}
+
+	expected := map[string]string{
+		"quality":           "best",
+		"youtube.onlyAudio": "yes",
+		"youtube.retries":   "3",
+	}
+	if diff := pretty.Compare(c.options, expected); diff != "" {
+		t.Errorf("options diff:\n%s", diff)
+	}
+	if c.format != "%[default]" {
+		t.Errorf("Invalid format: %v", c.format)
+	}
+
+	expectedArchives := map[string][]string{
+		"tg": {"https://archive.4plebs.org", "https://desuarchive.org"},
+		"g":  {},
+	}
+	if diff := pretty.Compare(c.fourchanArchives, expectedArchives); diff != "" {
+		t.Errorf("fourchanArchives diff:\n%s", diff)
+	}
+
+	if _, err := newConfig(map[string]map[string]string{"": {"quality": "best"}}); err == nil {
+		t.Errorf("Expected an error for an unknown top level key")
+	}
+}
+
+func TestOptionsFor(t *testing.T) {
+	configOptions := map[string]string{"quality": "best", "youtube.onlyAudio": "yes", "twitch.quality": "720p60"}
+	userOptions := map[string]string{"quality": "worst", "Youtube.useFfmpeg": "no", "vimeo.quality": "1080p"}
+
+	expected := givenOptions{
+		values: map[string]string{
+			"quality":   "worst",
+			"onlyAudio": "yes",
+			"useFfmpeg": "no",
+		},
+		explicit: map[string]bool{
+			"onlyAudio": true,
+			"useFfmpeg": true,
+		},
+		unscoped: map[string]bool{
+			"quality": true,
+		},
+	}
+
+	given := optionsFor("youtube", configOptions, userOptions)
+	if diff := pretty.Compare(given, expected); diff != "" {
+		t.Errorf("optionsFor diff:\n%s", diff)
+	}
+
+	// scoped config beats global config, global user options beat both
+	given = optionsFor("twitch", configOptions, map[string]string{})
+	if given.values["quality"] != "720p60" {
+		t.Errorf("Expected the scoped config option, got: %v", given.values["quality"])
+	}
+	given = optionsFor("twitch", configOptions, map[string]string{"quality": "best"})
+	if given.values["quality"] != "best" {
+		t.Errorf("Expected the user option, got: %v", given.values["quality"])
+	}
+
+	// unscoped options don't have to be available for every service
+	given = optionsFor("imgur", configOptions, map[string]string{"quality": "best"})
+	if given.explicit["quality"] || !given.unscoped["quality"] {
+		t.Errorf("Unscoped options shouldn't be explicit")
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	available := map[string][]string{
+		"quality":   {"best", "medium", "worst"},
+		"onlyAudio": {"yes", "no"},
+	}
+
+	cases := []struct {
+		name     string
+		given    givenOptions
+		expected []string
+	}{
+		{
+			"valid",
+			givenOptions{values: map[string]string{"quality": "best"}, explicit: map[string]bool{"quality": true}},
+			[]string{},
+		},
+		{
+			"key typo",
+			givenOptions{values: map[string]string{"qualty": "best"}, explicit: map[string]bool{"qualty": true}},
+			[]string{"unknown option qualty, did you mean quality?"},
+		},
+		{
+			"value typo",
+			givenOptions{values: map[string]string{"quality": "bets"}, explicit: map[string]bool{"quality": true}},
+			[]string{"invalid value bets of option quality, did you mean best?"},
+		},
+		{
+			"unknown value",
+			givenOptions{values: map[string]string{"quality": "ultra"}, explicit: map[string]bool{"quality": true}},
+			[]string{"invalid value ultra of option quality, available: best, medium, worst"},
+		},
+		{
+			"unknown unscoped option",
+			givenOptions{values: map[string]string{"retries": "3"}, explicit: map[string]bool{}, unscoped: map[string]bool{"retries": true}},
+			[]string{},
+		},
+		{
+			"unscoped key typo",
+			givenOptions{values: map[string]string{"qualty": "best"}, explicit: map[string]bool{}, unscoped: map[string]bool{"qualty": true}},
+			[]string{"unknown option qualty, did you mean quality?"},
+		},
+		{
+			"unscoped unknown value",
+			givenOptions{values: map[string]string{"quality": "ultra"}, explicit: map[string]bool{}, unscoped: map[string]bool{"quality": true}},
+			[]string{"invalid value ultra of option quality, available: best, medium, worst"},
+		},
+		{
+			"unscoped config value",
+			givenOptions{values: map[string]string{"quality": "ultra"}, explicit: map[string]bool{}},
+			[]string{},
+		},
+		{
+			"invalid unscoped value",
+			givenOptions{values: map[string]string{"onlyaudio": "yes", "onlyAudio": "maybe"}, explicit: map[string]bool{"onlyaudio": true}},
+			[]string{"unknown option onlyaudio, did you mean onlyAudio?"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			messages := []string{}
+			for _, err := range validateOptions(available, c.given) {
+				messages = append(messages, err.Error())
+			}
+
+			if diff := pretty.Compare(messages, c.expected); diff != "" {
+				t.Errorf("validateOptions diff:\n%s", diff)
+			}
+		})
+	}
+
+	errs := validateOptions(nil, givenOptions{values: map[string]string{"quality": "best"}, explicit: map[string]bool{"quality": true}})
+	if len(errs) != 1 || errs[0].Error() != "unknown option quality, no options are available" {
+		t.Errorf("Unexpected errors for a service without options: %v", errs)
+	}
+}
+
+func TestUnscopedOptionsOfTwoServices(t *testing.T) {
+	youtubeAvailable := map[string][]string{
+		"quality":   {"best", "worst", "720p"},
+		"onlyAudio": {"yes", "no"},
+	}
+	facebookAvailable := map[string][]string{
+		"quality": {"hd", "sd"},
+	}
+	configOptions := map[string]string{"quality": "best", "onlyAudio": "yes", "facebook.quality": "sd"}
+	userOptions := map[string]string{"retries": "3", "onlyAudio": "maybe"}
+
+	// unscoped config options and options of other services aren't errors of single items,
+	// invalid values of command line ones are
+	youtubeGiven := optionsFor("youtube", configOptions, userOptions)
+	facebookGiven := optionsFor("facebook", map[string]string{"quality": "best"}, userOptions)
+	messages := []string{}
+	for _, err := range validateOptions(youtubeAvailable, youtubeGiven) {
+		messages = append(messages, err.Error())
+	}
+	if diff := pretty.Compare(messages, []string{"invalid value maybe of option onlyAudio, available: yes, no"}); diff != "" {
+		t.Errorf("youtube validateOptions diff:\n%s", diff)
+	}
+	if errs := validateOptions(facebookAvailable, facebookGiven); len(errs) != 0 {
+		t.Errorf("Unexpected facebook errors: %v", errs)
+	}
+	if errs := validateOptions(facebookAvailable, optionsFor("facebook", nil, map[string]string{"qualty": "hd"})); len(errs) != 1 {
+		t.Errorf("Expected a typo error for facebook, got: %v", errs)
+	}
+
+	// quality=best only applies to youtube, facebook uses its scoped option or the default
+	if options := applicableOptions(youtubeAvailable, youtubeGiven); options["quality"] != "best" {
+		t.Errorf("Expected quality best for youtube, got: %v", options)
+	}
+	if options := applicableOptions(facebookAvailable, facebookGiven); options["quality"] != "" {
+		t.Errorf("Expected the default quality for facebook, got: %v", options)
+	}
+	if options := applicableOptions(facebookAvailable, optionsFor("facebook", configOptions, nil)); options["quality"] != "sd" {
+		t.Errorf("Expected the scoped quality for facebook, got: %v", options)
+	}
+
+	run := runOptions{}
+	run.add(youtubeAvailable)
+	run.add(facebookAvailable)
+
+	messages = []string{}
+	for _, err := range run.validateUnscoped(configOptions) {
+		messages = append(messages, err.Error())
+	}
+	for _, err := range run.unknownUnscoped(mergeStringMaps(userOptions, map[string]string{"qualty": "hd"})) {
+		messages = append(messages, err.Error())
+	}
+
+	// values and typos were reported by items
+	expected := []string{
+		"unknown option retries, available: onlyAudio, quality",
+	}
+	if diff := pretty.Compare(messages, expected); diff != "" {
+		t.Errorf("unscoped diff:\n%s", diff)
+	}
+}
+
+func TestValidateScopes(t *testing.T) {
+	options := map[string]string{"quality": "best", "youtube.onlyAudio": "yes", "yotube.quality": "best", "zzz.a": "b"}
+
+	messages := []string{}
+	for _, err := range validateScopes(options, []string{"youtube", "vimeo"}) {
+		messages = append(messages, err.Error())
+	}
+
+	expected := []string{
+		"unknown service yotube of option yotube.quality, did you mean youtube?",
+		"unknown service zzz of option zzz.a, available: youtube, vimeo",
+	}
+	if diff := pretty.Compare(messages, expected); diff != "" {
+		t.Errorf("validateScopes diff:\n%s", diff)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"quality", "quality", 0},
+		{"qualty", "quality", 1},
+		{"kitten", "sitting", 3},
+		{"", "abc", 3},
+	}
+
+	for _, c := range cases {
+		if distance := editDistance(c.a, c.b); distance != c.expected {
+			t.Errorf("editDistance(%q, %q) = %d, expected: %d", c.a, c.b, distance, c.expected)
+		}
+	}
+}
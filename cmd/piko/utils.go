@@ -17,14 +17,189 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/qtils/commandparser"
+	"github.com/mlvzk/qtils/commandparser/commandhelper"
 )
 
+// job is a target with the settings it's downloaded with
+type job struct {
+	target  string
+	format  string
+	options map[string]string
+	filters map[string][]string
+}
+
+func (j job) withTarget(target string) job {
+	j.target = target
+	return j
+}
+
+// targetOptions are the options which lines of a batch file can override
+func targetOptions() []commandhelper.OptionBuilder {
+	return []commandhelper.OptionBuilder{
+		commandhelper.
+			NewOption("format").
+			Alias("f").
+			Description(`File path format, ex: --format %[id].%[ext]. "id" and "ext" are meta tags(see --discover).
+Use %[default] to fill with default format, ex: downloads/%[default]`),
+		commandhelper.
+			NewOption("option").
+			Alias("o").
+			Arrayed().
+			ValidateBind(commandhelper.ValidateKeyValue("=")).
+			Description(`Download options, ex: --option quality=best.
+Prefix with a service name to only apply to that service, ex: --option youtube.onlyAudio=yes`),
+		commandhelper.
+			NewOption("filter").
+			Arrayed().
+			ValidateBind(commandhelper.ValidateKeyValue("=")).
+			Description(`Only download items with matching meta, ex: --filter "format=VBR MP3".
+Values of the same key are alternatives, different keys must all match`),
+	}
+}
+
+// parseOptions puts validated key=value options into options
+func parseOptions(keyValues []string, options map[string]string) {
+	for _, option := range keyValues {
+		keyValue := strings.SplitN(option, "=", 2)
+		key, value := keyValue[0], keyValue[1]
+
+		options[key] = value
+	}
+}
+
+// parseFilters puts validated key=value filters into filters
+func parseFilters(keyValues []string, filters map[string][]string) {
+	for _, filter := range keyValues {
+		keyValue := strings.SplitN(filter, "=", 2)
+		key, value := keyValue[0], keyValue[1]
+
+		filters[key] = append(filters[key], value)
+	}
+}
+
+// readBatch returns a job for every target in reader, one target per line.
+// Blank lines and lines starting with # are skipped,
+// the rest of a line can override format, options and filters of defaults
+func readBatch(reader io.Reader, defaults job) ([]job, error) {
+	jobs := []job{}
+
+	scanner := bufio.NewScanner(reader)
+	// some urls are very long
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		lineJobs, err := parseBatchLine(line, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		jobs = append(jobs, lineJobs...)
+	}
+
+	return jobs, scanner.Err()
+}
+
+func parseBatchLine(line string, defaults job) ([]job, error) {
+	args, err := splitArgs(line)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := commandparser.New()
+	helper := commandhelper.New()
+	parser.AddOption(helper.EatOption(targetOptions()...)...)
+
+	// the parser skips the executable
+	cmd, err := parser.Parse(append([]string{""}, args...))
+	if err != nil {
+		return nil, err
+	}
+	if errs := helper.Verify(cmd.Args, cmd.Arrayed); len(errs) != 0 {
+		return nil, errs[0]
+	}
+	if len(cmd.Positionals) == 0 {
+		return nil, errors.New("missing target")
+	}
+
+	lineDefaults := job{
+		format:  defaults.format,
+		options: mergeStringMaps(defaults.options),
+		filters: map[string][]string{},
+	}
+	if format, ok := cmd.Args["format"]; ok {
+		lineDefaults.format = format
+	}
+	parseOptions(cmd.Arrayed["option"], lineDefaults.options)
+	parseFilters(cmd.Arrayed["filter"], lineDefaults.filters)
+	// filters of the line replace the default filters of the same key
+	for key, values := range defaults.filters {
+		if _, ok := lineDefaults.filters[key]; !ok {
+			lineDefaults.filters[key] = values
+		}
+	}
+
+	jobs := []job{}
+	for _, target := range cmd.Positionals {
+		jobs = append(jobs, lineDefaults.withTarget(target))
+	}
+
+	return jobs, nil
+}
+
+// splitArgs splits line on whitespace, except inside single or double quotes
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+
+	var (
+		current strings.Builder
+		inArg   bool
+		quote   rune
+	)
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			current.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+
+	return args, nil
+}
+
 var formatRegexp = regexp.MustCompile(`%\[[[:alnum:]]*\]`)
 
 func format(formatter string, meta map[string]string) string {
@@ -54,6 +229,109 @@ func mergeStringMaps(maps ...map[string]string) map[string]string {
 	return merged
 }
 
+// matchesFilters reports whether meta has, for every filtered key, one of the wanted values
+func matchesFilters(meta map[string]string, filters map[string][]string) bool {
+	for key, values := range filters {
+		matched := false
+		for _, value := range values {
+			if meta[key] == value {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// serviceName is the lowercase name of the service type, ex: youtube, fourchan
+func serviceName(s service.Service) string {
+	return strings.ToLower(reflect.TypeOf(s).Name())
+}
+
+func containsString(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+// closestString returns the candidate with the lowest case insensitive edit distance to str,
+// if it's close enough to be a typo
+func closestString(str string, candidates []string) (string, bool) {
+	closest := ""
+	closestDistance := -1
+	for _, candidate := range candidates {
+		distance := editDistance(strings.ToLower(str), strings.ToLower(candidate))
+		if closestDistance == -1 || distance < closestDistance {
+			closest = candidate
+			closestDistance = distance
+		}
+	}
+
+	// allow about one typo per 3 characters
+	maxDistance := len([]rune(str))/3 + 1
+	return closest, closestDistance != -1 && closestDistance <= maxDistance
+}
+
+// editDistance is the levenshtein distance between a and b
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	return previous[len(rb)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
+
+func validateDuration(key string) commandhelper.ValidationFunc {
+	return func(value string) error {
+		if value == "" {
+			return nil
+		}
+
+		if _, err := time.ParseDuration(value); err != nil {
+			return commandhelper.NewInvalidValue(key, "value must be a duration, ex: 90s, 1h30m")
+		}
+
+		return nil
+	}
+}
+
 func tryClose(reader interface{}) error {
 	if closer, ok := reader.(io.Closer); ok {
 		return closer.Close()
@@ -0,0 +1,355 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package archiveorg
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/mlvzk/piko/service"
+)
+
+// stringList is a field which is a string or an array of strings
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		return json.Unmarshal(data, (*[]string)(l))
+	}
+
+	var str string
+	if err := json.Unmarshal(data, &str); err != nil {
+		return err
+	}
+	*l = stringList{str}
+	return nil
+}
+
+type file struct {
+	Name   string `json:"name"`
+	Source string `json:"source"`
+	Format string `json:"format"`
+	MD5    string `json:"md5"`
+	Size   string `json:"size"`
+	Title  string `json:"title"`
+	Track  string `json:"track"`
+}
+
+type metadata struct {
+	Metadata struct {
+		Identifier string     `json:"identifier"`
+		Title      stringList `json:"title"`
+		Creator    stringList `json:"creator"`
+		Date       string     `json:"date"`
+		MediaType  string     `json:"mediatype"`
+	} `json:"metadata"`
+	Files []file `json:"files"`
+}
+
+type searchResponse struct {
+	Response struct {
+		NumFound int `json:"numFound"`
+		Docs     []struct {
+			Identifier string `json:"identifier"`
+		} `json:"docs"`
+	} `json:"response"`
+}
+
+type ArchiveOrg struct{}
+type ArchiveOrgIterator struct {
+	baseURL string
+	url     string
+	// page of collection search results, starts at 1
+	page       int
+	collection string
+	end        bool
+}
+
+func New() ArchiveOrg {
+	return ArchiveOrg{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s ArchiveOrg) IsValidTarget(target string) bool {
+	return strings.Contains(target, "archive.org/details/") || strings.Contains(target, "archive.org/download/")
+}
+
+func (s ArchiveOrg) FetchItems(target string) (service.ServiceIterator, error) {
+	return &ArchiveOrgIterator{
+		baseURL: "https://archive.org",
+		url:     target,
+		page:    1,
+	}, nil
+}
+
+func (s ArchiveOrg) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	var body io.ReadCloser = resp.Body
+	if meta["md5"] != "" {
+		body = &verifyingReader{
+			ReadCloser: resp.Body,
+			hash:       md5.New(),
+			expected:   meta["md5"],
+			name:       meta["file"],
+		}
+	}
+
+	if resp.ContentLength == -1 {
+		return body, nil
+	}
+
+	return output{
+		ReadCloser: body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+// verifyingReader fails the last read if the md5 of the file doesn't match
+type verifyingReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected string
+	name     string
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if sum := hex.EncodeToString(r.hash.Sum(nil)); sum != r.expected {
+			return n, fmt.Errorf("MD5 mismatch of %s, expected: %s, got: %s", r.name, r.expected, sum)
+		}
+	}
+
+	return n, err
+}
+
+const pageSize = 50
+
+func (i *ArchiveOrgIterator) Next() ([]service.Item, error) {
+	if i.collection != "" {
+		return i.nextCollectionPage()
+	}
+
+	identifier, fileName, err := parseTarget(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	item, err := i.fetchMetadata(identifier)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if item.Metadata.MediaType == "collection" {
+		i.collection = identifier
+		return i.nextCollectionPage()
+	}
+
+	i.end = true
+	return i.fileItems(item, fileName), nil
+}
+
+func (i ArchiveOrgIterator) HasEnded() bool {
+	return i.end
+}
+
+func (i *ArchiveOrgIterator) nextCollectionPage() ([]service.Item, error) {
+	query := url.Values{}
+	query.Set("q", "collection:"+i.collection)
+	query.Add("fl[]", "identifier")
+	query.Add("sort[]", "identifier asc")
+	query.Set("rows", strconv.Itoa(pageSize))
+	query.Set("page", strconv.Itoa(i.page))
+	query.Set("output", "json")
+
+	search := searchResponse{}
+	if err := i.getJSON("/advancedsearch.php?"+query.Encode(), &search); err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if len(search.Response.Docs) == 0 || i.page*pageSize >= search.Response.NumFound {
+		i.end = true
+	}
+	i.page++
+
+	// keep going on errors, one dark item shouldn't stop the whole collection
+	var firstErr error
+	items := []service.Item{}
+	for _, doc := range search.Response.Docs {
+		item, err := i.fetchMetadata(doc.Identifier)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		items = append(items, i.fileItems(item, "")...)
+	}
+
+	return items, firstErr
+}
+
+func (i *ArchiveOrgIterator) fetchMetadata(identifier string) (metadata, error) {
+	item := metadata{}
+	if err := i.getJSON("/metadata/"+identifier, &item); err != nil {
+		return item, err
+	}
+	// dark or nonexistent items return an empty object
+	if item.Metadata.Identifier == "" {
+		return item, errors.New("Couldn't find the item " + identifier)
+	}
+
+	return item, nil
+}
+
+// fileItems returns an item per file, or only the one named fileName if it's not empty,
+// metadata files generated by archive.org are skipped
+func (i *ArchiveOrgIterator) fileItems(item metadata, fileName string) []service.Item {
+	identifier := item.Metadata.Identifier
+
+	items := []service.Item{}
+	for _, f := range item.Files {
+		if fileName != "" && f.Name != fileName {
+			continue
+		}
+		if fileName == "" && f.Source == "metadata" {
+			continue
+		}
+
+		escapedName := []string{}
+		for _, part := range strings.Split(f.Name, "/") {
+			escapedName = append(escapedName, url.PathEscape(part))
+		}
+
+		ext := path.Ext(f.Name)
+		if len(ext) > 1 {
+			// cut the dot
+			ext = ext[1:]
+		}
+
+		items = append(items, service.Item{
+			Meta: map[string]string{
+				"identifier":  identifier,
+				"title":       first(item.Metadata.Title),
+				"creator":     strings.Join(item.Metadata.Creator, ", "),
+				"date":        item.Metadata.Date,
+				"file":        f.Name,
+				"fileTitle":   f.Title,
+				"track":       f.Track,
+				"format":      f.Format,
+				"source":      f.Source,
+				"md5":         f.MD5,
+				"size":        f.Size,
+				"ext":         ext,
+				"downloadURL": i.baseURL + "/download/" + url.PathEscape(identifier) + "/" + strings.Join(escapedName, "/"),
+			},
+			DefaultName: "%[identifier]/%[file]",
+		})
+	}
+
+	return items
+}
+
+func first(list stringList) string {
+	if len(list) == 0 {
+		return ""
+	}
+
+	return list[0]
+}
+
+// parseTarget returns the identifier and the file name, empty for whole items.
+// Supported urls: archive.org/details/<identifier>[/<file>], archive.org/download/<identifier>[/<file>]
+func parseTarget(target string) (identifier, fileName string, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	pathParts := strings.SplitN(strings.Trim(u.Path, "/"), "/", 3)
+	if len(pathParts) < 2 || (pathParts[0] != "details" && pathParts[0] != "download") || pathParts[1] == "" {
+		return "", "", errors.New("Unsupported archive.org url: " + target)
+	}
+
+	if len(pathParts) == 3 {
+		fileName = pathParts[2]
+	}
+
+	return pathParts[1], fileName, nil
+}
+
+func (i *ArchiveOrgIterator) getJSON(apiPath string, v interface{}) error {
+	u := i.baseURL + apiPath
+
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,213 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package archiveorg
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/metadata/gd1977": `{"metadata":{"identifier":"gd1977","title":"Grateful Dead Live","creator":["Grateful Dead","Band"],"date":"1977-05-08","mediatype":"etree"},
+		"files":[
+			{"name":"gd77-05-08d1t01.flac","source":"original","format":"Flac","md5":"aaa","size":"1000","title":"Minglewood Blues","track":"01"},
+			{"name":"gd77-05-08d1t01.mp3","source":"derivative","format":"VBR MP3","md5":"bbb","size":"100","title":"Minglewood Blues","track":"01"},
+			{"name":"gd1977_meta.xml","source":"metadata","format":"Metadata","md5":"ccc"}
+		]}`,
+	"/metadata/films": `{"metadata":{"identifier":"films","title":"Films","mediatype":"collection"},"files":[]}`,
+	"/metadata/film1": `{"metadata":{"identifier":"film1","title":"Film one","mediatype":"movies"},
+		"files":[{"name":"videos/film one.mp4","source":"derivative","format":"h.264","md5":"ddd","size":"5000"}]}`,
+	"/metadata/dark":             `{}`,
+	"/advancedsearch.php?page=1": `{"response":{"numFound":2,"docs":[{"identifier":"film1"},{"identifier":"dark"}]}}`,
+}
+
+func responseKey(r *http.Request) string {
+	if r.URL.Path == "/advancedsearch.php" {
+		return r.URL.Path + "?page=" + r.URL.Query().Get("page")
+	}
+	return r.URL.Path
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://archive.org/details/gd1977":                       true,
+		"https://archive.org/download/gd1977/gd77-05-08d1t01.flac": true,
+		"archive.org/details/films":                                true,
+		"https://archive.org/search?query=films":                   false,
+		"https://example.com/details/gd1977":                       false,
+	}
+
+	s := New()
+	for target, expected := range tests {
+		if s.IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey)
+	defer ts.Close()
+
+	iterator := &ArchiveOrgIterator{
+		baseURL: ts.URL,
+		url:     "https://archive.org/details/gd1977",
+		page:    1,
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	meta := func(name, fileFormat, source, md5, size string) map[string]string {
+		return map[string]string{
+			"identifier":  "gd1977",
+			"title":       "Grateful Dead Live",
+			"creator":     "Grateful Dead, Band",
+			"date":        "1977-05-08",
+			"file":        name,
+			"fileTitle":   "Minglewood Blues",
+			"track":       "01",
+			"format":      fileFormat,
+			"source":      source,
+			"md5":         md5,
+			"size":        size,
+			"ext":         name[strings.LastIndex(name, ".")+1:],
+			"downloadURL": ts.URL + "/download/gd1977/" + name,
+		}
+	}
+
+	expected := []service.Item{
+		{
+			Meta:        meta("gd77-05-08d1t01.flac", "Flac", "original", "aaa", "1000"),
+			DefaultName: "%[identifier]/%[file]",
+		},
+		{
+			Meta:        meta("gd77-05-08d1t01.mp3", "VBR MP3", "derivative", "bbb", "100"),
+			DefaultName: "%[identifier]/%[file]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextFile(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey)
+	defer ts.Close()
+
+	iterator := &ArchiveOrgIterator{
+		baseURL: ts.URL,
+		url:     "https://archive.org/download/gd1977/gd1977_meta.xml",
+		page:    1,
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	if len(items) != 1 || items[0].Meta["file"] != "gd1977_meta.xml" {
+		t.Errorf("Expected only the requested file, got: %+v", items)
+	}
+}
+
+func TestIteratorNextCollection(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey)
+	defer ts.Close()
+
+	iterator := &ArchiveOrgIterator{
+		baseURL: ts.URL,
+		url:     "https://archive.org/details/films",
+		page:    1,
+	}
+
+	items, err := iterator.Next()
+	if err == nil {
+		t.Errorf("Expected an error for the dark item")
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"identifier":  "film1",
+				"title":       "Film one",
+				"creator":     "",
+				"date":        "",
+				"file":        "videos/film one.mp4",
+				"fileTitle":   "",
+				"track":       "",
+				"format":      "h.264",
+				"source":      "derivative",
+				"md5":         "ddd",
+				"size":        "5000",
+				"ext":         "mp4",
+				"downloadURL": ts.URL + "/download/film1/videos/film%20one.mp4",
+			},
+			DefaultName: "%[identifier]/%[file]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestDownloadVerifiesMD5(t *testing.T) {
+	content := "file content"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, content)
+	}))
+	defer ts.Close()
+
+	sum := md5.Sum([]byte(content))
+	tests := map[string]bool{
+		hex.EncodeToString(sum[:]):         true,
+		"00000000000000000000000000000000": false,
+	}
+
+	s := New()
+	for checksum, valid := range tests {
+		reader, err := s.Download(map[string]string{"downloadURL": ts.URL, "md5": checksum}, nil)
+		if err != nil {
+			t.Fatalf("Download error: %v", err)
+		}
+
+		_, err = ioutil.ReadAll(reader)
+		if (err == nil) != valid {
+			t.Errorf("Unexpected read result, md5: %v, error: %v", checksum, err)
+		}
+	}
+}
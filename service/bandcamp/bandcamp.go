@@ -0,0 +1,283 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package bandcamp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mlvzk/piko/service"
+)
+
+// tralbum is the data-tralbum attribute of track and album pages
+type tralbum struct {
+	Artist   string `json:"artist"`
+	ItemType string `json:"item_type"`
+	ArtID    int    `json:"art_id"`
+	URL      string `json:"url"`
+	Current  struct {
+		Title       string `json:"title"`
+		ReleaseDate string `json:"release_date"`
+	} `json:"current"`
+	AlbumReleaseDate string `json:"album_release_date"`
+	TrackInfo        []struct {
+		ID       int               `json:"id"`
+		Title    string            `json:"title"`
+		TrackNum int               `json:"track_num"`
+		Duration float64           `json:"duration"`
+		File     map[string]string `json:"file"`
+	} `json:"trackinfo"`
+}
+
+// embed is the data-embed attribute, it has the album title on track pages
+type embed struct {
+	AlbumTitle string `json:"album_title"`
+}
+
+type Bandcamp struct{}
+type BandcampIterator struct {
+	url string
+	// release urls of a discography, fetched on the first Next
+	releases []string
+	fetched  bool
+	end      bool
+}
+
+func New() Bandcamp {
+	return Bandcamp{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+// IsValidTarget accepts only bandcamp.com subdomains,
+// artists on custom domains can't be told apart from other sites by the url
+func (s Bandcamp) IsValidTarget(target string) bool {
+	return strings.Contains(target, ".bandcamp.com")
+}
+
+func (s Bandcamp) FetchItems(target string) (service.ServiceIterator, error) {
+	return &BandcampIterator{
+		url: target,
+	}, nil
+}
+
+func (s Bandcamp) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *BandcampIterator) Next() ([]service.Item, error) {
+	if isRelease(i.url) {
+		i.end = true
+		return fetchRelease(i.url)
+	}
+
+	if !i.fetched {
+		i.fetched = true
+
+		releases, err := fetchDiscography(i.url)
+		if err != nil {
+			i.end = true
+			return nil, err
+		}
+		i.releases = releases
+	}
+
+	if len(i.releases) == 0 {
+		i.end = true
+		return []service.Item{}, nil
+	}
+
+	release := i.releases[0]
+	i.releases = i.releases[1:]
+	if len(i.releases) == 0 {
+		i.end = true
+	}
+
+	return fetchRelease(release)
+}
+
+func (i BandcampIterator) HasEnded() bool {
+	return i.end
+}
+
+func isRelease(target string) bool {
+	return strings.Contains(target, "/album/") || strings.Contains(target, "/track/")
+}
+
+func fetchDocument(target string) (*goquery.Document, *url.URL, error) {
+	resp, err := http.Get(target)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, nil, fmt.Errorf("GET %v returned a wrong status code - %v", target, resp.StatusCode)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return doc, resp.Request.URL, nil
+}
+
+// fetchDiscography returns urls of albums and tracks of the artist page
+func fetchDiscography(target string) ([]string, error) {
+	doc, base, err := fetchDocument(target)
+	if err != nil {
+		return nil, err
+	}
+
+	releases := []string{}
+	seen := map[string]bool{}
+	doc.Find(`#music-grid li a[href], .music-grid li a[href]`).Each(func(_ int, sel *goquery.Selection) {
+		href, _ := sel.Attr("href")
+		u, err := base.Parse(href)
+		if err != nil || !isRelease(u.String()) || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+
+		releases = append(releases, u.String())
+	})
+
+	if len(releases) == 0 {
+		return nil, errors.New("Couldn't find any releases on the page")
+	}
+
+	return releases, nil
+}
+
+func fetchRelease(target string) ([]service.Item, error) {
+	doc, _, err := fetchDocument(target)
+	if err != nil {
+		return nil, err
+	}
+
+	tralbumJSON, exists := doc.Find(`[data-tralbum]`).Attr("data-tralbum")
+	if !exists {
+		return nil, errors.New("Couldn't find the data-tralbum json")
+	}
+	album := tralbum{}
+	if err := json.Unmarshal([]byte(tralbumJSON), &album); err != nil {
+		return nil, err
+	}
+
+	albumTitle := album.Current.Title
+	releaseDate := album.AlbumReleaseDate
+	if album.ItemType == "track" {
+		albumEmbed := embed{}
+		json.Unmarshal([]byte(doc.Find(`[data-embed]`).AttrOr("data-embed", "{}")), &albumEmbed)
+		// singles have no album
+		albumTitle = albumEmbed.AlbumTitle
+		if albumTitle == "" {
+			albumTitle = album.Current.Title
+		}
+		if releaseDate == "" {
+			releaseDate = album.Current.ReleaseDate
+		}
+	}
+
+	coverURL := ""
+	if album.ArtID != 0 {
+		coverURL = fmt.Sprintf("https://f4.bcbits.com/img/a%010d_10.jpg", album.ArtID)
+	}
+
+	items := []service.Item{}
+	for _, track := range album.TrackInfo {
+		// unreleased or paid only tracks have no stream
+		streamURL := track.File["mp3-128"]
+		if streamURL == "" {
+			continue
+		}
+
+		// singles have no track number
+		trackNum, defaultName := "", "%[artist]/%[album]/%[title].%[ext]"
+		if track.TrackNum != 0 {
+			trackNum, defaultName = fmt.Sprintf("%02d", track.TrackNum), "%[artist]/%[album]/%[track] - %[title].%[ext]"
+		}
+
+		items = append(items, service.Item{
+			Meta: map[string]string{
+				"id":          strconv.Itoa(track.ID),
+				"title":       track.Title,
+				"track":       trackNum,
+				"album":       albumTitle,
+				"artist":      album.Artist,
+				"date":        formatDate(releaseDate),
+				"duration":    strconv.Itoa(int(track.Duration)),
+				"coverURL":    coverURL,
+				"ext":         "mp3",
+				"downloadURL": streamURL,
+			},
+			DefaultName: defaultName,
+		})
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("Couldn't find any streamable tracks of " + target)
+	}
+
+	return items, nil
+}
+
+// formatDate turns dates like "01 Jan 2019 00:00:00 GMT" into 2019-01-01
+func formatDate(date string) string {
+	t, err := time.Parse("02 Jan 2006 15:04:05 MST", date)
+	if err != nil {
+		return date
+	}
+
+	return t.Format("2006-01-02")
+}
@@ -0,0 +1,187 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package bandcamp
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+func releasePage(tralbum, embed string) string {
+	return fmt.Sprintf(`<html><body><script data-tralbum="%s" data-embed="%s"></script></body></html>`,
+		html.EscapeString(tralbum), html.EscapeString(embed))
+}
+
+var pages = map[string]string{
+	"/music": `<html><body><ol id="music-grid">
+		<li><a href="/album/first-album"><img></a></li>
+		<li><a href="/track/single"><img></a></li>
+		<li><a href="/album/first-album"><p>duplicate link</p></a></li>
+	</ol></body></html>`,
+	"/album/first-album": releasePage(`{"artist":"Some Artist","item_type":"album","art_id":123456,
+		"current":{"title":"First Album"},"album_release_date":"01 Mar 2019 00:00:00 GMT",
+		"trackinfo":[
+			{"id":1,"title":"Opening","track_num":1,"duration":201.5,"file":{"mp3-128":"https://t4.bcbits.com/stream/1"}},
+			{"id":2,"title":"Paid only","track_num":2,"duration":180,"file":null},
+			{"id":3,"title":"Closing","track_num":3,"duration":240,"file":{"mp3-128":"https://t4.bcbits.com/stream/3"}}
+		]}`, `{}`),
+	"/track/single": releasePage(`{"artist":"Some Artist","item_type":"track","art_id":654321,
+		"current":{"title":"Single","release_date":"15 Apr 2019 00:00:00 GMT"},
+		"trackinfo":[{"id":4,"title":"Single","track_num":null,"duration":99,"file":{"mp3-128":"https://t4.bcbits.com/stream/4"}}]}`, `{}`),
+	"/track/closing": releasePage(`{"artist":"Some Artist","item_type":"track","art_id":123456,
+		"current":{"title":"Closing","release_date":"01 Mar 2019 00:00:00 GMT"},
+		"trackinfo":[{"id":3,"title":"Closing","track_num":3,"duration":240,"file":{"mp3-128":"https://t4.bcbits.com/stream/3"}}]}`, `{"album_title":"First Album"}`),
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return testutil.ServeResponsesBy(pages, func(r *http.Request) string {
+		if _, ok := pages[r.URL.Path]; !ok {
+			t.Errorf("Unexpected request: %v", r.URL)
+		}
+
+		return r.URL.Path
+	})
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://someartist.bandcamp.com/album/first-album": true,
+		"https://someartist.bandcamp.com/":                  true,
+		"https://soundcloud.com/someone":                    false,
+	}
+
+	for target, expected := range tests {
+		if (Bandcamp{}).IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	iterator := BandcampIterator{
+		url: ts.URL + "/album/first-album",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "1",
+				"title":       "Opening",
+				"track":       "01",
+				"album":       "First Album",
+				"artist":      "Some Artist",
+				"date":        "2019-03-01",
+				"duration":    "201",
+				"coverURL":    "https://f4.bcbits.com/img/a0000123456_10.jpg",
+				"ext":         "mp3",
+				"downloadURL": "https://t4.bcbits.com/stream/1",
+			},
+			DefaultName: "%[artist]/%[album]/%[track] - %[title].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "3",
+				"title":       "Closing",
+				"track":       "03",
+				"album":       "First Album",
+				"artist":      "Some Artist",
+				"date":        "2019-03-01",
+				"duration":    "240",
+				"coverURL":    "https://f4.bcbits.com/img/a0000123456_10.jpg",
+				"ext":         "mp3",
+				"downloadURL": "https://t4.bcbits.com/stream/3",
+			},
+			DefaultName: "%[artist]/%[album]/%[track] - %[title].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextDiscography(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	iterator := BandcampIterator{
+		url: ts.URL + "/music",
+	}
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		releaseItems, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		items = append(items, releaseItems...)
+	}
+
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, item.Meta["id"]+" "+item.Meta["album"]+" "+item.Meta["date"])
+	}
+
+	expected := []string{"1 First Album 2019-03-01", "3 First Album 2019-03-01", "4 Single 2019-04-15"}
+	if diff := pretty.Compare(ids, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextTrack(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	// tracks of albums keep their number, singles have none
+	expected := map[string]string{
+		"/track/closing": "03 First Album %[artist]/%[album]/%[track] - %[title].%[ext]",
+		"/track/single":  " Single %[artist]/%[album]/%[title].%[ext]",
+	}
+
+	for trackPath, expectedTrack := range expected {
+		iterator := BandcampIterator{
+			url: ts.URL + trackPath,
+		}
+
+		items, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		if len(items) != 1 {
+			t.Fatalf("Expected one item, got: %v", items)
+		}
+
+		if track := items[0].Meta["track"] + " " + items[0].Meta["album"] + " " + items[0].DefaultName; track != expectedTrack {
+			t.Errorf("Unexpected track of %v: %v, expected: %v", trackPath, track, expectedTrack)
+		}
+	}
+}
@@ -39,3 +39,15 @@ type Service interface {
 type Sized interface {
 	Size() uint64
 }
+
+// Watcher is implemented by services which can keep following a target,
+// the iterator returns only new items on every Next until the target ends
+type Watcher interface {
+	WatchItems(target string) (ServiceIterator, error)
+}
+
+// Committer is implemented by downloads which are remembered, ex: to be skipped on re-runs.
+// Commit is called only once the download is saved completely
+type Committer interface {
+	Commit() error
+}
@@ -0,0 +1,90 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LoadCookies reads a cookies.txt file in the netscape format,
+// the one exported by browser extensions
+func LoadCookies(path string) (http.CookieJar, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseCookies(file)
+}
+
+func ParseCookies(reader io.Reader) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = line[len("#HttpOnly_"):]
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		// domain, include subdomains, path, secure, expiration, name, value
+		fields := strings.Split(line, "\t")
+		if len(fields) < 7 {
+			continue
+		}
+
+		cookie := &http.Cookie{
+			Domain:   fields[0],
+			Path:     fields[2],
+			Secure:   fields[3] == "TRUE",
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expiration, err := strconv.ParseInt(fields[4], 10, 64); err == nil && expiration > 0 {
+			cookie.Expires = time.Unix(expiration, 0)
+		}
+
+		jar.SetCookies(&url.URL{
+			Scheme: "https",
+			Host:   strings.TrimPrefix(fields[0], "."),
+		}, []*http.Cookie{cookie})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return jar, nil
+}
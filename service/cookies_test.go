@@ -0,0 +1,57 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseCookies(t *testing.T) {
+	file := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		".instagram.com\tTRUE\t/\tTRUE\t0\tsessionid\tabc123",
+		"#HttpOnly_.facebook.com\tTRUE\t/\tTRUE\t0\tc_user\t1000",
+		"malformed line",
+	}, "\n")
+
+	jar, err := ParseCookies(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("ParseCookies error: %v", err)
+	}
+
+	tests := map[string]string{
+		"https://www.instagram.com/someone/": "sessionid=abc123",
+		"https://www.facebook.com/page":      "c_user=1000",
+		"https://twitter.com/":               "",
+	}
+
+	for target, expected := range tests {
+		u, _ := url.Parse(target)
+
+		var got []string
+		for _, cookie := range jar.Cookies(u) {
+			got = append(got, cookie.String())
+		}
+
+		if strings.Join(got, "; ") != expected {
+			t.Errorf("Wrong cookies for %v, got: %v, expected: %v", target, got, expected)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package dailymotion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/hls"
+)
+
+type videoMetadata struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Duration    float64 `json:"duration"`
+	CreatedTime int64   `json:"created_time"`
+	Owner       struct {
+		Username   string `json:"username"`
+		ScreenName string `json:"screenname"`
+	} `json:"owner"`
+	Qualities map[string][]struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"qualities"`
+	// present instead of the video for private, deleted and geoblocked videos
+	Error *struct {
+		Title string `json:"title"`
+	} `json:"error"`
+}
+
+type Dailymotion struct{}
+type DailymotionIterator struct {
+	baseURL string
+	url     string
+	end     bool
+}
+
+func New() Dailymotion {
+	return Dailymotion{}
+}
+
+func (s Dailymotion) IsValidTarget(target string) bool {
+	return strings.Contains(target, "dailymotion.com/video/") ||
+		strings.Contains(target, "dailymotion.com/embed/video/") ||
+		strings.Contains(target, "dai.ly/")
+}
+
+func (s Dailymotion) FetchItems(target string) (service.ServiceIterator, error) {
+	return &DailymotionIterator{
+		baseURL: "https://www.dailymotion.com",
+		url:     target,
+	}, nil
+}
+
+func (s Dailymotion) Download(meta, options map[string]string) (io.Reader, error) {
+	quality := options["quality"]
+	if quality == "best" {
+		// name of the best quality, resolved in Next
+		quality = meta["_best"]
+	}
+
+	variants := []hls.Variant{}
+	json.Unmarshal([]byte(meta["_hls"]), &variants)
+
+	for _, v := range variants {
+		if qualityName(v) == quality {
+			limit, _ := time.ParseDuration(options["duration"])
+
+			reader, writer := io.Pipe()
+			go hls.Record(v.URL, limit, writer)
+
+			return reader, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Quality %s is not available", quality)
+}
+
+func (i *DailymotionIterator) Next() ([]service.Item, error) {
+	i.end = true
+
+	id, err := parseTarget(i.url)
+	if err != nil {
+		return nil, err
+	}
+
+	metadataURL := i.baseURL + "/player/metadata/video/" + id
+	resp, err := http.Get(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", metadataURL, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := videoMetadata{}
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.Error != nil {
+		return nil, fmt.Errorf("Video %s is unavailable: %s", id, metadata.Error.Title)
+	}
+
+	masterURL := ""
+	for _, stream := range metadata.Qualities["auto"] {
+		if stream.Type == "application/x-mpegURL" {
+			masterURL = stream.URL
+			break
+		}
+	}
+	if masterURL == "" {
+		return nil, errors.New("Couldn't find the HLS playlist of the video " + id)
+	}
+
+	content, err := hls.Fetch(masterURL)
+	if err != nil {
+		return nil, err
+	}
+	allVariants, err := hls.ParseMaster(masterURL, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// every quality is listed once per CDN, keep the first
+	variants := []hls.Variant{}
+	qualities := []string{"best"}
+	for _, v := range allVariants {
+		name := qualityName(v)
+		if contains(qualities, name) {
+			continue
+		}
+
+		variants = append(variants, v)
+		qualities = append(qualities, name)
+	}
+	variantsJSON, _ := json.Marshal(variants)
+
+	owner := metadata.Owner.ScreenName
+	if owner == "" {
+		owner = metadata.Owner.Username
+	}
+
+	createdTime := ""
+	if metadata.CreatedTime != 0 {
+		createdTime = time.Unix(metadata.CreatedTime, 0).UTC().Format("2006-01-02")
+	}
+
+	return []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          id,
+				"title":       metadata.Title,
+				"owner":       owner,
+				"duration":    strconv.Itoa(int(metadata.Duration)),
+				"createdTime": createdTime,
+				"ext":         "mp4",
+				"_best":       qualityName(hls.Best(variants)),
+				"_hls":        string(variantsJSON),
+			},
+			DefaultName: "%[owner]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": qualities,
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}, nil
+}
+
+func (i DailymotionIterator) HasEnded() bool {
+	return i.end
+}
+
+// qualityName is the height of the variant like 720p
+func qualityName(v hls.Variant) string {
+	if parts := strings.Split(v.Resolution, "x"); len(parts) == 2 {
+		return parts[1] + "p"
+	}
+
+	return strconv.Itoa(v.Bandwidth)
+}
+
+func contains(list []string, str string) bool {
+	for _, s := range list {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseTarget returns the video id, supported urls:
+// dailymotion.com/video/<id>, dailymotion.com/embed/video/<id>, dai.ly/<id>
+func parseTarget(target string) (string, error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	id := u.Path[strings.LastIndex(u.Path, "/")+1:]
+	// old urls have the title after the id, ex: x7tgad0_title
+	id = strings.Split(id, "_")[0]
+	if id == "" {
+		return "", errors.New("Couldn't find the video id in " + target)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package dailymotion
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/player/metadata/video/x7tgad0": `{"id":"x7tgad0","title":"Launch","duration":95.4,"created_time":1556668800,
+		"owner":{"username":"spacenews","screenname":"Space News"},
+		"qualities":{"auto":[{"type":"application/x-mpegURL","url":"{{server}}/master.m3u8"}]}}`,
+	"/player/metadata/video/private": `{"error":{"title":"This video is private"}}`,
+	"/master.m3u8": `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=400000,RESOLUTION=640x360,NAME="360"
+/cdn1/360.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=400000,RESOLUTION=640x360,NAME="360"
+/cdn2/360.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,NAME="720"
+/cdn1/720.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,NAME="720"
+/cdn2/720.m3u8
+`,
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://www.dailymotion.com/video/x7tgad0":       true,
+		"https://www.dailymotion.com/embed/video/x7tgad0": true,
+		"https://dai.ly/x7tgad0":                          true,
+		"https://www.dailymotion.com/spacenews":           false,
+		"https://example.com/video/x7tgad0":               false,
+	}
+
+	s := New()
+	for target, expected := range tests {
+		if s.IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := map[string]string{
+		"https://www.dailymotion.com/video/x7tgad0":              "x7tgad0",
+		"www.dailymotion.com/video/x7tgad0_launch-of-a-rocket":   "x7tgad0",
+		"https://www.dailymotion.com/embed/video/x7tgad0?mute=1": "x7tgad0",
+		"https://dai.ly/x7tgad0":                                 "x7tgad0",
+	}
+
+	for target, expected := range tests {
+		id, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget error: %v, target: %v", err, target)
+			continue
+		}
+		if id != expected {
+			t.Errorf("Invalid id, target: %v, got: %v, expected: %v", target, id, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator := &DailymotionIterator{
+		baseURL: ts.URL,
+		url:     "https://www.dailymotion.com/video/x7tgad0",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "x7tgad0",
+				"title":       "Launch",
+				"owner":       "Space News",
+				"duration":    "95",
+				"createdTime": "2019-05-01",
+				"ext":         "mp4",
+				"_best":       "720p",
+				"_hls": fmt.Sprintf(`[{"URL":"%[1]s/cdn1/360.m3u8","Name":"640x360","Resolution":"640x360","Bandwidth":400000},`+
+					`{"URL":"%[1]s/cdn1/720.m3u8","Name":"1280x720","Resolution":"1280x720","Bandwidth":2000000}]`, ts.URL),
+			},
+			DefaultName: "%[owner]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "360p", "720p"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextUnavailable(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator := &DailymotionIterator{
+		baseURL: ts.URL,
+		url:     "https://www.dailymotion.com/video/private",
+	}
+
+	if _, err := iterator.Next(); err == nil || !strings.Contains(err.Error(), "This video is private") {
+		t.Errorf("Expected an unavailable error, got: %v", err)
+	}
+}
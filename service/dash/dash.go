@@ -0,0 +1,265 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package dash
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+)
+
+type Representation struct {
+	ID        string
+	URL       string
+	MimeType  string
+	Codecs    string
+	Bandwidth int
+	Width     int
+	Height    int
+}
+
+func (r Representation) IsVideo() bool {
+	return strings.HasPrefix(r.MimeType, "video/")
+}
+
+func (r Representation) IsAudio() bool {
+	return strings.HasPrefix(r.MimeType, "audio/")
+}
+
+type mpd struct {
+	BaseURL string `xml:"BaseURL"`
+	Periods []struct {
+		BaseURL        string `xml:"BaseURL"`
+		AdaptationSets []struct {
+			BaseURL         string `xml:"BaseURL"`
+			MimeType        string `xml:"mimeType,attr"`
+			ContentType     string `xml:"contentType,attr"`
+			Codecs          string `xml:"codecs,attr"`
+			Representations []struct {
+				ID        string `xml:"id,attr"`
+				BaseURL   string `xml:"BaseURL"`
+				MimeType  string `xml:"mimeType,attr"`
+				Codecs    string `xml:"codecs,attr"`
+				Bandwidth int    `xml:"bandwidth,attr"`
+				Width     int    `xml:"width,attr"`
+				Height    int    `xml:"height,attr"`
+			} `xml:"Representation"`
+		} `xml:"AdaptationSet"`
+	} `xml:"Period"`
+}
+
+func Fetch(manifestURL string) (string, error) {
+	res, err := http.Get(manifestURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("GET %v returned a wrong status code - %v", manifestURL, res.StatusCode)
+	}
+
+	bytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+// Parse returns representations in the order they appear in the manifest,
+// only single file representations like the ones of facebook and reddit are supported,
+// segmented ones without a BaseURL are skipped
+func Parse(manifestURL, content string) ([]Representation, error) {
+	base, err := url.Parse(manifestURL)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := mpd{}
+	if err := xml.Unmarshal([]byte(content), &manifest); err != nil {
+		return nil, err
+	}
+
+	mpdBase, err := resolve(base, manifest.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	representations := []Representation{}
+	for _, period := range manifest.Periods {
+		periodBase, err := resolve(mpdBase, period.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, set := range period.AdaptationSets {
+			setBase, err := resolve(periodBase, set.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, r := range set.Representations {
+				if strings.TrimSpace(r.BaseURL) == "" {
+					continue
+				}
+
+				u, err := resolve(setBase, r.BaseURL)
+				if err != nil {
+					return nil, err
+				}
+
+				representation := Representation{
+					ID:        r.ID,
+					URL:       u.String(),
+					MimeType:  r.MimeType,
+					Codecs:    r.Codecs,
+					Bandwidth: r.Bandwidth,
+					Width:     r.Width,
+					Height:    r.Height,
+				}
+				// attributes missing in the representation are inherited from the set
+				if representation.MimeType == "" {
+					representation.MimeType = set.MimeType
+				}
+				if representation.MimeType == "" && set.ContentType != "" {
+					representation.MimeType = set.ContentType + "/mp4"
+				}
+				if representation.Codecs == "" {
+					representation.Codecs = set.Codecs
+				}
+
+				representations = append(representations, representation)
+			}
+		}
+	}
+
+	if len(representations) == 0 {
+		return nil, errors.New("Couldn't find any representations in the manifest")
+	}
+
+	return representations, nil
+}
+
+func BestVideo(representations []Representation) (Representation, bool) {
+	return find(representations, Representation.IsVideo, true)
+}
+
+func WorstVideo(representations []Representation) (Representation, bool) {
+	return find(representations, Representation.IsVideo, false)
+}
+
+func BestAudio(representations []Representation) (Representation, bool) {
+	return find(representations, Representation.IsAudio, true)
+}
+
+func find(representations []Representation, filter func(Representation) bool, best bool) (Representation, bool) {
+	var (
+		found Representation
+		ok    bool
+	)
+
+	for _, r := range representations {
+		if !filter(r) {
+			continue
+		}
+
+		if !ok || (best && r.Bandwidth > found.Bandwidth) || (!best && r.Bandwidth < found.Bandwidth) {
+			found, ok = r, true
+		}
+	}
+
+	return found, ok
+}
+
+// Merge muxes the video and audio representations into a fragmented mp4 with ffmpeg,
+// the returned reader is ready before ffmpeg finishes downloading
+func Merge(videoURL, audioURL string) (io.Reader, error) {
+	return merge(videoURL, audioURL, nil, func() {})
+}
+
+// MergeStream is like Merge, but the video is read from a stream instead of an url,
+// audio can be a local file. The video is closed and cleanup is called once ffmpeg quits,
+// or right away if it couldn't be started
+func MergeStream(video io.ReadCloser, audio string, cleanup func()) (io.Reader, error) {
+	return merge("-", audio, video, func() {
+		// stops the writer of the video if ffmpeg quit before reading all of it
+		video.Close()
+		cleanup()
+	})
+}
+
+func merge(videoInput, audioInput string, stdin io.Reader, done func()) (io.Reader, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		done()
+		return nil, errors.New("ffmpeg is required to merge separate audio and video")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-loglevel", "error",
+		"-i", videoInput,
+		"-i", audioInput,
+		"-map", "0:v", "-map", "1:a",
+		"-c", "copy",
+		// fragmented, because stdout isn't seekable
+		"-movflags", "frag_keyframe+empty_moov",
+		"-f", "mp4", "-")
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+
+	reader, writer := io.Pipe()
+	cmd.Stdout = writer
+	go func() {
+		err := cmd.Run()
+		cancel()
+		done()
+		writer.CloseWithError(err)
+	}()
+
+	return mergeOutput{
+		PipeReader: reader,
+		cancel:     cancel,
+	}, nil
+}
+
+// mergeOutput kills ffmpeg when it's closed before the end
+type mergeOutput struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (o mergeOutput) Close() error {
+	o.cancel()
+	return o.PipeReader.Close()
+}
+
+func resolve(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base, nil
+	}
+
+	return base.Parse(ref)
+}
@@ -0,0 +1,95 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package dash
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParse(t *testing.T) {
+	content := `<?xml version="1.0" encoding="UTF-8"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" mediaPresentationDuration="PT12.5S" type="static">
+  <Period duration="PT12.5S">
+    <AdaptationSet contentType="video" segmentAlignment="true">
+      <Representation id="VIDEO-1" bandwidth="2400000" width="1280" height="720" codecs="avc1.4d401f" mimeType="video/mp4">
+        <BaseURL>DASH_720.mp4</BaseURL>
+        <SegmentBase indexRange="800-871"><Initialization range="0-799"/></SegmentBase>
+      </Representation>
+      <Representation id="VIDEO-2" bandwidth="600000" width="480" height="270" codecs="avc1.4d401e" mimeType="video/mp4">
+        <BaseURL>https://cdn.example.com/v/DASH_240.mp4?sig=abc</BaseURL>
+      </Representation>
+      <Representation id="VIDEO-3" bandwidth="100000" width="240" height="136" mimeType="video/mp4">
+        <SegmentTemplate media="segment_$Number$.m4s"/>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet contentType="audio" codecs="mp4a.40.2">
+      <Representation id="AUDIO-1" bandwidth="128000">
+        <BaseURL>audio</BaseURL>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+
+	representations, err := Parse("https://v.example.com/abc/DASHPlaylist.mpd?a=1", content)
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	expected := []Representation{
+		{
+			ID:        "VIDEO-1",
+			URL:       "https://v.example.com/abc/DASH_720.mp4",
+			MimeType:  "video/mp4",
+			Codecs:    "avc1.4d401f",
+			Bandwidth: 2400000,
+			Width:     1280,
+			Height:    720,
+		},
+		{
+			ID:        "VIDEO-2",
+			URL:       "https://cdn.example.com/v/DASH_240.mp4?sig=abc",
+			MimeType:  "video/mp4",
+			Codecs:    "avc1.4d401e",
+			Bandwidth: 600000,
+			Width:     480,
+			Height:    270,
+		},
+		{
+			ID:        "AUDIO-1",
+			URL:       "https://v.example.com/abc/audio",
+			MimeType:  "audio/mp4",
+			Codecs:    "mp4a.40.2",
+			Bandwidth: 128000,
+		},
+	}
+
+	if diff := pretty.Compare(representations, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+
+	if best, _ := BestVideo(representations); best.ID != "VIDEO-1" {
+		t.Errorf("Wrong best video: %v", best.ID)
+	}
+	if worst, _ := WorstVideo(representations); worst.ID != "VIDEO-2" {
+		t.Errorf("Wrong worst video: %v", worst.ID)
+	}
+	if audio, _ := BestAudio(representations); audio.ID != "AUDIO-1" {
+		t.Errorf("Wrong best audio: %v", audio.ID)
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package deviantart
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mlvzk/piko/service"
+)
+
+type deviation struct {
+	DeviationID   int    `json:"deviationId"`
+	Title         string `json:"title"`
+	PublishedTime string `json:"publishedTime"`
+	Author        struct {
+		Username string `json:"username"`
+	} `json:"author"`
+	Media struct {
+		BaseURI    string   `json:"baseUri"`
+		PrettyName string   `json:"prettyName"`
+		Token      []string `json:"token"`
+		Types      []struct {
+			T string `json:"t"`
+			C string `json:"c"`
+		} `json:"types"`
+	} `json:"media"`
+	// only in extended_fetch
+	Extended struct {
+		Tags []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+		// the original file, only if the artist allows downloads
+		Download struct {
+			URL string `json:"url"`
+		} `json:"download"`
+	} `json:"extended"`
+}
+
+type galleryContents struct {
+	HasMore    bool `json:"hasMore"`
+	NextOffset int  `json:"nextOffset"`
+	Results    []struct {
+		Deviation deviation `json:"deviation"`
+	} `json:"results"`
+}
+
+const galleryLimit = 24
+
+type DeviantArt struct{}
+type DeviantArtIterator struct {
+	baseURL string
+	url     string
+	offset  int
+	end     bool
+}
+
+func New() DeviantArt {
+	return DeviantArt{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s DeviantArt) IsValidTarget(target string) bool {
+	return strings.Contains(target, "deviantart.com/")
+}
+
+func (s DeviantArt) FetchItems(target string) (service.ServiceIterator, error) {
+	return &DeviantArtIterator{
+		baseURL: "https://www.deviantart.com",
+		url:     target,
+	}, nil
+}
+
+func (s DeviantArt) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *DeviantArtIterator) Next() ([]service.Item, error) {
+	kind, user, id, err := parseTarget(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if kind == "deviation" {
+		i.end = true
+
+		item, err := i.deviationItem(user, id)
+		if err != nil {
+			return nil, err
+		}
+		item.Meta["index"] = "0"
+
+		return []service.Item{item}, nil
+	}
+
+	query := url.Values{}
+	query.Set("username", user)
+	query.Set("offset", strconv.Itoa(i.offset))
+	query.Set("limit", strconv.Itoa(galleryLimit))
+	if id == "" {
+		query.Set("all_folder", "true")
+	} else {
+		query.Set("folderid", id)
+	}
+
+	contents := galleryContents{}
+	if err := i.getJSON("/_napi/da-user-profile/api/gallery/contents?"+query.Encode(), &contents); err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	offset := i.offset
+	i.offset = contents.NextOffset
+	if !contents.HasMore {
+		i.end = true
+	}
+
+	// keep going on errors, one removed deviation shouldn't stop the whole gallery
+	var firstErr error
+	items := []service.Item{}
+	for index, result := range contents.Results {
+		item, err := i.deviationItem(user, strconv.Itoa(result.Deviation.DeviationID))
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		item.Meta["index"] = strconv.Itoa(offset + index)
+
+		items = append(items, item)
+	}
+
+	return items, firstErr
+}
+
+func (i DeviantArtIterator) HasEnded() bool {
+	return i.end
+}
+
+func (i *DeviantArtIterator) deviationItem(user, id string) (service.Item, error) {
+	query := url.Values{}
+	query.Set("deviationid", id)
+	query.Set("username", user)
+	query.Set("type", "art")
+
+	response := struct {
+		Deviation deviation `json:"deviation"`
+	}{}
+	if err := i.getJSON("/_napi/shared_api/deviation/extended_fetch?"+query.Encode(), &response); err != nil {
+		return service.Item{}, err
+	}
+	d := response.Deviation
+
+	downloadURL := d.Extended.Download.URL
+	if downloadURL == "" {
+		downloadURL = fullviewURL(d)
+	}
+	if downloadURL == "" {
+		return service.Item{}, errors.New("Couldn't find the image of the deviation " + id)
+	}
+
+	u, err := url.Parse(downloadURL)
+	if err != nil {
+		return service.Item{}, err
+	}
+	ext := path.Ext(u.Path)
+	if len(ext) > 1 {
+		// cut the dot
+		ext = ext[1:]
+	}
+
+	tags := []string{}
+	for _, tag := range d.Extended.Tags {
+		tags = append(tags, tag.Name)
+	}
+
+	return service.Item{
+		Meta: map[string]string{
+			"id":          id,
+			"title":       d.Title,
+			"artist":      d.Author.Username,
+			"tags":        strings.Join(tags, ", "),
+			"date":        strings.Split(d.PublishedTime, "T")[0],
+			"ext":         ext,
+			"downloadURL": downloadURL,
+		},
+		DefaultName: "%[artist]-%[title]-%[id].%[ext]",
+	}, nil
+}
+
+// fullviewURL returns the url of the largest preview,
+// types without the "c" template are served from the base uri
+func fullviewURL(d deviation) string {
+	if d.Media.BaseURI == "" {
+		return ""
+	}
+
+	fullview := d.Media.BaseURI
+	for _, t := range d.Media.Types {
+		if t.T == "fullview" && t.C != "" {
+			fullview += "/" + strings.TrimPrefix(strings.Replace(t.C, "<prettyName>", d.Media.PrettyName, -1), "/")
+			break
+		}
+	}
+
+	if len(d.Media.Token) > 0 {
+		fullview += "?token=" + d.Media.Token[0]
+	}
+
+	return fullview
+}
+
+func (i *DeviantArtIterator) getJSON(apiPath string, v interface{}) error {
+	u := i.baseURL + apiPath
+
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+var deviationIDRegexp = regexp.MustCompile(`-(\d+)$`)
+
+// parseTarget returns kind of the target, the user and the id, kinds are:
+// deviation - deviantart.com/<user>/art/<slug>-<id>
+// gallery - deviantart.com/<user>/gallery/<folder id>[/<name>], deviantart.com/<user>/gallery[/all], id is the folder id, empty for all
+func parseTarget(target string) (kind, user, id string, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] == "" {
+		return "", "", "", errors.New("Unsupported deviantart url: " + target)
+	}
+	user = pathParts[0]
+
+	switch pathParts[1] {
+	case "art":
+		if len(pathParts) >= 3 {
+			if matches := deviationIDRegexp.FindStringSubmatch(pathParts[2]); len(matches) == 2 {
+				return "deviation", user, matches[1], nil
+			}
+		}
+	case "gallery":
+		if len(pathParts) == 2 || pathParts[2] == "all" {
+			return "gallery", user, "", nil
+		}
+		if _, err := strconv.Atoi(pathParts[2]); err == nil {
+			return "gallery", user, pathParts[2], nil
+		}
+	}
+
+	return "", "", "", errors.New("Unsupported deviantart url: " + target)
+}
@@ -0,0 +1,152 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package deviantart
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+// by deviation id for extended_fetch, by offset for gallery contents
+var responses = map[string]string{
+	"deviation:801": `{"deviation":{"deviationId":801,"title":"Dragon","publishedTime":"2019-06-20T10:00:00-0700",
+		"author":{"username":"artist"},
+		"media":{"baseUri":"https://images-wixmp.example/f/abc/dragon.jpg","prettyName":"dragon_by_artist",
+			"token":["tkn"],"types":[{"t":"preview","c":"/v1/fit/w_300,h_300/<prettyName>-300w.jpg"},{"t":"fullview","c":"/v1/fill/w_1024,h_768,q_75/<prettyName>-fullview.jpg"}]},
+		"extended":{"tags":[{"name":"dragon"},{"name":"fantasy"}]}}}`,
+	"deviation:802": `{"deviation":{"deviationId":802,"title":"Castle","publishedTime":"2019-06-21T10:00:00-0700",
+		"author":{"username":"artist"},
+		"media":{"baseUri":"https://images-wixmp.example/f/abc/castle.png","prettyName":"castle_by_artist","types":[{"t":"fullview"}]},
+		"extended":{"tags":[],"download":{"url":"https://www.deviantart.com/download/802/castle_by_artist.png?token=dl"}}}}`,
+	"gallery:0": `{"hasMore":true,"nextOffset":2,"results":[{"deviation":{"deviationId":801}},{"deviation":{"deviationId":803}}]}`,
+	"gallery:2": `{"hasMore":false,"nextOffset":null,"results":[{"deviation":{"deviationId":802}}]}`,
+}
+
+func responseKey(t *testing.T) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		query := r.URL.Query()
+
+		switch r.URL.Path {
+		case "/_napi/shared_api/deviation/extended_fetch":
+			return "deviation:" + query.Get("deviationid")
+		case "/_napi/da-user-profile/api/gallery/contents":
+			if query.Get("folderid") != "123" {
+				t.Errorf("Wrong folder id: %v", query.Get("folderid"))
+			}
+			return "gallery:" + query.Get("offset")
+		}
+
+		return ""
+	}
+}
+func TestParseTarget(t *testing.T) {
+	tests := map[string][3]string{
+		"https://www.deviantart.com/artist/art/Dragon-801":         {"deviation", "artist", "801"},
+		"www.deviantart.com/artist/art/Red-Dragon-2-801?q=1":       {"deviation", "artist", "801"},
+		"https://www.deviantart.com/artist/gallery/123/landscapes": {"gallery", "artist", "123"},
+		"https://www.deviantart.com/artist/gallery/all":            {"gallery", "artist", ""},
+		"https://www.deviantart.com/artist/gallery":                {"gallery", "artist", ""},
+	}
+
+	for target, expected := range tests {
+		kind, user, id, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget error: %v, target: %v", err, target)
+			continue
+		}
+		if result := [3]string{kind, user, id}; result != expected {
+			t.Errorf("Invalid result, target: %v, got: %v, expected: %v", target, result, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+	defer ts.Close()
+
+	iterator := &DeviantArtIterator{
+		baseURL: ts.URL,
+		url:     "https://www.deviantart.com/artist/art/Dragon-801",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "801",
+				"title":       "Dragon",
+				"artist":      "artist",
+				"tags":        "dragon, fantasy",
+				"date":        "2019-06-20",
+				"index":       "0",
+				"ext":         "jpg",
+				"downloadURL": "https://images-wixmp.example/f/abc/dragon.jpg/v1/fill/w_1024,h_768,q_75/dragon_by_artist-fullview.jpg?token=tkn",
+			},
+			DefaultName: "%[artist]-%[title]-%[id].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextGallery(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+	defer ts.Close()
+
+	iterator := &DeviantArtIterator{
+		baseURL: ts.URL,
+		url:     "https://www.deviantart.com/artist/gallery/123/landscapes",
+	}
+
+	items, err := iterator.Next()
+	if err == nil {
+		t.Errorf("Expected an error for the removed deviation")
+	}
+	if iterator.HasEnded() {
+		t.Fatalf("Iterator shouldn't have ended after the first page")
+	}
+	if len(items) != 1 || items[0].Meta["id"] != "801" || items[0].Meta["index"] != "0" {
+		t.Errorf("Unexpected first page: %+v", items)
+	}
+
+	items, err = iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	// the original download is preferred over the preview
+	if len(items) != 1 || items[0].Meta["index"] != "2" || items[0].Meta["ext"] != "png" ||
+		items[0].Meta["downloadURL"] != "https://www.deviantart.com/download/802/castle_by_artist.png?token=dl" {
+		t.Errorf("Unexpected second page: %+v", items)
+	}
+}
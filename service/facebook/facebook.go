@@ -18,18 +18,23 @@ package facebook
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/dash"
+	"github.com/mlvzk/piko/service/generic"
 )
 
 type output struct {
@@ -41,14 +46,33 @@ func (o output) Size() uint64 {
 	return o.length
 }
 
-type Facebook struct{}
+type Facebook struct {
+	// client of requests to facebook pages, with cookies of the session if logged in
+	client *http.Client
+}
 type FacebookIterator struct {
-	url string
-	end bool
+	url       string
+	end       bool
+	mbasicURL string
+	client    *http.Client
+	// page crawling state
+	nextURL string
+	author  string
+	seen    map[string]bool
 }
 
 func New() Facebook {
-	return Facebook{}
+	return Facebook{
+		client: http.DefaultClient,
+	}
+}
+
+// NewWithCookies sends cookies of jar with requests to facebook,
+// for private profiles
+func NewWithCookies(jar http.CookieJar) Facebook {
+	return Facebook{
+		client: &http.Client{Jar: jar},
+	}
 }
 
 func (s Facebook) IsValidTarget(target string) bool {
@@ -57,37 +81,112 @@ func (s Facebook) IsValidTarget(target string) bool {
 
 func (s Facebook) FetchItems(target string) (service.ServiceIterator, error) {
 	return &FacebookIterator{
-		url: target,
+		url:       target,
+		mbasicURL: "https://mbasic.facebook.com",
+		client:    s.client,
 	}, nil
 }
 
 func (s Facebook) Download(meta, options map[string]string) (io.Reader, error) {
-	downloadURL, hasDownloadURL := meta["downloadURL"]
-	if !hasDownloadURL {
+	if meta["type"] == "video" && meta["downloadURL"] == "" {
+		return downloadVideo(meta, options["quality"])
+	}
+
+	if downloadURL, hasDownloadURL := meta["downloadURL"]; hasDownloadURL {
+		return download(downloadURL)
+	}
+
+	photoURL, hasPhotoURL := meta["_photoURL"]
+	if !hasPhotoURL {
 		return nil, errors.New("Missing meta downloadURL")
 	}
 
+	// photos of pages are resolved here to avoid a request per photo in Next
+	res, err := downloadFullSize(s.client, photoURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return sizedBody(res), nil
+}
+
+func download(downloadURL string) (io.Reader, error) {
 	res, err := http.Get(downloadURL)
 	if err != nil {
 		return nil, err
 	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, res.StatusCode)
+	}
 
+	return sizedBody(res), nil
+}
+
+func sizedBody(res *http.Response) io.Reader {
 	if res.ContentLength == -1 {
-		return res.Body, nil
+		return res.Body
 	}
 
 	return output{
 		ReadCloser: res.Body,
 		length:     uint64(res.ContentLength),
-	}, nil
+	}
+}
+
+// downloadVideo picks the progressive source or DASH representations for quality,
+// DASH video has no audio, so it's merged with the best audio representation
+func downloadVideo(meta map[string]string, quality string) (io.Reader, error) {
+	sources := map[string]string{}
+	json.Unmarshal([]byte(meta["_sources"]), &sources)
+	representations := []dash.Representation{}
+	json.Unmarshal([]byte(meta["_dash"]), &representations)
+
+	if source, hasSource := sources[quality]; hasSource {
+		return download(source)
+	}
+
+	var (
+		video dash.Representation
+		found bool
+	)
+	switch quality {
+	case "hd":
+		video, found = dash.BestVideo(representations)
+	case "sd":
+		video, found = dash.WorstVideo(representations)
+	default:
+		for _, r := range representations {
+			if r.IsVideo() && dashQualityName(r) == quality {
+				video, found = r, true
+			}
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("Quality %s is not available", quality)
+	}
+
+	audio, hasAudio := dash.BestAudio(representations)
+	if !hasAudio {
+		return download(video.URL)
+	}
+
+	return dash.Merge(video.URL, audio.URL)
 }
 
-var srcRegexp = regexp.MustCompile(`(sd|hd)_src:"(.+?)"`)
+var (
+	srcRegexp          = regexp.MustCompile(`(sd|hd)_src:"(.+?)"`)
+	dashManifestRegexp = regexp.MustCompile(`dash_manifest:"((?:[^"\\]|\\.)*)"`)
+)
 
 func (i *FacebookIterator) Next() ([]service.Item, error) {
+	if isPageURL(i.url) {
+		return i.nextPage()
+	}
+
 	i.end = true
 
-	resp, err := http.Get(i.url)
+	resp, err := i.client.Get(i.url)
 	if err != nil {
 		return nil, err
 	}
@@ -108,7 +207,7 @@ func (i *FacebookIterator) Next() ([]service.Item, error) {
 
 	title := doc.Find(`title`).Text()
 	description, _ := doc.Find(`meta[name="description"]`).Attr("content")
-	image, hasImage := doc.Find(`meta[property="og:image"]`).Attr("content")
+	og := generic.ParseOpenGraph(doc)
 
 	var author string
 	titleParts := strings.Split(title, " - ")
@@ -116,38 +215,33 @@ func (i *FacebookIterator) Next() ([]service.Item, error) {
 		author = titleParts[len(titleParts)-1]
 	}
 
-	var bestVideo, worstVideo string
-	matches := srcRegexp.FindAllSubmatch(bodyBytes, -1)
-	if matches != nil {
-		for _, match := range matches {
-			if len(match) < 3 {
-				continue
-			}
+	sources := map[string]string{}
+	for _, match := range srcRegexp.FindAllSubmatch(bodyBytes, -1) {
+		sources[string(match[1])] = string(match[2])
+	}
 
-			if string(match[1]) == "hd" {
-				bestVideo = string(match[len(match)-1])
-			} else {
-				worstVideo = string(match[len(match)-1])
-			}
+	representations := []dash.Representation{}
+	if match := dashManifestRegexp.FindSubmatch(bodyBytes); match != nil {
+		// the manifest is embedded as an escaped javascript string
+		manifest := ""
+		if err := json.Unmarshal([]byte(`"`+string(match[1])+`"`), &manifest); err == nil {
+			// a broken manifest shouldn't prevent downloading the progressive sources
+			representations, _ = dash.Parse(i.url, manifest)
 		}
 	}
 
 	items := []service.Item{}
 
-	if hasImage && image != "" {
+	if len(og.Images) > 0 {
+		image := og.Images[0]
 		imageURL, err := url.Parse(image)
 		if err != nil {
 			return nil, err
 		}
-		pathParts := strings.Split(imageURL.Path, "_")
-		id := ""
-		if len(pathParts) > 2 {
-			id = pathParts[2]
-		}
-
 		items = append(items, service.Item{
 			Meta: map[string]string{
-				"id":          id,
+				"id":          mediaID(imageURL),
+				"_key":        path.Base(imageURL.Path),
 				"author":      author,
 				"description": description,
 				"type":        "image",
@@ -158,33 +252,10 @@ func (i *FacebookIterator) Next() ([]service.Item, error) {
 		})
 	}
 
-	if bestVideo != "" || worstVideo != "" {
-		video := bestVideo
-		if video == "" {
-			video = worstVideo
-		}
-
-		videoURL, err := url.Parse(video)
-		if err != nil {
-			return nil, err
-		}
-
-		id := ""
-		if pathParts := strings.Split(videoURL.Path, "_"); len(pathParts) > 2 {
-			id = pathParts[2]
-		}
-
-		items = append(items, service.Item{
-			Meta: map[string]string{
-				"id":          id,
-				"author":      author,
-				"description": description,
-				"type":        "video",
-				"ext":         "mp4",
-				"downloadURL": video,
-			},
-			DefaultName: "%[author]-%[id].%[ext]",
-		})
+	if videoItem, hasVideo := newVideoItem(sources, representations); hasVideo {
+		videoItem.Meta["author"] = author
+		videoItem.Meta["description"] = description
+		items = append(items, videoItem)
 	}
 
 	// don't care if this fails
@@ -225,11 +296,6 @@ func (i *FacebookIterator) Next() ([]service.Item, error) {
 				return
 			}
 
-			id := ""
-			if pathParts := strings.Split(mediaURL.Path, "_"); len(pathParts) > 2 {
-				id = pathParts[2]
-			}
-
 			ext := filepath.Ext(mediaURL.Path)
 			if len(ext) > 0 {
 				// cut the dot
@@ -238,7 +304,8 @@ func (i *FacebookIterator) Next() ([]service.Item, error) {
 
 			items = append(items, service.Item{
 				Meta: map[string]string{
-					"id":          id,
+					"id":          mediaID(mediaURL),
+					"_key":        path.Base(mediaURL.Path),
 					"author":      author,
 					"description": description,
 					"type":        "unknown",
@@ -250,21 +317,106 @@ func (i *FacebookIterator) Next() ([]service.Item, error) {
 		})
 	}()
 
-	uniqueItems := []service.Item{}
-itemIterator:
+	return uniqueItems(items, map[string]bool{}), nil
+}
+
+// uniqueItems drops items of photos and videos already in seen
+func uniqueItems(items []service.Item, seen map[string]bool) []service.Item {
+	unique := []service.Item{}
 	for _, item := range items {
-		for _, uniqueItem := range uniqueItems {
-			if item.Meta["id"] == uniqueItem.Meta["id"] {
-				continue itemIterator
-			}
+		key := item.Meta["_key"]
+		if seen[key] {
+			continue
 		}
+		seen[key] = true
 
-		uniqueItems = append(uniqueItems, item)
+		unique = append(unique, item)
 	}
 
-	return uniqueItems, nil
+	return unique
 }
 
 func (i FacebookIterator) HasEnded() bool {
 	return i.end
 }
+
+func newVideoItem(sources map[string]string, representations []dash.Representation) (service.Item, bool) {
+	qualities := []string{}
+	for _, quality := range []string{"hd", "sd"} {
+		if _, hasSource := sources[quality]; hasSource {
+			qualities = append(qualities, quality)
+		}
+	}
+
+	best, hasDashVideo := dash.BestVideo(representations)
+	if hasDashVideo {
+		for _, quality := range []string{"hd", "sd"} {
+			if _, hasSource := sources[quality]; !hasSource {
+				qualities = append(qualities, quality)
+			}
+		}
+
+		for _, r := range representations {
+			if r.IsVideo() {
+				qualities = appendUnique(qualities, dashQualityName(r))
+			}
+		}
+	}
+
+	if len(qualities) == 0 {
+		return service.Item{}, false
+	}
+
+	videoURL := sources["hd"]
+	if videoURL == "" {
+		videoURL = sources["sd"]
+	}
+	if videoURL == "" {
+		videoURL = best.URL
+	}
+	u, _ := url.Parse(videoURL)
+	if u == nil {
+		u = &url.URL{}
+	}
+
+	sourcesJSON, _ := json.Marshal(sources)
+	representationsJSON, _ := json.Marshal(representations)
+
+	return service.Item{
+		Meta: map[string]string{
+			"id":       mediaID(u),
+			"type":     "video",
+			"ext":      "mp4",
+			"_key":     path.Base(u.Path),
+			"_sources": string(sourcesJSON),
+			"_dash":    string(representationsJSON),
+		},
+		DefaultName: "%[author]-%[id].%[ext]",
+		AvailableOptions: map[string]([]string){
+			"quality": qualities,
+		},
+		DefaultOptions: map[string]string{
+			// progressive sources come first, they don't need merging
+			"quality": qualities[0],
+		},
+	}, true
+}
+
+// dashQualityName names video representations by their height like 720p
+func dashQualityName(r dash.Representation) string {
+	if r.Height == 0 {
+		return r.ID
+	}
+
+	return strconv.Itoa(r.Height) + "p"
+}
+
+func appendUnique(slice []string, value string) []string {
+	for _, v := range slice {
+		if v == value {
+			return slice
+		}
+	}
+
+	return append(slice, value)
+}
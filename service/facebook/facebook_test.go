@@ -17,12 +17,17 @@
 package facebook
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/dash"
 	"github.com/mlvzk/piko/service/testutil"
 )
 
@@ -44,12 +49,33 @@ func TestIsValidTarget(t *testing.T) {
 		}
 	}
 }
+
+func TestIsPageURL(t *testing.T) {
+	tests := map[string]bool{
+		"https://www.facebook.com/Shiba.Zero.Mika":                        true,
+		"https://www.facebook.com/Shiba.Zero.Mika/photos":                 true,
+		"https://www.facebook.com/profile.php?id=100001":                  true,
+		"facebook.com/Shiba.Zero.Mika":                                    true,
+		"https://www.facebook.com/Shiba.Zero.Mika/videos/414355892680582": false,
+		"https://www.facebook.com/photo.php?fbid=1002":                    false,
+		"https://www.facebook.com/groups/somegroup":                       false,
+		"https://www.facebook.com/":                                       false,
+	}
+
+	for target, expected := range tests {
+		if isPageURL(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
 func TestIteratorNext(t *testing.T) {
 	ts := testutil.CacheHttpRequest(t, base, *update)
 	defer ts.Close()
 
 	iterator := FacebookIterator{
-		url: ts.URL + "/Shiba.Zero.Mika/videos/414355892680582",
+		url:    ts.URL + "/Shiba.Zero.Mika/videos/414355892680582",
+		client: http.DefaultClient,
 	}
 
 	items, err := iterator.Next()
@@ -63,10 +89,11 @@ func TestIteratorNext(t *testing.T) {
 
 	for _, item := range items {
 		item.Meta["id"] = "ignore"
-		if !strings.Contains(item.Meta["downloadURL"], "fbcdn.net") {
-			t.Fatalf("Incorrect downloadURL: %s", item.Meta["downloadURL"])
+		item.Meta["_key"] = "ignore"
+		if !strings.Contains(item.Meta["_sources"], "fbcdn.net") {
+			t.Fatalf("Incorrect _sources: %s", item.Meta["_sources"])
 		}
-		item.Meta["downloadURL"] = "ignore"
+		item.Meta["_sources"] = "ignore"
 	}
 
 	expected := []service.Item{
@@ -77,7 +104,173 @@ func TestIteratorNext(t *testing.T) {
 				"description": "早晨啊🌼今早傻波在睡夢中又滾了下床😅之後起身扮作若無其事地再上床睡😂\n#柴犬 #shiba #zeromika #shibazeromika",
 				"ext":         "mp4",
 				"type":        "video",
-				"downloadURL": "ignore",
+				"_key":        "ignore",
+				"_sources":    "ignore",
+				"_dash":       "[]",
+			},
+			DefaultName: "%[author]-%[id].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"hd", "sd"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "hd",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextDash(t *testing.T) {
+	manifest := `<?xml version="1.0"?><MPD xmlns="urn:mpeg:dash:schema:mpd:2011"><Period>` +
+		`<AdaptationSet><Representation id="1v" mimeType="video/mp4" bandwidth="2000000" width="1280" height="720">` +
+		`<BaseURL>https://video.xx.fbcdn.net/v/t39.25447-2/111_222_333_n.mp4?tag=dash_720p</BaseURL></Representation>` +
+		`<Representation id="2v" mimeType="video/mp4" bandwidth="500000" width="640" height="360">` +
+		`<BaseURL>https://video.xx.fbcdn.net/v/t39.25447-2/111_222_444_n.mp4?tag=dash_360p</BaseURL></Representation></AdaptationSet>` +
+		`<AdaptationSet><Representation id="3a" mimeType="audio/mp4" bandwidth="64000">` +
+		`<BaseURL>https://video.xx.fbcdn.net/v/t39.25447-2/111_222_555_n.mp4?tag=dash_audio</BaseURL></Representation></AdaptationSet>` +
+		`</Period></MPD>`
+	escaped, _ := json.Marshal(manifest)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><title>Some video - Some Page</title></head><body>
+			<script>new VideoPlayer({sd_src:"https://video.xx.fbcdn.net/v/t42.9040-2/111_222_333_n.mp4?tag=sd",dash_manifest:%s});</script>
+		</body></html>`, strings.Replace(string(escaped), "/", `\/`, -1))
+	}))
+	defer ts.Close()
+
+	iterator := FacebookIterator{
+		url:    ts.URL + "/SomePage/videos/333",
+		client: http.DefaultClient,
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %v", len(items))
+	}
+
+	representations := []dash.Representation{}
+	if err := json.Unmarshal([]byte(items[0].Meta["_dash"]), &representations); err != nil {
+		t.Fatalf("Invalid _dash meta: %v", err)
+	}
+	if len(representations) != 3 {
+		t.Errorf("Expected 3 representations, got %v", len(representations))
+	}
+	items[0].Meta["_dash"] = "ignore"
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "333",
+				"author":      "Some Page",
+				"description": "",
+				"type":        "video",
+				"ext":         "mp4",
+				"_key":        "111_222_333_n.mp4",
+				"_sources":    `{"sd":"https://video.xx.fbcdn.net/v/t42.9040-2/111_222_333_n.mp4?tag=sd"}`,
+				"_dash":       "ignore",
+			},
+			DefaultName: "%[author]-%[id].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"sd", "hd", "720p", "360p"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "sd",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextPage(t *testing.T) {
+	pages := map[string]string{
+		"/SomePage": `<html><head><title>Some Page</title></head><body>
+			<article data-ft='{"top_level_post_id":"111","page_insights":{"5":{"post_context":{"publish_time":1557000000}}}}'>
+				<a href="/SomePage/photos/a.5/1001/?type=3"><img src="thumb.jpg"></a>
+			</article>
+			<article data-ft='{"top_level_post_id":"222","page_insights":{"5":{"post_context":{"publish_time":1556000000}}}}'>
+				<a href="/video_redirect/?src=https%3A%2F%2Fvideo.xx.fbcdn.net%2Fv%2Ft42.9040-2%2F10000000_2002_3003_n.mp4%3F_nc_cat%3D1"><img src="thumb.jpg"></a>
+			</article>
+			<div id="structured_composer_async_container"><a href="/SomePage?sectionLoadingID=m_timeline_loading_div&amp;cursor=abc">See More Stories</a></div>
+		</body></html>`,
+		"/SomePage?sectionLoadingID=m_timeline_loading_div&cursor=abc": `<html><body>
+			<article data-ft='{"top_level_post_id":"333","page_insights":{"5":{"post_context":{"publish_time":1555000000}}}}'>
+				<a href="/photo.php?fbid=1002&amp;id=5&amp;set=a.5"><img src="thumb.jpg"></a>
+				<a href="/photo.php?fbid=1002&amp;id=5&amp;set=a.5">text link without an image</a>
+				<a href="/SomePage/photos/a.5/1001/?type=3"><img src="thumb.jpg"></a>
+			</article>
+		</body></html>`,
+	}
+
+	ts := testutil.ServeResponsesBy(pages, func(r *http.Request) string {
+		if _, ok := pages[r.URL.RequestURI()]; !ok {
+			t.Errorf("Unexpected request: %v", r.URL.RequestURI())
+		}
+
+		return r.URL.RequestURI()
+	})
+	defer ts.Close()
+
+	iterator := FacebookIterator{
+		url:       "https://www.facebook.com/SomePage",
+		mbasicURL: ts.URL,
+		client:    http.DefaultClient,
+	}
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		pageItems, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		items = append(items, pageItems...)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":        "1001",
+				"_key":      "1001",
+				"author":    "Some Page",
+				"type":      "image",
+				"ext":       "jpg",
+				"permalink": "https://www.facebook.com/111",
+				"timestamp": "1557000000",
+				"_photoURL": ts.URL + "/SomePage/photos/a.5/1001/?type=3",
+			},
+			DefaultName: "%[author]-%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "3003",
+				"_key":        "10000000_2002_3003_n.mp4",
+				"author":      "Some Page",
+				"type":        "video",
+				"ext":         "mp4",
+				"permalink":   "https://www.facebook.com/222",
+				"timestamp":   "1556000000",
+				"downloadURL": "https://video.xx.fbcdn.net/v/t42.9040-2/10000000_2002_3003_n.mp4?_nc_cat=1",
+			},
+			DefaultName: "%[author]-%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":        "1002",
+				"_key":      "1002",
+				"author":    "Some Page",
+				"type":      "image",
+				"ext":       "jpg",
+				"permalink": "https://www.facebook.com/333",
+				"timestamp": "1555000000",
+				"_photoURL": ts.URL + "/photo.php?fbid=1002&id=5&set=a.5",
 			},
 			DefaultName: "%[author]-%[id].%[ext]",
 		},
@@ -0,0 +1,290 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package facebook
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mlvzk/piko/service"
+)
+
+// dataFt is a partial structure of the data-ft attribute of mbasic posts
+type dataFt struct {
+	TopLevelPostID string `json:"top_level_post_id"`
+	PageInsights   map[string]struct {
+		PostContext struct {
+			PublishTime int64 `json:"publish_time"`
+		} `json:"post_context"`
+	} `json:"page_insights"`
+}
+
+// not usernames, a page url has just the username or profile.php?id=
+var reservedPaths = map[string]bool{
+	"groups": true, "watch": true, "photo.php": true, "story.php": true,
+	"permalink.php": true, "video.php": true, "events": true, "pages": true,
+}
+
+func isPageURL(target string) bool {
+	u, err := parseURL(target)
+	if err != nil {
+		return false
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if pathParts[0] == "" || reservedPaths[pathParts[0]] {
+		return false
+	}
+	if pathParts[0] == "profile.php" {
+		return u.Query().Get("id") != "" && len(pathParts) == 1
+	}
+
+	return len(pathParts) == 1 || (len(pathParts) == 2 && pathParts[1] == "photos")
+}
+
+// nextPage crawls one page of the public timeline or the photos tab,
+// mbasic.facebook.com is used because it doesn't need javascript
+func (i *FacebookIterator) nextPage() ([]service.Item, error) {
+	pageURL := i.nextURL
+	if pageURL == "" {
+		u, err := parseURL(i.url)
+		if err != nil {
+			i.end = true
+			return nil, err
+		}
+		pageURL = i.mbasicURL + u.RequestURI()
+	}
+
+	resp, err := i.client.Get(pageURL)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		i.end = true
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", pageURL, resp.StatusCode)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if i.author == "" {
+		i.author = strings.TrimSpace(doc.Find("title").Text())
+	}
+
+	items := []service.Item{}
+	doc.Find(`article[data-ft], div[role="article"][data-ft]`).Each(func(_ int, postSel *goquery.Selection) {
+		ft := dataFt{}
+		json.Unmarshal([]byte(postSel.AttrOr("data-ft", "{}")), &ft)
+
+		var timestamp int64
+		for _, insights := range ft.PageInsights {
+			timestamp = insights.PostContext.PublishTime
+		}
+
+		permalink := ""
+		if ft.TopLevelPostID != "" {
+			permalink = "https://www.facebook.com/" + ft.TopLevelPostID
+		}
+
+		items = append(items, i.mediaItems(postSel, permalink, timestamp)...)
+	})
+
+	// the photos tab has no posts, only links to photos
+	if strings.HasSuffix(strings.Split(pageURL, "?")[0], "/photos") {
+		items = append(items, i.mediaItems(doc.Selection, "", 0)...)
+	}
+
+	i.nextURL = ""
+	doc.Find("a").EachWithBreak(func(_ int, sel *goquery.Selection) bool {
+		switch strings.TrimSpace(sel.Text()) {
+		case "See More Stories", "Show more", "See More Photos", "See More":
+			if href, exists := sel.Attr("href"); exists {
+				i.nextURL = i.absolute(href)
+				return false
+			}
+		}
+
+		return true
+	})
+	if i.nextURL == "" {
+		i.end = true
+	}
+
+	// photos are linked from posts and again from the photos tab
+	if i.seen == nil {
+		i.seen = map[string]bool{}
+	}
+
+	return uniqueItems(items, i.seen), nil
+}
+
+// mediaItems returns photos and videos found in sel
+func (i *FacebookIterator) mediaItems(sel *goquery.Selection, permalink string, timestamp int64) []service.Item {
+	items := []service.Item{}
+
+	sel.Find(`a[href*="/photos/"], a[href^="/photo.php"]`).Each(func(_ int, linkSel *goquery.Selection) {
+		if linkSel.Find("img").Length() == 0 {
+			return
+		}
+
+		photoURL := i.absolute(linkSel.AttrOr("href", ""))
+		u, err := url.Parse(photoURL)
+		if err != nil {
+			return
+		}
+
+		id := u.Query().Get("fbid")
+		if id == "" {
+			id = path.Base(strings.TrimSuffix(u.Path, "/"))
+		}
+
+		itemPermalink := permalink
+		if itemPermalink == "" {
+			itemPermalink = "https://www.facebook.com" + u.Path
+		}
+
+		items = append(items, i.pageItem(map[string]string{
+			"id":        id,
+			"_key":      id,
+			"type":      "image",
+			"ext":       "jpg",
+			"permalink": itemPermalink,
+			"_photoURL": photoURL,
+		}, timestamp))
+	})
+
+	sel.Find(`a[href^="/video_redirect/"]`).Each(func(_ int, linkSel *goquery.Selection) {
+		redirect, err := url.Parse(linkSel.AttrOr("href", ""))
+		if err != nil {
+			return
+		}
+
+		videoURL := redirect.Query().Get("src")
+		u, err := url.Parse(videoURL)
+		if err != nil || videoURL == "" {
+			return
+		}
+
+		items = append(items, i.pageItem(map[string]string{
+			"id":          mediaID(u),
+			"_key":        path.Base(u.Path),
+			"type":        "video",
+			"ext":         "mp4",
+			"permalink":   permalink,
+			"downloadURL": videoURL,
+		}, timestamp))
+	})
+
+	return items
+}
+
+func (i *FacebookIterator) pageItem(meta map[string]string, timestamp int64) service.Item {
+	meta["author"] = i.author
+	meta["timestamp"] = ""
+	if timestamp != 0 {
+		meta["timestamp"] = strconv.FormatInt(timestamp, 10)
+	}
+
+	return service.Item{
+		Meta:        meta,
+		DefaultName: "%[author]-%[id].%[ext]",
+	}
+}
+
+func (i *FacebookIterator) absolute(href string) string {
+	if strings.HasPrefix(href, "/") {
+		return i.mbasicURL + href
+	}
+
+	return href
+}
+
+// downloadFullSize follows the "View Full Size" link of a mbasic photo page
+func downloadFullSize(client *http.Client, photoURL string) (*http.Response, error) {
+	resp, err := client.Get(photoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", photoURL, resp.StatusCode)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	fullSize, exists := doc.Find(`a[href*="view_full_size"]`).Attr("href")
+	if !exists {
+		return nil, errors.New("Couldn't find the full size link of photo " + photoURL)
+	}
+	fullSizeURL, err := resp.Request.URL.Parse(fullSize)
+	if err != nil {
+		return nil, err
+	}
+
+	imageResp, err := client.Get(fullSizeURL.String())
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(imageResp.Header.Get("Content-Type"), "image/") {
+		return imageResp, nil
+	}
+
+	// sometimes the image is behind a meta refresh instead of a redirect
+	defer imageResp.Body.Close()
+	refreshDoc, err := goquery.NewDocumentFromReader(imageResp.Body)
+	if err != nil {
+		return nil, err
+	}
+	refresh := refreshDoc.Find(`meta[http-equiv="refresh"]`).AttrOr("content", "")
+	urlIndex := strings.Index(refresh, "url=")
+	if urlIndex == -1 {
+		return nil, errors.New("Couldn't find the full size image of photo " + photoURL)
+	}
+
+	return client.Get(refresh[urlIndex+len("url="):])
+}
+
+func mediaID(u *url.URL) string {
+	if pathParts := strings.Split(u.Path, "_"); len(pathParts) > 2 {
+		return pathParts[2]
+	}
+
+	return ""
+}
+
+func parseURL(target string) (*url.URL, error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	return url.Parse(target)
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package feed
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultArchivePath is in the user's cache directory,
+// empty if there's none
+func DefaultArchivePath() string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(cacheDir, "piko", "feed-archive.txt")
+}
+
+// loadArchive returns guids of downloaded episodes, one per line in the file
+func loadArchive(archivePath string) (map[string]bool, error) {
+	archived := map[string]bool{}
+	if archivePath == "" {
+		return archived, nil
+	}
+
+	file, err := os.Open(archivePath)
+	if os.IsNotExist(err) {
+		return archived, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		archived[scanner.Text()] = true
+	}
+
+	return archived, scanner.Err()
+}
+
+func addToArchive(archivePath, guid string) error {
+	if archivePath == "" || guid == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(archivePath), os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(archivePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.WriteString(guid + "\n")
+	return err
+}
+
+// archivingReader adds the guid to the archive once the episode is saved, see service.Committer
+type archivingReader struct {
+	io.ReadCloser
+	archivePath string
+	guid        string
+}
+
+func (r *archivingReader) Commit() error {
+	return addToArchive(r.archivePath, r.guid)
+}
+
+type archivingOutput struct {
+	*archivingReader
+	length uint64
+}
+
+func (o archivingOutput) Size() uint64 {
+	return o.length
+}
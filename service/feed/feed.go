@@ -0,0 +1,419 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package feed
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvzk/piko/service"
+)
+
+const atomNS = "http://www.w3.org/2005/Atom"
+
+// text matches elements of every namespace, so itunes:title can be told apart from title
+type text struct {
+	XMLName xml.Name
+	Value   string `xml:",chardata"`
+}
+
+type link struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type mediaContent struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+type rssFeed struct {
+	Channel struct {
+		Titles []text  `xml:"title"`
+		Links  []link  `xml:"http://www.w3.org/2005/Atom link"`
+		Items  []entry `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomFeed struct {
+	Titles  []text  `xml:"title"`
+	Links   []link  `xml:"link"`
+	Entries []entry `xml:"entry"`
+}
+
+// entry is both an rss item and an atom entry
+type entry struct {
+	Titles     []text `xml:"title"`
+	GUID       string `xml:"guid"`
+	ID         string `xml:"id"`
+	PubDate    string `xml:"pubDate"`
+	Published  string `xml:"published"`
+	Updated    string `xml:"updated"`
+	Enclosures []struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Links         []link         `xml:"link"`
+	MediaContents []mediaContent `xml:"http://search.yahoo.com/mrss/ content"`
+	Episode       string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	Season        string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+}
+
+type Feed struct {
+	archivePath string
+	fallback    service.Service
+}
+type FeedIterator struct {
+	url         string
+	archivePath string
+	fallback    service.Service
+	// iterator of the fallback, if the target wasn't a feed
+	delegated service.ServiceIterator
+	// index of the next episode, counted across pages
+	index int
+	end   bool
+}
+
+// New takes the path of the archive of downloaded episodes,
+// an empty path disables skipping of downloaded episodes.
+// Targets which turn out not to be feeds are delegated to fallback, if it's not nil
+func New(archivePath string, fallback service.Service) Feed {
+	return Feed{
+		archivePath: archivePath,
+		fallback:    fallback,
+	}
+}
+
+// IsValidTarget can't look at the document, so it guesses from the url
+func (s Feed) IsValidTarget(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	lowerPath := strings.ToLower(u.Path)
+	switch path.Ext(lowerPath) {
+	case ".rss", ".atom":
+		return true
+	case ".xml":
+		// not sitemaps and other xml documents
+		return strings.Contains(lowerPath, "feed") || strings.Contains(lowerPath, "rss") ||
+			strings.Contains(lowerPath, "atom") || strings.Contains(lowerPath, "podcast")
+	}
+
+	return strings.HasPrefix(u.Host, "feeds.") ||
+		strings.HasSuffix(lowerPath, "/feed") || strings.HasSuffix(lowerPath, "/feed/") ||
+		strings.HasSuffix(lowerPath, "/rss") || strings.HasSuffix(lowerPath, "/rss/")
+}
+
+func (s Feed) FetchItems(target string) (service.ServiceIterator, error) {
+	return &FeedIterator{
+		url:         target,
+		archivePath: s.archivePath,
+		fallback:    s.fallback,
+	}, nil
+}
+
+func (s Feed) Download(meta, options map[string]string) (io.Reader, error) {
+	if meta["_delegate"] == "fallback" && s.fallback != nil {
+		return s.fallback.Download(meta, options)
+	}
+
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	// the episode is archived only once it's saved
+	body := &archivingReader{
+		ReadCloser:  resp.Body,
+		archivePath: s.archivePath,
+		guid:        meta["guid"],
+	}
+
+	if resp.ContentLength == -1 {
+		return body, nil
+	}
+
+	return archivingOutput{
+		archivingReader: body,
+		length:          uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *FeedIterator) Next() ([]service.Item, error) {
+	if i.delegated != nil {
+		return i.nextDelegated()
+	}
+
+	i.end = true
+
+	resp, err := http.Get(i.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", i.url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	root := struct {
+		XMLName xml.Name
+	}{}
+	err = xml.Unmarshal(body, &root)
+	isFeed := err == nil && (root.XMLName.Local == "rss" || root.XMLName.Local == "feed")
+	// IsValidTarget only guesses, so pages which aren't feeds are left to the fallback
+	if !isFeed && i.fallback != nil {
+		i.delegated, err = i.fallback.FetchItems(i.url)
+		if err != nil {
+			return nil, err
+		}
+
+		return i.nextDelegated()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		title   string
+		entries []entry
+		links   []link
+	)
+	switch root.XMLName.Local {
+	case "rss":
+		feed := rssFeed{}
+		if err := xml.Unmarshal(body, &feed); err != nil {
+			return nil, err
+		}
+		title, entries, links = plainText(feed.Channel.Titles), feed.Channel.Items, feed.Channel.Links
+	case "feed":
+		feed := atomFeed{}
+		if err := xml.Unmarshal(body, &feed); err != nil {
+			return nil, err
+		}
+		title, entries, links = plainText(feed.Titles), feed.Entries, feed.Links
+	default:
+		return nil, errors.New("Not an RSS or Atom feed: " + i.url)
+	}
+
+	archived, err := loadArchive(i.archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	base := resp.Request.URL
+	items := []service.Item{}
+	for _, e := range entries {
+		item, hasMedia := i.newItem(base, title, e)
+		if !hasMedia {
+			continue
+		}
+		i.index++
+
+		if archived[item.Meta["guid"]] {
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	// paged feeds, RFC 5005
+	for _, l := range links {
+		if l.Rel != "next" || l.Href == "" {
+			continue
+		}
+
+		next, err := base.Parse(l.Href)
+		if err != nil {
+			return items, err
+		}
+		i.url = next.String()
+		i.end = false
+		break
+	}
+
+	return items, nil
+}
+
+func (i FeedIterator) HasEnded() bool {
+	if i.delegated != nil {
+		return i.delegated.HasEnded()
+	}
+
+	return i.end
+}
+
+func (i *FeedIterator) nextDelegated() ([]service.Item, error) {
+	items, err := i.delegated.Next()
+	for _, item := range items {
+		item.Meta["_delegate"] = "fallback"
+	}
+
+	return items, err
+}
+
+func (i *FeedIterator) newItem(base *url.URL, podcast string, e entry) (service.Item, bool) {
+	mediaURL, mediaType := e.media()
+	if mediaURL == "" {
+		return service.Item{}, false
+	}
+
+	u, err := base.Parse(strings.TrimSpace(mediaURL))
+	if err != nil {
+		return service.Item{}, false
+	}
+
+	guid := strings.TrimSpace(e.GUID)
+	if guid == "" {
+		guid = strings.TrimSpace(e.ID)
+	}
+	if guid == "" {
+		// feeds without guids, the media url is the next best thing
+		guid = u.String()
+	}
+
+	date := e.PubDate
+	if date == "" {
+		date = e.Published
+	}
+	if date == "" {
+		date = e.Updated
+	}
+
+	return service.Item{
+		Meta: map[string]string{
+			"guid":        guid,
+			"title":       plainText(e.Titles),
+			"podcast":     podcast,
+			"pubDate":     formatDate(date),
+			"episode":     strings.TrimSpace(e.Episode),
+			"season":      strings.TrimSpace(e.Season),
+			"index":       strconv.Itoa(i.index),
+			"ext":         extension(u, mediaType),
+			"downloadURL": u.String(),
+		},
+		DefaultName: "%[podcast]/%[pubDate] - %[title].%[ext]",
+	}, true
+}
+
+// media returns the enclosure or, if there's none, the first media:content
+func (e entry) media() (mediaURL, mediaType string) {
+	for _, enclosure := range e.Enclosures {
+		if enclosure.URL != "" {
+			return enclosure.URL, enclosure.Type
+		}
+	}
+
+	for _, l := range e.Links {
+		if l.Rel == "enclosure" && l.Href != "" {
+			return l.Href, l.Type
+		}
+	}
+
+	for _, content := range e.MediaContents {
+		if content.URL != "" {
+			return content.URL, content.Type
+		}
+	}
+
+	return "", ""
+}
+
+// plainText returns the text of the element without a namespace
+func plainText(texts []text) string {
+	for _, t := range texts {
+		if t.XMLName.Space == "" || t.XMLName.Space == atomNS {
+			return strings.TrimSpace(t.Value)
+		}
+	}
+
+	return ""
+}
+
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+}
+
+// formatDate turns dates of rss and atom into 2006-01-02
+func formatDate(date string) string {
+	date = strings.TrimSpace(date)
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, date); err == nil {
+			return t.Format("2006-01-02")
+		}
+	}
+
+	return date
+}
+
+var mimeExtensions = map[string]string{
+	"audio/mpeg":      "mp3",
+	"audio/mp4":       "m4a",
+	"audio/x-m4a":     "m4a",
+	"audio/ogg":       "ogg",
+	"audio/opus":      "opus",
+	"video/mp4":       "mp4",
+	"video/x-m4v":     "m4v",
+	"video/webm":      "webm",
+	"application/pdf": "pdf",
+}
+
+func extension(u *url.URL, mediaType string) string {
+	if ext := path.Ext(u.Path); len(ext) > 1 {
+		// cut the dot
+		return strings.ToLower(ext[1:])
+	}
+
+	if ext, found := mimeExtensions[mediaType]; found {
+		return ext
+	}
+
+	return "mp3"
+}
@@ -0,0 +1,318 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package feed
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var documents = map[string]string{
+	"/podcast.rss": `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:media="http://search.yahoo.com/mrss/">
+<channel>
+	<title>Some Podcast</title>
+	<itunes:title>Some Podcast (itunes)</itunes:title>
+	<atom:link rel="self" href="/podcast.rss"/>
+	<atom:link rel="next" href="/podcast.rss?page=2"/>
+	<item>
+		<title>Episode two</title>
+		<itunes:title>Two</itunes:title>
+		<guid isPermaLink="false">ep-2</guid>
+		<pubDate>Tue, 14 May 2019 10:00:00 +0000</pubDate>
+		<itunes:episode>2</itunes:episode>
+		<itunes:season>1</itunes:season>
+		<enclosure url="/audio/ep2.mp3" length="1000" type="audio/mpeg"/>
+	</item>
+	<item>
+		<title>Announcement without audio</title>
+		<guid>announcement</guid>
+	</item>
+	<item>
+		<title>Episode one</title>
+		<guid>ep-1</guid>
+		<pubDate>Tue, 7 May 2019 10:00:00 GMT</pubDate>
+		<media:content url="https://cdn.example.com/ep1" type="audio/mp4"/>
+	</item>
+</channel>
+</rss>`,
+	"/podcast.rss?page=2": `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0"><channel>
+	<title>Some Podcast</title>
+	<item>
+		<title>Pilot</title>
+		<guid>ep-0</guid>
+		<pubDate>Tue, 30 Apr 2019 10:00:00 +0000</pubDate>
+		<enclosure url="https://cdn.example.com/ep0.mp3" type="audio/mpeg"/>
+	</item>
+</channel></rss>`,
+	"/videos.atom": `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Some Videos</title>
+	<entry>
+		<title>First video</title>
+		<id>tag:example.com,2019:1</id>
+		<published>2019-05-01T12:00:00Z</published>
+		<link rel="alternate" href="https://example.com/1"/>
+		<link rel="enclosure" href="https://cdn.example.com/1.mp4" type="video/mp4"/>
+	</entry>
+</feed>`,
+	"/audio/ep2.mp3": "episode two audio",
+	"/blog/feed/":    `<!DOCTYPE html><html><head><title>Not a feed</title></head><body></body></html>`,
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return testutil.ServeResponsesBy(documents, func(r *http.Request) string {
+		if _, ok := documents[r.URL.RequestURI()]; !ok {
+			t.Errorf("Unexpected request: %v", r.URL)
+		}
+
+		return r.URL.RequestURI()
+	})
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/podcast.rss":             true,
+		"https://feeds.example.com/somepodcast":       true,
+		"https://example.com/blog/feed/":              true,
+		"https://anchor.fm/s/123/podcast/rss":         true,
+		"https://example.com/feed.xml":                true,
+		"https://example.com/sitemap.xml":             false,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ": false,
+	}
+
+	for target, expected := range tests {
+		if (Feed{}).IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	iterator := FeedIterator{
+		url: ts.URL + "/podcast.rss",
+	}
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		pageItems, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		items = append(items, pageItems...)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"guid":        "ep-2",
+				"title":       "Episode two",
+				"podcast":     "Some Podcast",
+				"pubDate":     "2019-05-14",
+				"episode":     "2",
+				"season":      "1",
+				"index":       "0",
+				"ext":         "mp3",
+				"downloadURL": ts.URL + "/audio/ep2.mp3",
+			},
+			DefaultName: "%[podcast]/%[pubDate] - %[title].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"guid":        "ep-1",
+				"title":       "Episode one",
+				"podcast":     "Some Podcast",
+				"pubDate":     "2019-05-07",
+				"episode":     "",
+				"season":      "",
+				"index":       "1",
+				"ext":         "m4a",
+				"downloadURL": "https://cdn.example.com/ep1",
+			},
+			DefaultName: "%[podcast]/%[pubDate] - %[title].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"guid":        "ep-0",
+				"title":       "Pilot",
+				"podcast":     "Some Podcast",
+				"pubDate":     "2019-04-30",
+				"episode":     "",
+				"season":      "",
+				"index":       "2",
+				"ext":         "mp3",
+				"downloadURL": "https://cdn.example.com/ep0.mp3",
+			},
+			DefaultName: "%[podcast]/%[pubDate] - %[title].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextAtom(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	iterator := FeedIterator{
+		url: ts.URL + "/videos.atom",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should end without a next link")
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"guid":        "tag:example.com,2019:1",
+				"title":       "First video",
+				"podcast":     "Some Videos",
+				"pubDate":     "2019-05-01",
+				"episode":     "",
+				"season":      "",
+				"index":       "0",
+				"ext":         "mp4",
+				"downloadURL": "https://cdn.example.com/1.mp4",
+			},
+			DefaultName: "%[podcast]/%[pubDate] - %[title].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "piko-feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archivePath := filepath.Join(dir, "nested", "archive.txt")
+
+	s := New(archivePath, nil)
+	reader, err := s.Download(map[string]string{
+		"guid":        "ep-2",
+		"downloadURL": ts.URL + "/audio/ep2.mp3",
+	}, map[string]string{})
+	if err != nil {
+		t.Fatalf("Download error: %v", err)
+	}
+	if _, err := ioutil.ReadAll(reader); err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+
+	// reading isn't enough, the episode might not have been saved
+	if archived, _ := loadArchive(archivePath); archived["ep-2"] {
+		t.Errorf("Episode archived before it was committed")
+	}
+	if err := reader.(service.Committer).Commit(); err != nil {
+		t.Fatalf("Commit error: %v", err)
+	}
+
+	iterator, _ := s.FetchItems(ts.URL + "/podcast.rss")
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	if len(items) != 1 || items[0].Meta["guid"] != "ep-1" || items[0].Meta["index"] != "1" {
+		t.Errorf("Downloaded episode wasn't skipped: %v", items)
+	}
+}
+
+type fakeFallback struct{}
+
+func (fakeFallback) IsValidTarget(target string) bool {
+	return true
+}
+
+func (fakeFallback) FetchItems(target string) (service.ServiceIterator, error) {
+	return &fakeFallbackIterator{}, nil
+}
+
+func (fakeFallback) Download(meta, options map[string]string) (io.Reader, error) {
+	return strings.NewReader("from fallback"), nil
+}
+
+type fakeFallbackIterator struct {
+	end bool
+}
+
+func (i *fakeFallbackIterator) Next() ([]service.Item, error) {
+	i.end = true
+	return []service.Item{{Meta: map[string]string{"id": "page"}}}, nil
+}
+
+func (i fakeFallbackIterator) HasEnded() bool {
+	return i.end
+}
+
+func TestIteratorNextNotFeed(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	s := New("", fakeFallback{})
+	iterator, _ := s.FetchItems(ts.URL + "/blog/feed/")
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		pageItems, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		items = append(items, pageItems...)
+	}
+
+	expected := []service.Item{{Meta: map[string]string{"id": "page", "_delegate": "fallback"}}}
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+
+	reader, err := s.Download(items[0].Meta, map[string]string{})
+	if err != nil {
+		t.Fatalf("Download error: %v", err)
+	}
+	if content, _ := ioutil.ReadAll(reader); string(content) != "from fallback" {
+		t.Errorf("Download wasn't delegated, got: %s", content)
+	}
+}
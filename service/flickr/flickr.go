@@ -0,0 +1,542 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package flickr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mlvzk/piko/service"
+)
+
+// content is how the api wraps text fields
+type content struct {
+	Content string `json:"_content"`
+}
+
+type apiResponse struct {
+	Stat    string `json:"stat"`
+	Message string `json:"message"`
+}
+
+type photoInfo struct {
+	Photo struct {
+		ID    string `json:"id"`
+		Owner struct {
+			NSID     string `json:"nsid"`
+			Username string `json:"username"`
+			RealName string `json:"realname"`
+		} `json:"owner"`
+		Title       content `json:"title"`
+		Description content `json:"description"`
+		Dates       struct {
+			Taken string `json:"taken"`
+		} `json:"dates"`
+		License string `json:"license"`
+		Media   string `json:"media"`
+	} `json:"photo"`
+}
+
+type photoSizes struct {
+	Sizes struct {
+		Size []struct {
+			Label  string      `json:"label"`
+			Width  json.Number `json:"width"`
+			Height json.Number `json:"height"`
+			Source string      `json:"source"`
+			// photo or video, videos have sizes of their stills too
+			Media string `json:"media"`
+		} `json:"size"`
+	} `json:"sizes"`
+}
+
+type photoExif struct {
+	Photo struct {
+		Camera string `json:"camera"`
+		Exif   []struct {
+			Tag string  `json:"tag"`
+			Raw content `json:"raw"`
+		} `json:"exif"`
+	} `json:"photo"`
+}
+
+type photoList struct {
+	// only in photosets
+	Title string      `json:"title"`
+	Pages int         `json:"pages"`
+	Photo []listPhoto `json:"photo"`
+}
+
+// listPhoto has the fields of listExtras
+type listPhoto struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Description content `json:"description"`
+	License     string  `json:"license"`
+	DateTaken   string  `json:"datetaken"`
+	Media       string  `json:"media"`
+	// urls of sizes, the original is there only if the owner allows it
+	URLO string `json:"url_o"`
+	URLK string `json:"url_k"`
+	URLH string `json:"url_h"`
+	URLL string `json:"url_l"`
+	URLC string `json:"url_c"`
+	URLZ string `json:"url_z"`
+}
+
+// listExtras are fields added to photos of lists, so they don't need their own calls
+const listExtras = "description,license,date_taken,media,url_o,url_k,url_h,url_l,url_c,url_z"
+
+type lookupUser struct {
+	User struct {
+		ID string `json:"id"`
+	} `json:"user"`
+}
+
+type peopleInfo struct {
+	Person struct {
+		Username content `json:"username"`
+		RealName content `json:"realname"`
+	} `json:"person"`
+}
+
+// names of flickr.photos.licenses.getInfo
+var licenses = map[string]string{
+	"0":  "All Rights Reserved",
+	"1":  "CC BY-NC-SA 2.0",
+	"2":  "CC BY-NC 2.0",
+	"3":  "CC BY-NC-ND 2.0",
+	"4":  "CC BY 2.0",
+	"5":  "CC BY-SA 2.0",
+	"6":  "CC BY-ND 2.0",
+	"7":  "No known copyright restrictions",
+	"8":  "United States Government Work",
+	"9":  "CC0 1.0",
+	"10": "Public Domain Mark 1.0",
+}
+
+// exif tags exposed in meta, by meta key
+var exifTags = map[string]string{
+	"exposure":    "ExposureTime",
+	"aperture":    "FNumber",
+	"iso":         "ISO",
+	"focalLength": "FocalLength",
+	"lens":        "LensModel",
+}
+
+const perPage = 50
+
+type Flickr struct{}
+type FlickrIterator struct {
+	baseAPIURL string
+	// page with the api key of the website
+	keyURL string
+	url    string
+	key    string
+	userID string
+	// of userID, fetched once for all photos of the list
+	username string
+	realName string
+	page     int
+	end      bool
+}
+
+func New() Flickr {
+	return Flickr{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Flickr) IsValidTarget(target string) bool {
+	return strings.Contains(target, "flickr.com/photos/")
+}
+
+func (s Flickr) FetchItems(target string) (service.ServiceIterator, error) {
+	return &FlickrIterator{
+		baseAPIURL: "https://api.flickr.com/services/rest",
+		keyURL:     "https://www.flickr.com/hermes_error_beacon.gne",
+		url:        target,
+		page:       1,
+	}, nil
+}
+
+func (s Flickr) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *FlickrIterator) Next() ([]service.Item, error) {
+	kind, user, id, err := parseTarget(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if i.key == "" {
+		if i.key, err = fetchKey(i.keyURL); err != nil {
+			i.end = true
+			return nil, err
+		}
+	}
+
+	if kind == "photo" {
+		i.end = true
+
+		item, err := i.photoItem(id)
+		if err != nil {
+			return nil, err
+		}
+
+		return []service.Item{item}, nil
+	}
+
+	list, err := i.listPage(kind, user, id)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if i.page >= list.Pages {
+		i.end = true
+	}
+	i.page++
+
+	// keep going on errors, one private photo shouldn't stop the whole album
+	var firstErr error
+	items := []service.Item{}
+	for _, photo := range list.Photo {
+		item, err := i.listItem(photo)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if kind == "album" {
+			item.Meta["album"] = list.Title
+		}
+		items = append(items, item)
+	}
+
+	return items, firstErr
+}
+
+func (i FlickrIterator) HasEnded() bool {
+	return i.end
+}
+
+func (i *FlickrIterator) listPage(kind, user, id string) (photoList, error) {
+	if i.userID == "" {
+		lookup := lookupUser{}
+		err := i.call("flickr.urls.lookupUser", url.Values{"url": {"https://www.flickr.com/photos/" + user + "/"}}, &lookup)
+		if err != nil {
+			return photoList{}, err
+		}
+		i.userID = lookup.User.ID
+
+		people := peopleInfo{}
+		if err := i.call("flickr.people.getInfo", url.Values{"user_id": {i.userID}}, &people); err != nil {
+			return photoList{}, err
+		}
+		i.username, i.realName = people.Person.Username.Content, people.Person.RealName.Content
+	}
+
+	params := url.Values{
+		"user_id":  {i.userID},
+		"page":     {strconv.Itoa(i.page)},
+		"per_page": {strconv.Itoa(perPage)},
+		"extras":   {listExtras},
+	}
+
+	if kind == "album" {
+		params.Set("photoset_id", id)
+
+		response := struct {
+			Photoset photoList `json:"photoset"`
+		}{}
+		err := i.call("flickr.photosets.getPhotos", params, &response)
+		return response.Photoset, err
+	}
+
+	response := struct {
+		Photos photoList `json:"photos"`
+	}{}
+	err := i.call("flickr.people.getPhotos", params, &response)
+	return response.Photos, err
+}
+
+func (i *FlickrIterator) photoItem(id string) (service.Item, error) {
+	info := photoInfo{}
+	if err := i.call("flickr.photos.getInfo", url.Values{"photo_id": {id}}, &info); err != nil {
+		return service.Item{}, err
+	}
+
+	downloadURL, err := i.largestSize(id, info.Photo.Media)
+	if err != nil {
+		return service.Item{}, err
+	}
+
+	return i.newItem(map[string]string{
+		"id":          id,
+		"title":       info.Photo.Title.Content,
+		"description": info.Photo.Description.Content,
+		"owner":       info.Photo.Owner.Username,
+		"ownerName":   info.Photo.Owner.RealName,
+		"ownerID":     info.Photo.Owner.NSID,
+		"taken":       info.Photo.Dates.Taken,
+		"license":     licenses[info.Photo.License],
+		"media":       info.Photo.Media,
+	}, downloadURL), nil
+}
+
+// listItem needs only the exif call, the rest comes with the list
+func (i *FlickrIterator) listItem(photo listPhoto) (service.Item, error) {
+	downloadURL := ""
+	// urls of videos are their stills
+	if photo.Media == "photo" {
+		for _, sizeURL := range []string{photo.URLO, photo.URLK, photo.URLH, photo.URLL, photo.URLC, photo.URLZ} {
+			if sizeURL != "" {
+				downloadURL = sizeURL
+				break
+			}
+		}
+	}
+	if downloadURL == "" {
+		var err error
+		if downloadURL, err = i.largestSize(photo.ID, photo.Media); err != nil {
+			return service.Item{}, err
+		}
+	}
+
+	return i.newItem(map[string]string{
+		"id":          photo.ID,
+		"title":       photo.Title,
+		"description": photo.Description.Content,
+		"owner":       i.username,
+		"ownerName":   i.realName,
+		"ownerID":     i.userID,
+		"taken":       photo.DateTaken,
+		"license":     licenses[photo.License],
+		"media":       photo.Media,
+	}, downloadURL), nil
+}
+
+// largestSize returns the original if the owner allows it, otherwise the largest size,
+// of the given media only
+func (i *FlickrIterator) largestSize(id, media string) (string, error) {
+	sizes := photoSizes{}
+	if err := i.call("flickr.photos.getSizes", url.Values{"photo_id": {id}}, &sizes); err != nil {
+		return "", err
+	}
+
+	downloadURL := ""
+	var largest int64
+	for _, size := range sizes.Sizes.Size {
+		if size.Media != "" && size.Media != media {
+			continue
+		}
+
+		if size.Label == "Original" || size.Label == "Video Original" {
+			return size.Source, nil
+		}
+
+		width, _ := size.Width.Int64()
+		height, _ := size.Height.Int64()
+		if width*height >= largest {
+			largest = width * height
+			downloadURL = size.Source
+		}
+	}
+	if downloadURL == "" {
+		return "", errors.New("Couldn't find any sizes of the " + media + " " + id)
+	}
+
+	return downloadURL, nil
+}
+
+// newItem adds the exif and the download url to meta
+func (i *FlickrIterator) newItem(meta map[string]string, downloadURL string) service.Item {
+	ext := ""
+	if u, err := url.Parse(downloadURL); err == nil && len(path.Ext(u.Path)) > 1 {
+		// cut the dot
+		ext = path.Ext(u.Path)[1:]
+	}
+	if ext == "" && meta["media"] == "video" {
+		// video urls are like /photos/<user>/<id>/play/orig/<secret>/
+		ext = "mp4"
+	}
+
+	meta["ext"] = ext
+	meta["downloadURL"] = downloadURL
+	meta["camera"] = ""
+	for key := range exifTags {
+		meta[key] = ""
+	}
+
+	// owners can hide exif, it's not an error
+	exif := photoExif{}
+	if err := i.call("flickr.photos.getExif", url.Values{"photo_id": {meta["id"]}}, &exif); err == nil {
+		meta["camera"] = exif.Photo.Camera
+		for key, tag := range exifTags {
+			for _, e := range exif.Photo.Exif {
+				if e.Tag == tag {
+					meta[key] = e.Raw.Content
+					break
+				}
+			}
+		}
+	}
+
+	return service.Item{
+		Meta:        meta,
+		DefaultName: "%[owner]-%[id].%[ext]",
+	}
+}
+
+// call calls a method of the rest api and unmarshals the response into v
+func (i *FlickrIterator) call(method string, params url.Values, v interface{}) error {
+	query := url.Values{}
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("method", method)
+	query.Set("api_key", i.key)
+	query.Set("format", "json")
+	query.Set("nojsoncallback", "1")
+
+	u := i.baseAPIURL + "?" + query.Encode()
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	status := apiResponse{}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return err
+	}
+	if status.Stat != "ok" {
+		return fmt.Errorf("%s failed: %s", method, status.Message)
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+var siteKeyRegexp = regexp.MustCompile(`site_key\s*=\s*"([^"]+)"`)
+
+// fetchKey returns the api key the website itself uses
+func fetchKey(keyURL string) (string, error) {
+	resp, err := http.Get(keyURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("GET %v returned a wrong status code - %v", keyURL, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	matches := siteKeyRegexp.FindSubmatch(body)
+	if len(matches) < 2 {
+		return "", errors.New("Couldn't find the api key")
+	}
+
+	return string(matches[1]), nil
+}
+
+var photoIDRegexp = regexp.MustCompile(`^\d+$`)
+
+// parseTarget returns kind of the target, the user and the id of the photo or the album, kinds are:
+// photo - flickr.com/photos/<user>/<id>
+// album - flickr.com/photos/<user>/albums/<id>, flickr.com/photos/<user>/sets/<id>
+// photostream - flickr.com/photos/<user>, id is empty
+func parseTarget(target string) (kind, user, id string, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(pathParts) < 2 || pathParts[0] != "photos" || pathParts[1] == "" {
+		return "", "", "", errors.New("Unsupported flickr url: " + target)
+	}
+	user = pathParts[1]
+
+	switch {
+	case len(pathParts) == 2:
+		return "photostream", user, "", nil
+	case (pathParts[2] == "albums" || pathParts[2] == "sets") && len(pathParts) >= 4:
+		return "album", user, pathParts[3], nil
+	case photoIDRegexp.MatchString(pathParts[2]):
+		return "photo", user, pathParts[2], nil
+	}
+
+	return "", "", "", errors.New("Unsupported flickr url: " + target)
+}
@@ -0,0 +1,206 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package flickr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+// by method and photo id or page, the site key page by its path
+var responses = map[string]string{
+	"/key": `<script>root.YUI_config.flickr.api.site_key = "testkey";</script>`,
+	"flickr.photos.getInfo:101": `{"stat":"ok","photo":{"id":"101","media":"photo","license":"4",
+		"owner":{"nsid":"12@N01","username":"lens","realname":"Ann Lens"},
+		"title":{"_content":"Harbour"},"description":{"_content":"Morning"},"dates":{"taken":"2019-04-01 06:30:00"}}}`,
+	"flickr.photos.getSizes:101": `{"stat":"ok","sizes":{"size":[
+		{"label":"Large","width":1024,"height":768,"source":"https://live.staticflickr.com/1/101_abc_b.jpg"},
+		{"label":"Original","width":"4000","height":"3000","source":"https://live.staticflickr.com/1/101_def_o.png"}]}}`,
+	"flickr.photos.getExif:101": `{"stat":"ok","photo":{"camera":"Fujifilm X-T2","exif":[
+		{"tag":"ExposureTime","raw":{"_content":"1/250"}},{"tag":"FNumber","raw":{"_content":"8.0"}},{"tag":"ISO","raw":{"_content":"200"}}]}}`,
+	"flickr.photos.getExif:102":  `{"stat":"fail","code":2,"message":"Permission denied"}`,
+	"flickr.photos.getSizes:103": `{"stat":"fail","code":1,"message":"Photo not found"}`,
+	"flickr.photos.getSizes:104": `{"stat":"ok","sizes":{"size":[
+		{"label":"Large","width":1024,"height":576,"source":"https://live.staticflickr.com/1/104_abc_b.jpg","media":"photo"},
+		{"label":"Site MP4","width":640,"height":360,"source":"https://www.flickr.com/photos/lens/104/play/site/abc/","media":"video"},
+		{"label":"720p","width":1280,"height":720,"source":"https://www.flickr.com/photos/lens/104/play/720p/abc/","media":"video"}]}}`,
+	"flickr.photos.getExif:104": `{"stat":"ok","photo":{"camera":"","exif":[]}}`,
+	"flickr.urls.lookupUser:":   `{"stat":"ok","user":{"id":"12@N01","username":{"_content":"lens"}}}`,
+	"flickr.people.getInfo:":    `{"stat":"ok","person":{"id":"12@N01","username":{"_content":"lens"},"realname":{"_content":"Ann Lens"}}}`,
+	// lists have the extras, so only videos and photos without sizes in them need more calls
+	"flickr.photosets.getPhotos:1": `{"stat":"ok","photoset":{"title":"Coast","page":1,"pages":2,"photo":[
+		{"id":"101","title":"Harbour","description":{"_content":"Morning"},"license":"4","datetaken":"2019-04-01 06:30:00","media":"photo",
+			"url_o":"https://live.staticflickr.com/1/101_def_o.png","url_l":"https://live.staticflickr.com/1/101_abc_b.jpg"},
+		{"id":"103","title":"Deleted","media":"video"}]}}`,
+	"flickr.photosets.getPhotos:2": `{"stat":"ok","photoset":{"title":"Coast","page":2,"pages":2,"photo":[
+		{"id":"102","title":"Pier","description":{"_content":""},"license":"0","datetaken":"2019-04-02 07:00:00","media":"photo",
+			"url_z":"https://live.staticflickr.com/1/102_abc_z.jpg","url_l":"https://live.staticflickr.com/1/102_abc_b.jpg"},
+		{"id":"104","title":"Waves","license":"4","media":"video","url_l":"https://live.staticflickr.com/1/104_abc_b.jpg"}]}}`,
+}
+
+func responseKey(t *testing.T) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if r.URL.Path == "/key" {
+			return r.URL.Path
+		}
+
+		query := r.URL.Query()
+		if query.Get("api_key") != "testkey" {
+			t.Errorf("Wrong api key: %v", query.Get("api_key"))
+		}
+
+		if strings.HasSuffix(query.Get("method"), ".getPhotos") && query.Get("extras") != listExtras {
+			t.Errorf("Wrong extras: %v", query.Get("extras"))
+		}
+
+		return query.Get("method") + ":" + query.Get("photo_id") + query.Get("page")
+	}
+}
+
+func newTestIterator(ts *httptest.Server, target string) *FlickrIterator {
+	return &FlickrIterator{
+		baseAPIURL: ts.URL + "/rest",
+		keyURL:     ts.URL + "/key",
+		url:        target,
+		page:       1,
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := map[string][3]string{
+		"https://www.flickr.com/photos/lens/101":             {"photo", "lens", "101"},
+		"https://www.flickr.com/photos/lens/101/in/album-1/": {"photo", "lens", "101"},
+		"www.flickr.com/photos/lens/albums/72157":            {"album", "lens", "72157"},
+		"https://www.flickr.com/photos/12@N01/sets/72157/":   {"album", "12@N01", "72157"},
+		"https://www.flickr.com/photos/lens/":                {"photostream", "lens", ""},
+	}
+
+	for target, expected := range tests {
+		kind, user, id, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget error: %v, target: %v", err, target)
+			continue
+		}
+		if result := [3]string{kind, user, id}; result != expected {
+			t.Errorf("Invalid result, target: %v, got: %v, expected: %v", target, result, expected)
+		}
+	}
+
+	if _, _, _, err := parseTarget("https://www.flickr.com/photos/lens/favorites"); err == nil {
+		t.Errorf("Expected an error for an unsupported url")
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+	defer ts.Close()
+
+	iterator := newTestIterator(ts, "https://www.flickr.com/photos/lens/101")
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "101",
+				"title":       "Harbour",
+				"description": "Morning",
+				"owner":       "lens",
+				"ownerName":   "Ann Lens",
+				"ownerID":     "12@N01",
+				"taken":       "2019-04-01 06:30:00",
+				"license":     "CC BY 2.0",
+				"media":       "photo",
+				"camera":      "Fujifilm X-T2",
+				"exposure":    "1/250",
+				"aperture":    "8.0",
+				"iso":         "200",
+				"focalLength": "",
+				"lens":        "",
+				"ext":         "png",
+				"downloadURL": "https://live.staticflickr.com/1/101_def_o.png",
+			},
+			DefaultName: "%[owner]-%[id].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextAlbum(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+	defer ts.Close()
+
+	iterator := newTestIterator(ts, "https://www.flickr.com/photos/lens/albums/72157")
+
+	items, err := iterator.Next()
+	if err == nil {
+		t.Errorf("Expected an error for the missing video")
+	}
+	if iterator.HasEnded() {
+		t.Fatalf("Iterator shouldn't have ended after the first page")
+	}
+	if len(items) != 1 {
+		t.Fatalf("Expected one item, got: %+v", items)
+	}
+
+	// the same as of the single photo, but without getInfo and getSizes
+	first, err := newTestIterator(ts, "https://www.flickr.com/photos/lens/101").Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	first[0].Meta["album"] = "Coast"
+	if diff := pretty.Compare(items, first); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+
+	items, err = iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	got := []string{}
+	for _, item := range items {
+		got = append(got, item.Meta["media"]+" "+item.Meta["ext"]+" "+item.Meta["license"]+" "+item.Meta["downloadURL"])
+	}
+	expected := []string{
+		// no original and no exif
+		"photo jpg All Rights Reserved https://live.staticflickr.com/1/102_abc_b.jpg",
+		// the largest video size, not the still
+		"video mp4 CC BY 2.0 https://www.flickr.com/photos/lens/104/play/720p/abc/",
+	}
+	if diff := pretty.Compare(got, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
@@ -17,24 +17,158 @@
 package fourchan
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/mlvzk/piko/service"
 )
 
-type Fourchan struct{}
-type FourchanIterator struct {
+// post is a partial structure of a post from the read-only json api
+type post struct {
+	No          int    `json:"no"`
+	Tim         int64  `json:"tim"`
+	Filename    string `json:"filename"`
+	Ext         string `json:"ext"`
+	MD5         string `json:"md5"`
+	Width       int    `json:"w"`
+	Height      int    `json:"h"`
+	Fsize       int    `json:"fsize"`
+	FileDeleted int    `json:"filedeleted"`
+	Archived    int    `json:"archived"`
+}
+
+type thread struct {
+	Posts []post `json:"posts"`
+}
+
+// threadsPage is an element of both threads.json and catalog.json
+type threadsPage struct {
+	Page    int `json:"page"`
+	Threads []struct {
+		No int `json:"no"`
+	} `json:"threads"`
+}
+
+// archiveThread is a partial structure of a thread from FoolFuuka's api,
+// FoolFuuka returns numbers as strings
+type archiveThread struct {
+	OP    archivePost            `json:"op"`
+	Posts map[string]archivePost `json:"posts"`
+}
+
+type archivePost struct {
+	Num   string `json:"num"`
+	Media *struct {
+		MediaFilename   string `json:"media_filename"`
+		MediaOrig       string `json:"media_orig"`
+		MediaHash       string `json:"media_hash"`
+		MediaSize       string `json:"media_size"`
+		MediaW          string `json:"media_w"`
+		MediaH          string `json:"media_h"`
+		MediaLink       string `json:"media_link"`
+		RemoteMediaLink string `json:"remote_media_link"`
+		ThumbLink       string `json:"thumb_link"`
+	} `json:"media"`
+}
+
+// DefaultArchives are FoolFuuka archive sites tried in order
+// when a thread of the board 404s
+var DefaultArchives = map[string][]string{
+	"a":     {"https://desuarchive.org"},
+	"aco":   {"https://desuarchive.org"},
+	"adv":   {"https://archive.4plebs.org"},
+	"an":    {"https://desuarchive.org"},
+	"bant":  {"https://archive.nyafuu.org"},
+	"c":     {"https://desuarchive.org"},
+	"co":    {"https://desuarchive.org"},
+	"d":     {"https://desuarchive.org"},
+	"e":     {"https://archive.nyafuu.org"},
+	"f":     {"https://archive.4plebs.org"},
+	"fit":   {"https://desuarchive.org"},
+	"g":     {"https://desuarchive.org"},
+	"gif":   {"https://desuarchive.org"},
+	"hr":    {"https://archive.4plebs.org"},
+	"int":   {"https://desuarchive.org"},
+	"k":     {"https://desuarchive.org"},
+	"m":     {"https://desuarchive.org"},
+	"mlp":   {"https://desuarchive.org"},
+	"mu":    {"https://desuarchive.org"},
+	"n":     {"https://archive.nyafuu.org"},
+	"o":     {"https://archive.4plebs.org"},
+	"pol":   {"https://archive.4plebs.org"},
+	"q":     {"https://desuarchive.org"},
+	"qa":    {"https://desuarchive.org"},
+	"r9k":   {"https://desuarchive.org"},
+	"s4s":   {"https://archive.4plebs.org"},
+	"sp":    {"https://archive.4plebs.org"},
+	"tg":    {"https://archive.4plebs.org", "https://desuarchive.org"},
+	"trash": {"https://desuarchive.org"},
+	"trv":   {"https://archive.4plebs.org"},
+	"tv":    {"https://archive.4plebs.org"},
+	"vip":   {"https://archive.nyafuu.org"},
+	"vr":    {"https://desuarchive.org"},
+	"w":     {"https://archive.nyafuu.org"},
+	"wg":    {"https://archive.nyafuu.org"},
+	"wsg":   {"https://desuarchive.org"},
+	"x":     {"https://archive.4plebs.org"},
+}
+
+type statusError struct {
 	url  string
-	page int
-	end  bool
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("GET %v returned a wrong status code - %v", e.url, e.code)
+}
+
+type Fourchan struct {
+	archives map[string][]string
+}
+type FourchanIterator struct {
+	url          string
+	baseApiURL   string
+	baseImageURL string
+	archives     map[string][]string
+	board        string
+	// board or catalog target, not a single thread
+	isCrawl bool
+	// threads left to fetch, nil until the target is resolved
+	threads []int
+	end     bool
+}
+
+// FourchanWatchIterator polls a single thread,
+// returning files of new posts until the thread is archived or 404s
+type FourchanWatchIterator struct {
+	FourchanIterator
+	threadNo     int
+	interval     time.Duration
+	lastModified string
+	seen         map[int]bool
+	polled       bool
 }
 
 func New() Fourchan {
-	return Fourchan{}
+	return NewWithArchives(DefaultArchives)
+}
+
+// NewWithArchives takes archive sites by board to fallback to for dead threads,
+// see DefaultArchives
+func NewWithArchives(archives map[string][]string) Fourchan {
+	return Fourchan{
+		archives: archives,
+	}
 }
 
 type output struct {
@@ -52,9 +186,34 @@ func (s Fourchan) IsValidTarget(target string) bool {
 
 func (s Fourchan) FetchItems(target string) (service.ServiceIterator, error) {
 	return &FourchanIterator{
-		url:  target,
-		page: 1,
-		end:  false,
+		url:          target,
+		baseApiURL:   "https://a.4cdn.org",
+		baseImageURL: "https://i.4cdn.org",
+		archives:     s.archives,
+	}, nil
+}
+
+func (s Fourchan) WatchItems(target string) (service.ServiceIterator, error) {
+	board, threadNo, _, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	if threadNo == 0 {
+		return nil, errors.New("Only threads can be watched, target: " + target)
+	}
+
+	return &FourchanWatchIterator{
+		FourchanIterator: FourchanIterator{
+			url:          target,
+			baseApiURL:   "https://a.4cdn.org",
+			baseImageURL: "https://i.4cdn.org",
+			archives:     s.archives,
+			board:        board,
+		},
+		threadNo: threadNo,
+		// the api rules ask for no more than one request per 10 seconds
+		interval: time.Second * 10,
+		seen:     map[int]bool{},
 	}, nil
 }
 
@@ -68,6 +227,10 @@ func (s Fourchan) Download(meta, options map[string]string) (io.Reader, error) {
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", url, resp.StatusCode)
+	}
 
 	if resp.ContentLength == -1 {
 		return resp.Body, nil
@@ -79,60 +242,268 @@ func (s Fourchan) Download(meta, options map[string]string) (io.Reader, error) {
 	}, nil
 }
 
+// Next returns items of one thread,
+// board and catalog targets iterate over every live thread
 func (i *FourchanIterator) Next() ([]service.Item, error) {
-	i.end = true
+	if i.threads == nil {
+		board, threadNo, isCatalog, err := parseTarget(i.url)
+		if err != nil {
+			i.end = true
+			return nil, err
+		}
+		i.board = board
+
+		if threadNo != 0 {
+			i.threads = []int{threadNo}
+		} else {
+			i.isCrawl = true
+			i.threads, err = i.fetchThreadList(isCatalog)
+			if err != nil {
+				i.end = true
+				return nil, err
+			}
+		}
+	}
+
+	if len(i.threads) == 0 {
+		i.end = true
+		return []service.Item{}, nil
+	}
+
+	threadNo := i.threads[0]
+	i.threads = i.threads[1:]
+	if len(i.threads) == 0 {
+		i.end = true
+	}
 
-	resp, err := http.Get(i.url)
+	t, err := i.fetchThread(threadNo)
+	if statusErr, ok := err.(statusError); ok && statusErr.code == 404 {
+		// the thread is dead, it might still be in an archive
+		return i.fetchArchivedItems(threadNo, err)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", i.url, resp.StatusCode)
+
+	return i.threadItems(threadNo, t.Posts), nil
+}
+
+func (i FourchanIterator) HasEnded() bool {
+	return i.end
+}
+
+func (i *FourchanIterator) fetchThread(threadNo int) (thread, error) {
+	t := thread{}
+	err := getJSON(fmt.Sprintf("%s/%s/thread/%d.json", i.baseApiURL, i.board, threadNo), &t)
+
+	return t, err
+}
+
+func (i *FourchanIterator) fetchThreadList(isCatalog bool) ([]int, error) {
+	listName := "threads"
+	if isCatalog {
+		listName = "catalog"
 	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	pages := []threadsPage{}
+	err := getJSON(fmt.Sprintf("%s/%s/%s.json", i.baseApiURL, i.board, listName), &pages)
 	if err != nil {
 		return nil, err
 	}
 
+	threads := []int{}
+	for _, page := range pages {
+		for _, t := range page.Threads {
+			threads = append(threads, t.No)
+		}
+	}
+
+	return threads, nil
+}
+
+// defaultName is the original file name for a single thread.
+// Files of different threads in board and catalog crawls often share the same name,
+// so they're grouped by board and thread and prefixed with the id
+func (i *FourchanIterator) defaultName() string {
+	if i.isCrawl {
+		return "%[board]/%[thread]/%[id]-%[title]"
+	}
+
+	return "%[title]"
+}
+
+func (i *FourchanIterator) threadItems(threadNo int, posts []post) []service.Item {
 	items := []service.Item{}
-	doc.Find("div.file").Each(func(_ int, sel *goquery.Selection) {
-		imgURL, iuExists := sel.Find("a.fileThumb").Attr("href")
-		if !iuExists {
-			return
+
+	for _, p := range posts {
+		if p.Tim == 0 || p.FileDeleted == 1 {
+			continue
+		}
+
+		id := strconv.FormatInt(p.Tim, 10)
+		items = append(items, service.Item{
+			Meta: map[string]string{
+				"title":        p.Filename + p.Ext,
+				"imgURL":       fmt.Sprintf("%s/%s/%s%s", i.baseImageURL, i.board, id, p.Ext),
+				"id":           id,
+				"ext":          strings.TrimPrefix(p.Ext, "."),
+				"thumbnailURL": fmt.Sprintf("%s/%s/%ss.jpg", i.baseImageURL, i.board, id),
+				"board":        i.board,
+				"thread":       strconv.Itoa(threadNo),
+				"postNo":       strconv.Itoa(p.No),
+				"filename":     p.Filename,
+				"md5":          p.MD5,
+				"width":        strconv.Itoa(p.Width),
+				"height":       strconv.Itoa(p.Height),
+				"size":         strconv.Itoa(p.Fsize),
+				"archived":     "no",
+			},
+			DefaultName: i.defaultName(),
+			AvailableOptions: map[string][]string{
+				"thumbnail": {"yes", "no"},
+			},
+			DefaultOptions: map[string]string{
+				"thumbnail": "no",
+			},
+		})
+	}
+
+	return items
+}
+
+func (i *FourchanWatchIterator) Next() ([]service.Item, error) {
+	if i.polled {
+		time.Sleep(i.interval)
+	}
+	i.polled = true
+
+	u := fmt.Sprintf("%s/%s/thread/%d.json", i.baseApiURL, i.board, i.threadNo)
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if i.lastModified != "" {
+		req.Header.Set("If-Modified-Since", i.lastModified)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+	case 304:
+		return []service.Item{}, nil
+	case 404:
+		i.end = true
+		return []service.Item{}, nil
+	default:
+		return nil, statusError{u, resp.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	t := thread{}
+	if err := json.Unmarshal(body, &t); err != nil {
+		return nil, err
+	}
+	i.lastModified = resp.Header.Get("Last-Modified")
+
+	newPosts := []post{}
+	for _, p := range t.Posts {
+		if i.seen[p.No] {
+			continue
+		}
+
+		i.seen[p.No] = true
+		newPosts = append(newPosts, p)
+	}
+
+	if len(t.Posts) > 0 && t.Posts[0].Archived == 1 {
+		i.end = true
+	}
+
+	return i.threadItems(i.threadNo, newPosts), nil
+}
+
+// fetchArchivedItems tries archives of the board in order,
+// returning liveErr if none of them has the thread
+func (i *FourchanIterator) fetchArchivedItems(threadNo int, liveErr error) ([]service.Item, error) {
+	for _, archive := range i.archives[i.board] {
+		threads := map[string]json.RawMessage{}
+		err := getJSON(fmt.Sprintf("%s/_/api/chan/thread/?board=%s&num=%d", archive, i.board, threadNo), &threads)
+		if err != nil {
+			continue
 		}
-		if imgURL[0] == '/' {
-			imgURL = "https:" + imgURL
+
+		// FoolFuuka responds with {"error": "..."} if it doesn't have the thread
+		threadJSON, found := threads[strconv.Itoa(threadNo)]
+		if !found {
+			continue
 		}
 
-		thumbnailURL, _ := sel.Find("a.fileThumb img").Attr("src")
-		if thumbnailURL[0] == '/' {
-			thumbnailURL = "https:" + thumbnailURL
+		t := archiveThread{}
+		if err := json.Unmarshal(threadJSON, &t); err != nil {
+			continue
 		}
 
-		titleSel := sel.Find("div.fileText a")
-		title, titleExists := titleSel.Attr("title")
-		if !titleExists || strings.TrimSpace(title) == "" {
-			title = titleSel.Text()
+		return i.archiveItems(threadNo, t), nil
+	}
+
+	return nil, liveErr
+}
+
+func (i *FourchanIterator) archiveItems(threadNo int, t archiveThread) []service.Item {
+	posts := []archivePost{t.OP}
+	for _, p := range t.Posts {
+		posts = append(posts, p)
+	}
+	sort.Slice(posts, func(a, b int) bool {
+		numA, _ := strconv.Atoi(posts[a].Num)
+		numB, _ := strconv.Atoi(posts[b].Num)
+		return numA < numB
+	})
+
+	items := []service.Item{}
+	for _, p := range posts {
+		if p.Media == nil || p.Media.MediaOrig == "" {
+			continue
 		}
 
-		dotParts := strings.Split(imgURL, ".")
-		ext := dotParts[len(dotParts)-1]
+		imgURL := p.Media.MediaLink
+		if imgURL == "" {
+			imgURL = p.Media.RemoteMediaLink
+		}
+		if imgURL == "" {
+			continue
+		}
 
-		slashParts := strings.Split(imgURL, "/")
-		lastSlashDotParts := strings.Split(slashParts[len(slashParts)-1], ".")
-		id := lastSlashDotParts[0]
+		ext := path.Ext(p.Media.MediaOrig)
+		filename := strings.TrimSuffix(p.Media.MediaFilename, path.Ext(p.Media.MediaFilename))
 
 		items = append(items, service.Item{
 			Meta: map[string]string{
-				"title":        title,
+				"title":        p.Media.MediaFilename,
 				"imgURL":       imgURL,
-				"id":           id,
-				"ext":          ext,
-				"thumbnailURL": thumbnailURL,
+				"id":           strings.TrimSuffix(p.Media.MediaOrig, ext),
+				"ext":          strings.TrimPrefix(ext, "."),
+				"thumbnailURL": p.Media.ThumbLink,
+				"board":        i.board,
+				"thread":       strconv.Itoa(threadNo),
+				"postNo":       p.Num,
+				"filename":     filename,
+				"md5":          p.Media.MediaHash,
+				"width":        p.Media.MediaW,
+				"height":       p.Media.MediaH,
+				"size":         p.Media.MediaSize,
+				"archived":     "yes",
 			},
-			DefaultName: "%[title]",
+			DefaultName: i.defaultName(),
 			AvailableOptions: map[string][]string{
 				"thumbnail": {"yes", "no"},
 			},
@@ -140,11 +511,54 @@ func (i *FourchanIterator) Next() ([]service.Item, error) {
 				"thumbnail": "no",
 			},
 		})
-	})
+	}
 
-	return items, nil
+	return items
 }
 
-func (i FourchanIterator) HasEnded() bool {
-	return i.end
+// parseTarget parses urls like boards.4channel.org/g/thread/123/slug,
+// boards.4channel.org/g/ and boards.4channel.org/g/catalog
+func parseTarget(target string) (board string, threadNo int, isCatalog bool, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", 0, false, err
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if pathParts[0] == "" {
+		return "", 0, false, errors.New("Missing board in url: " + target)
+	}
+	board = pathParts[0]
+
+	if len(pathParts) >= 3 && pathParts[1] == "thread" {
+		threadNo, err = strconv.Atoi(pathParts[2])
+		if err != nil {
+			return "", 0, false, fmt.Errorf("Invalid thread number in url: %v", target)
+		}
+	}
+	isCatalog = len(pathParts) >= 2 && pathParts[1] == "catalog"
+
+	return board, threadNo, isCatalog, nil
+}
+
+func getJSON(u string, v interface{}) error {
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return statusError{u, resp.StatusCode}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
 }
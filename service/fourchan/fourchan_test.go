@@ -18,6 +18,9 @@ package fourchan
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
@@ -25,7 +28,7 @@ import (
 	"github.com/mlvzk/piko/service/testutil"
 )
 
-const base = "https://boards.4channel.org"
+const base = "https://a.4cdn.org"
 
 var update = flag.Bool("update", false, "update .golden files")
 
@@ -50,7 +53,9 @@ func TestIteratorNext(t *testing.T) {
 	defer ts.Close()
 
 	iterator := FourchanIterator{
-		url: ts.URL + "/vip/thread/88504",
+		url:          "https://boards.4channel.org/vip/thread/88504",
+		baseApiURL:   ts.URL,
+		baseImageURL: "https://i.4cdn.org",
 	}
 
 	items, err := iterator.Next()
@@ -62,10 +67,19 @@ func TestIteratorNext(t *testing.T) {
 		{
 			Meta: map[string]string{
 				"title":        "F.png",
-				"imgURL":       "https://is2.4chan.org/vip/1546227263937.png",
+				"imgURL":       "https://i.4cdn.org/vip/1546227263937.png",
 				"id":           "1546227263937",
 				"ext":          "png",
 				"thumbnailURL": "https://i.4cdn.org/vip/1546227263937s.jpg",
+				"board":        "vip",
+				"thread":       "88504",
+				"postNo":       "88504",
+				"filename":     "F",
+				"md5":          "CeZCF7fTAVoNxaDwW9Dn0w==",
+				"width":        "362",
+				"height":       "834",
+				"size":         "44254",
+				"archived":     "no",
 			},
 			DefaultName: "%[title]",
 			AvailableOptions: map[string][]string{
@@ -78,10 +92,19 @@ func TestIteratorNext(t *testing.T) {
 		{
 			Meta: map[string]string{
 				"title":        "1545804746249.jpg",
-				"imgURL":       "https://is2.4chan.org/vip/1546318308248.jpg",
+				"imgURL":       "https://i.4cdn.org/vip/1546318308248.jpg",
 				"id":           "1546318308248",
 				"ext":          "jpg",
 				"thumbnailURL": "https://i.4cdn.org/vip/1546318308248s.jpg",
+				"board":        "vip",
+				"thread":       "88504",
+				"postNo":       "88521",
+				"filename":     "1545804746249",
+				"md5":          "YkQzkOlhvObztcDhHqjisw==",
+				"width":        "500",
+				"height":       "881",
+				"size":         "163201",
+				"archived":     "no",
 			},
 			DefaultName: "%[title]",
 			AvailableOptions: map[string][]string{
@@ -94,10 +117,19 @@ func TestIteratorNext(t *testing.T) {
 		{
 			Meta: map[string]string{
 				"title":        "tegaki.png",
-				"imgURL":       "https://is2.4chan.org/vip/1549849384199.png",
+				"imgURL":       "https://i.4cdn.org/vip/1549849384199.png",
 				"id":           "1549849384199",
 				"ext":          "png",
 				"thumbnailURL": "https://i.4cdn.org/vip/1549849384199s.jpg",
+				"board":        "vip",
+				"thread":       "88504",
+				"postNo":       "89293",
+				"filename":     "tegaki",
+				"md5":          "O9TLDVVTjMpjfNSFgECK/Q==",
+				"width":        "400",
+				"height":       "400",
+				"size":         "3317",
+				"archived":     "no",
 			},
 			DefaultName: "%[title]",
 			AvailableOptions: map[string][]string{
@@ -113,3 +145,194 @@ func TestIteratorNext(t *testing.T) {
 		t.Errorf("%s diff:\n%s", t.Name(), diff)
 	}
 }
+
+func TestIteratorNextBoard(t *testing.T) {
+	responses := map[string]string{
+		"/vip/catalog.json":    `[{"page":1,"threads":[{"no":100},{"no":200}]},{"page":2,"threads":[{"no":300}]}]`,
+		"/vip/thread/100.json": `{"posts":[{"no":100,"filename":"a","ext":".jpg","tim":1000,"md5":"x","w":1,"h":1,"fsize":1}]}`,
+		"/vip/thread/200.json": `{"posts":[{"no":200},{"no":201,"filename":"b","ext":".webm","tim":2000,"md5":"y","w":2,"h":2,"fsize":2}]}`,
+		"/vip/thread/300.json": `{"posts":[{"no":300,"filename":"c","ext":".png","tim":3000,"md5":"z","w":3,"h":3,"fsize":3,"filedeleted":1}]}`,
+	}
+
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator := FourchanIterator{
+		url:          "https://boards.4channel.org/vip/catalog",
+		baseApiURL:   ts.URL,
+		baseImageURL: "https://i.4cdn.org",
+	}
+
+	got := []string{}
+	for !iterator.HasEnded() {
+		items, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+
+		for _, item := range items {
+			got = append(got, item.Meta["thread"]+"/"+item.Meta["postNo"]+" "+item.Meta["imgURL"])
+			if item.DefaultName != "%[board]/%[thread]/%[id]-%[title]" {
+				t.Errorf("Crawled files should be grouped by thread, got: %v", item.DefaultName)
+			}
+		}
+	}
+
+	expected := []string{
+		"100/100 https://i.4cdn.org/vip/1000.jpg",
+		"200/201 https://i.4cdn.org/vip/2000.webm",
+	}
+
+	if diff := pretty.Compare(got, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextArchived(t *testing.T) {
+	live := httptest.NewServer(http.NotFoundHandler())
+	defer live.Close()
+
+	emptyArchive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"error":"Thread not found."}`)
+	}))
+	defer emptyArchive.Close()
+
+	archive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_/api/chan/thread/" || r.URL.Query().Get("board") != "tg" || r.URL.Query().Get("num") != "500" {
+			t.Errorf("Unexpected archive request: %v", r.URL)
+		}
+
+		fmt.Fprint(w, `{"500":{
+			"op":{"num":"500","media":{"media_filename":"map.png","media_orig":"1550000000000.png","media_hash":"abc==","media_size":"1234","media_w":"800","media_h":"600","media_link":"https://archive.example.com/tg/image/1550000000000.png","remote_media_link":"https://i.4cdn.org/tg/1550000000000.png","thumb_link":"https://archive.example.com/tg/thumb/1550000000000s.jpg"}},
+			"posts":{
+				"502":{"num":"502","media":{"media_filename":"dice.webm","media_orig":"1550000000002.webm","media_hash":"def==","media_size":"5678","media_w":"640","media_h":"480","media_link":null,"remote_media_link":"https://i.4cdn.org/tg/1550000000002.webm","thumb_link":"https://archive.example.com/tg/thumb/1550000000002s.jpg"}},
+				"501":{"num":"501","media":null}
+			}
+		}}`)
+	}))
+	defer archive.Close()
+
+	iterator := FourchanIterator{
+		url:          "https://boards.4channel.org/tg/thread/500",
+		baseApiURL:   live.URL,
+		baseImageURL: "https://i.4cdn.org",
+		archives: map[string][]string{
+			"tg": {emptyArchive.URL, archive.URL},
+		},
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	availableOptions := map[string][]string{
+		"thumbnail": {"yes", "no"},
+	}
+	defaultOptions := map[string]string{
+		"thumbnail": "no",
+	}
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"title":        "map.png",
+				"imgURL":       "https://archive.example.com/tg/image/1550000000000.png",
+				"id":           "1550000000000",
+				"ext":          "png",
+				"thumbnailURL": "https://archive.example.com/tg/thumb/1550000000000s.jpg",
+				"board":        "tg",
+				"thread":       "500",
+				"postNo":       "500",
+				"filename":     "map",
+				"md5":          "abc==",
+				"width":        "800",
+				"height":       "600",
+				"size":         "1234",
+				"archived":     "yes",
+			},
+			DefaultName:      "%[title]",
+			AvailableOptions: availableOptions,
+			DefaultOptions:   defaultOptions,
+		},
+		{
+			Meta: map[string]string{
+				"title":        "dice.webm",
+				"imgURL":       "https://i.4cdn.org/tg/1550000000002.webm",
+				"id":           "1550000000002",
+				"ext":          "webm",
+				"thumbnailURL": "https://archive.example.com/tg/thumb/1550000000002s.jpg",
+				"board":        "tg",
+				"thread":       "500",
+				"postNo":       "502",
+				"filename":     "dice",
+				"md5":          "def==",
+				"width":        "640",
+				"height":       "480",
+				"size":         "5678",
+				"archived":     "yes",
+			},
+			DefaultName:      "%[title]",
+			AvailableOptions: availableOptions,
+			DefaultOptions:   defaultOptions,
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestWatchIteratorNext(t *testing.T) {
+	const lastModified = "Sun, 10 Feb 2019 20:43:04 GMT"
+	responses := []string{
+		`{"posts":[{"no":1,"filename":"a","ext":".png","tim":1000}]}`,
+		"",
+		`{"posts":[{"no":1,"filename":"a","ext":".png","tim":1000,"archived":1},{"no":2},{"no":3,"filename":"b","ext":".gif","tim":3000}]}`,
+	}
+
+	var polls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() { polls++ }()
+
+		if polls > 0 && r.Header.Get("If-Modified-Since") != lastModified {
+			t.Errorf("Wrong If-Modified-Since header: %v", r.Header.Get("If-Modified-Since"))
+		}
+
+		if responses[polls] == "" {
+			w.WriteHeader(304)
+			return
+		}
+		w.Header().Set("Last-Modified", lastModified)
+		fmt.Fprint(w, responses[polls])
+	}))
+	defer ts.Close()
+
+	iterator := FourchanWatchIterator{
+		FourchanIterator: FourchanIterator{
+			baseApiURL:   ts.URL,
+			baseImageURL: "https://i.4cdn.org",
+			board:        "vip",
+		},
+		threadNo: 88504,
+		seen:     map[int]bool{},
+	}
+
+	got := [][]string{}
+	for !iterator.HasEnded() {
+		items, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+
+		titles := []string{}
+		for _, item := range items {
+			titles = append(titles, item.Meta["title"])
+		}
+		got = append(got, titles)
+	}
+
+	expected := [][]string{{"a.png"}, {}, {"b.gif"}}
+	if diff := pretty.Compare(got, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package generic
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/hls"
+)
+
+// Generic is the fallback for urls no other service supports,
+// it should be the last service checked
+type Generic struct{}
+type GenericIterator struct {
+	url string
+	end bool
+}
+
+func New() Generic {
+	return Generic{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Generic) IsValidTarget(target string) bool {
+	return strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://")
+}
+
+func (s Generic) FetchItems(target string) (service.ServiceIterator, error) {
+	return &GenericIterator{
+		url: target,
+	}, nil
+}
+
+func (s Generic) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	if meta["ext"] == "ts" {
+		mediaURL, err := hls.ResolveMedia(downloadURL)
+		if err != nil {
+			return nil, err
+		}
+
+		limit, _ := time.ParseDuration(options["duration"])
+
+		reader, writer := io.Pipe()
+		go hls.Record(mediaURL, limit, writer)
+
+		return reader, nil
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *GenericIterator) Next() ([]service.Item, error) {
+	i.end = true
+
+	resp, err := http.Get(i.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", i.url, resp.StatusCode)
+	}
+
+	// the final url, after redirects
+	base := resp.Request.URL
+	mimeType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	if kind := mediaKind(mimeType); kind != "" {
+		return []service.Item{newItem(base.String(), kind, mimeType, "")}, nil
+	}
+	if mimeType != "text/html" && mimeType != "application/xhtml+xml" {
+		return nil, errors.New("Unsupported content type " + mimeType)
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	og := ParseOpenGraph(doc)
+	title := og.Title
+	if title == "" {
+		title = strings.TrimSpace(doc.Find("title").First().Text())
+	}
+
+	items := []service.Item{}
+	seen := map[string]bool{}
+	add := func(rawURL, kind, mimeType string) {
+		u, err := base.Parse(strings.TrimSpace(rawURL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+
+		items = append(items, newItem(u.String(), kind, mimeType, title))
+	}
+
+	for _, stream := range og.Streams {
+		add(stream, "video", "")
+	}
+	for _, video := range og.Videos {
+		add(video, "video", "")
+	}
+
+	doc.Find("video, audio").Each(func(_ int, mediaSel *goquery.Selection) {
+		kind := goquery.NodeName(mediaSel)
+
+		if src, exists := mediaSel.Attr("src"); exists {
+			add(src, kind, "")
+		}
+		mediaSel.Find("source").Each(func(_ int, sourceSel *goquery.Selection) {
+			if src, exists := sourceSel.Attr("src"); exists {
+				add(src, kind, sourceSel.AttrOr("type", ""))
+			}
+		})
+	})
+
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, scriptSel *goquery.Selection) {
+		for _, contentURL := range videoObjects([]byte(scriptSel.Text())) {
+			add(contentURL, "video", "")
+		}
+	})
+
+	// images last, og:image is usually just a thumbnail of the video
+	if len(items) == 0 {
+		for _, image := range og.Images {
+			add(image, "image", "")
+		}
+	}
+
+	if len(items) == 0 {
+		return nil, errors.New("Couldn't find any media on the page")
+	}
+
+	return items, nil
+}
+
+func (i GenericIterator) HasEnded() bool {
+	return i.end
+}
+
+// videoObjects returns contentUrls of JSON-LD VideoObjects,
+// which can be top level, in an array or in a @graph
+func videoObjects(content []byte) []string {
+	var data interface{}
+	if err := json.Unmarshal(content, &data); err != nil {
+		return nil
+	}
+
+	urls := []string{}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case []interface{}:
+			for _, child := range v {
+				walk(child)
+			}
+		case map[string]interface{}:
+			if v["@type"] == "VideoObject" {
+				if contentURL, ok := v["contentUrl"].(string); ok {
+					urls = append(urls, contentURL)
+				}
+			}
+			walk(v["@graph"])
+			walk(v["video"])
+		}
+	}
+	walk(data)
+
+	return urls
+}
+
+var mimeExtensions = map[string]string{
+	"image/jpeg":                    "jpg",
+	"image/png":                     "png",
+	"image/gif":                     "gif",
+	"image/webp":                    "webp",
+	"video/mp4":                     "mp4",
+	"video/webm":                    "webm",
+	"audio/mpeg":                    "mp3",
+	"audio/ogg":                     "ogg",
+	"audio/mp4":                     "m4a",
+	"application/x-mpegurl":         "ts",
+	"application/vnd.apple.mpegurl": "ts",
+}
+
+var defaultExtensions = map[string]string{
+	"image": "jpg",
+	"video": "mp4",
+	"audio": "mp3",
+}
+
+func mediaKind(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "audio"
+	}
+
+	return ""
+}
+
+func newItem(downloadURL, kind, mimeType, title string) service.Item {
+	u, _ := url.Parse(downloadURL)
+
+	name := path.Base(u.Path)
+	ext := strings.ToLower(path.Ext(name))
+	id := strings.TrimSuffix(name, path.Ext(name))
+	if id == "" || id == "." || id == "/" {
+		id = u.Host
+	}
+
+	if mimeExt, found := mimeExtensions[strings.ToLower(mimeType)]; found {
+		ext = mimeExt
+	} else if ext == ".m3u8" {
+		// playlists are recorded into a transport stream
+		ext = "ts"
+	} else if len(ext) > 1 {
+		// cut the dot
+		ext = ext[1:]
+	} else {
+		ext = defaultExtensions[kind]
+	}
+
+	return service.Item{
+		Meta: map[string]string{
+			"id":          id,
+			"title":       title,
+			"type":        kind,
+			"ext":         ext,
+			"downloadURL": downloadURL,
+		},
+		DefaultName: "%[id].%[ext]",
+	}
+}
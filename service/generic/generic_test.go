@@ -0,0 +1,213 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package generic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var pages = map[string]string{
+	"/article": `<html><head>
+		<title>Fallback title</title>
+		<meta property="og:title" content="Some article">
+		<meta property="og:image" content="https://cdn.example.com/thumb.jpg">
+		<meta property="og:video" content="https://cdn.example.com/clip.mp4">
+		<meta property="og:video:secure_url" content="https://cdn.example.com/clip.mp4">
+		<meta name="twitter:player:stream" content="/streams/live.m3u8">
+		<script type="application/ld+json">{"@context":"https://schema.org","@graph":[{"@type":"WebPage"},{"@type":"VideoObject","contentUrl":"https://cdn.example.com/ld.webm"}]}</script>
+	</head><body>
+		<video src="/media/intro.mp4"></video>
+		<audio><source src="podcast" type="audio/mpeg"><source src="blob:https://example.com/1"></audio>
+	</body></html>`,
+	"/gallery": `<html><head>
+		<meta property="og:image" content="/images/photo.png">
+	</head><body></body></html>`,
+	"/empty": `<html><body>Nothing here</body></html>`,
+	// sniffed as image/webp
+	"/files/cat.webp": "RIFF\x00\x00\x00\x00WEBPVP8 ",
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	return testutil.ServeResponsesBy(pages, func(r *http.Request) string {
+		if _, ok := pages[r.URL.Path]; !ok {
+			t.Errorf("Unexpected request: %v", r.URL)
+		}
+
+		return r.URL.Path
+	})
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://example.com/video.mp4": true,
+		"http://example.com/":           true,
+		"example.com/video.mp4":         false,
+		"magnet:?xt=urn:btih:abc":       false,
+	}
+
+	for target, expected := range tests {
+		if (Generic{}).IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	iterator := GenericIterator{
+		url: ts.URL + "/article",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "live",
+				"title":       "Some article",
+				"type":        "video",
+				"ext":         "ts",
+				"downloadURL": ts.URL + "/streams/live.m3u8",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "clip",
+				"title":       "Some article",
+				"type":        "video",
+				"ext":         "mp4",
+				"downloadURL": "https://cdn.example.com/clip.mp4",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "intro",
+				"title":       "Some article",
+				"type":        "video",
+				"ext":         "mp4",
+				"downloadURL": ts.URL + "/media/intro.mp4",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "podcast",
+				"title":       "Some article",
+				"type":        "audio",
+				"ext":         "mp3",
+				"downloadURL": ts.URL + "/podcast",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "ld",
+				"title":       "Some article",
+				"type":        "video",
+				"ext":         "webm",
+				"downloadURL": "https://cdn.example.com/ld.webm",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextImageOnly(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	iterator := GenericIterator{
+		url: ts.URL + "/gallery",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "photo",
+				"title":       "",
+				"type":        "image",
+				"ext":         "png",
+				"downloadURL": ts.URL + "/images/photo.png",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+
+	iterator = GenericIterator{
+		url: ts.URL + "/empty",
+	}
+	if _, err := iterator.Next(); err == nil {
+		t.Errorf("Expected an error for a page without media")
+	}
+}
+
+func TestIteratorNextDirect(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	iterator := GenericIterator{
+		url: ts.URL + "/files/cat.webp",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "cat",
+				"title":       "",
+				"type":        "image",
+				"ext":         "webp",
+				"downloadURL": ts.URL + "/files/cat.webp",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
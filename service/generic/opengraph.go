@@ -0,0 +1,70 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package generic
+
+import (
+	"github.com/PuerkitoBio/goquery"
+)
+
+// OpenGraph holds media meta tags of a page, the twitter card ones included
+type OpenGraph struct {
+	Title       string
+	Description string
+	Images      []string
+	Videos      []string
+	// twitter:player:stream, a direct video of twitter cards
+	Streams []string
+}
+
+// ParseOpenGraph returns meta tags in the order they appear in the document,
+// empty ones are skipped
+func ParseOpenGraph(doc *goquery.Document) OpenGraph {
+	return OpenGraph{
+		Title:       metaContent(doc, `meta[property="og:title"]`),
+		Description: metaContent(doc, `meta[property="og:description"]`),
+		Images:      MetaContents(doc, `meta[property="og:image"], meta[property="og:image:url"], meta[property="og:image:secure_url"]`),
+		Videos:      MetaContents(doc, `meta[property="og:video"], meta[property="og:video:url"], meta[property="og:video:secure_url"]`),
+		Streams:     MetaContents(doc, `meta[name="twitter:player:stream"], meta[property="twitter:player:stream"]`),
+	}
+}
+
+func metaContent(doc *goquery.Document, selector string) string {
+	if contents := MetaContents(doc, selector); len(contents) > 0 {
+		return contents[0]
+	}
+
+	return ""
+}
+
+// MetaContents returns non-empty content attributes of tags matching selector,
+// it deduplicates, because secure_url and url are often the same
+func MetaContents(doc *goquery.Document, selector string) []string {
+	contents := []string{}
+	seen := map[string]bool{}
+
+	doc.Find(selector).Each(func(_ int, sel *goquery.Selection) {
+		content := sel.AttrOr("content", "")
+		if content == "" || seen[content] {
+			return
+		}
+		seen[content] = true
+
+		contents = append(contents, content)
+	})
+
+	return contents
+}
@@ -0,0 +1,346 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package hls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Variant struct {
+	URL        string
+	Name       string
+	Resolution string
+	Bandwidth  int
+}
+
+type Segment struct {
+	URL      string
+	Sequence int
+	// in seconds
+	Duration float64
+}
+
+type MediaPlaylist struct {
+	// in seconds
+	TargetDuration float64
+	MediaSequence  int
+	Segments       []Segment
+	// true if the playlist has #EXT-X-ENDLIST, live playlists don't
+	Ended bool
+}
+
+func Fetch(playlistURL string) (string, error) {
+	res, err := http.Get(playlistURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return "", fmt.Errorf("GET %v returned a wrong status code - %v", playlistURL, res.StatusCode)
+	}
+
+	bytes, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return string(bytes), nil
+}
+
+// ParseMaster returns variants in the order they appear in the playlist
+func ParseMaster(playlistURL, content string) ([]Variant, error) {
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// #EXT-X-MEDIA NAME by GROUP-ID, twitch names its qualities this way
+	names := map[string]string{}
+	variants := []Variant{}
+	var pending *Variant
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
+			attrs := parseAttributes(line[len("#EXT-X-MEDIA:"):])
+			if attrs["TYPE"] == "VIDEO" && attrs["GROUP-ID"] != "" {
+				names[attrs["GROUP-ID"]] = attrs["NAME"]
+			}
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(line[len("#EXT-X-STREAM-INF:"):])
+			bandwidth, _ := strconv.Atoi(attrs["BANDWIDTH"])
+			pending = &Variant{
+				Name:       names[attrs["VIDEO"]],
+				Resolution: attrs["RESOLUTION"],
+				Bandwidth:  bandwidth,
+			}
+		case line[0] == '#':
+		default:
+			if pending == nil {
+				continue
+			}
+
+			u, err := base.Parse(line)
+			if err != nil {
+				return nil, err
+			}
+			pending.URL = u.String()
+			if pending.Name == "" {
+				pending.Name = pending.Resolution
+			}
+
+			variants = append(variants, *pending)
+			pending = nil
+		}
+	}
+
+	if len(variants) == 0 {
+		return nil, errors.New("Couldn't find any variants in the master playlist")
+	}
+
+	return variants, nil
+}
+
+func ParseMedia(playlistURL, content string) (MediaPlaylist, error) {
+	playlist := MediaPlaylist{}
+
+	base, err := url.Parse(playlistURL)
+	if err != nil {
+		return playlist, err
+	}
+
+	var duration float64
+	index := 0
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			playlist.TargetDuration, _ = strconv.ParseFloat(line[len("#EXT-X-TARGETDURATION:"):], 64)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			playlist.MediaSequence, _ = strconv.Atoi(line[len("#EXT-X-MEDIA-SEQUENCE:"):])
+		case strings.HasPrefix(line, "#EXTINF:"):
+			durationStr := strings.Split(line[len("#EXTINF:"):], ",")[0]
+			duration, _ = strconv.ParseFloat(durationStr, 64)
+		case line == "#EXT-X-ENDLIST":
+			playlist.Ended = true
+		case line[0] == '#':
+		default:
+			u, err := base.Parse(line)
+			if err != nil {
+				return playlist, err
+			}
+
+			playlist.Segments = append(playlist.Segments, Segment{
+				URL:      u.String(),
+				Sequence: playlist.MediaSequence + index,
+				Duration: duration,
+			})
+			index++
+			duration = 0
+		}
+	}
+
+	return playlist, nil
+}
+
+// ResolveMedia returns the url of a media playlist,
+// choosing the best variant if playlistURL points to a master playlist
+func ResolveMedia(playlistURL string) (string, error) {
+	content, err := Fetch(playlistURL)
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.Contains(content, "#EXT-X-STREAM-INF:") {
+		return playlistURL, nil
+	}
+
+	variants, err := ParseMaster(playlistURL, content)
+	if err != nil {
+		return "", err
+	}
+
+	return Best(variants).URL, nil
+}
+
+func Best(variants []Variant) Variant {
+	var best Variant
+
+	for _, v := range variants {
+		if v.Bandwidth > best.Bandwidth || best.URL == "" {
+			best = v
+		}
+	}
+
+	return best
+}
+
+func Worst(variants []Variant) Variant {
+	var worst Variant
+
+	for _, v := range variants {
+		if v.Bandwidth < worst.Bandwidth || worst.URL == "" {
+			worst = v
+		}
+	}
+
+	return worst
+}
+
+// minReloadWait keeps playlists with no or a zero target duration from being polled in a busy loop
+var minReloadWait = time.Second
+
+// reloadRetries is how many times a failed reload of a live playlist is retried,
+// waiting twice as long every time
+const reloadRetries = 3
+
+// Record writes segments of the media playlist to writer,
+// re-polling live playlists for new segments until the stream ends
+// or limit worth of media has been written, 0 means no limit
+func Record(playlistURL string, limit time.Duration, writer io.WriteCloser) (err error) {
+	defer func() {
+		if pipeWriter, ok := writer.(*io.PipeWriter); ok && err != nil {
+			pipeWriter.CloseWithError(err)
+			return
+		}
+		writer.Close()
+	}()
+
+	var recorded float64
+	lastSequence := -1
+
+	for {
+		playlist, err := fetchMedia(playlistURL)
+		// the first fetch isn't retried, the url is probably wrong
+		for attempt := 0; err != nil && lastSequence != -1 && attempt < reloadRetries; attempt++ {
+			time.Sleep(minReloadWait << uint(attempt))
+			playlist, err = fetchMedia(playlistURL)
+		}
+		if err != nil {
+			return err
+		}
+
+		newSegments := 0
+		for _, segment := range playlist.Segments {
+			if segment.Sequence <= lastSequence {
+				continue
+			}
+
+			if err := copySegment(segment.URL, writer); err != nil {
+				return err
+			}
+			lastSequence = segment.Sequence
+			newSegments++
+
+			recorded += segment.Duration
+			if limit > 0 && time.Duration(recorded*float64(time.Second)) >= limit {
+				return nil
+			}
+		}
+
+		if playlist.Ended {
+			return nil
+		}
+
+		// as recommended by the spec, wait half the target duration
+		// if the playlist hasn't changed since the last reload
+		wait := time.Duration(playlist.TargetDuration * float64(time.Second))
+		if newSegments == 0 {
+			wait /= 2
+		}
+		if wait < minReloadWait {
+			wait = minReloadWait
+		}
+		time.Sleep(wait)
+	}
+}
+
+func fetchMedia(playlistURL string) (MediaPlaylist, error) {
+	content, err := Fetch(playlistURL)
+	if err != nil {
+		return MediaPlaylist{}, err
+	}
+
+	return ParseMedia(playlistURL, content)
+}
+
+func copySegment(segmentURL string, writer io.Writer) error {
+	res, err := http.Get(segmentURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", segmentURL, res.StatusCode)
+	}
+
+	_, err = io.Copy(writer, res.Body)
+	return err
+}
+
+// parseAttributes parses attribute lists like BANDWIDTH=123,CODECS="a,b"
+func parseAttributes(list string) map[string]string {
+	attrs := map[string]string{}
+
+	for len(list) > 0 {
+		eq := strings.IndexByte(list, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(list[:eq])
+		list = list[eq+1:]
+
+		var value string
+		if len(list) > 0 && list[0] == '"' {
+			end := strings.IndexByte(list[1:], '"')
+			if end == -1 {
+				value, list = list[1:], ""
+			} else {
+				value, list = list[1:end+1], list[end+2:]
+			}
+		} else {
+			end := strings.IndexByte(list, ',')
+			if end == -1 {
+				value, list = list, ""
+			} else {
+				value, list = list[:end], list[end:]
+			}
+		}
+
+		attrs[key] = value
+		list = strings.TrimPrefix(list, ",")
+	}
+
+	return attrs
+}
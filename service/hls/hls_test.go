@@ -0,0 +1,150 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package hls
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseMaster(t *testing.T) {
+	content := `#EXTM3U
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="chunked",NAME="1080p60 (source)",AUTOSELECT=YES,DEFAULT=YES
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080,CODECS="avc1.64002A,mp4a.40.2",VIDEO="chunked"
+https://cdn.example.com/chunked/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,RESOLUTION=640x360,CODECS="avc1.4D401E,mp4a.40.2"
+/360p/index.m3u8
+`
+
+	variants, err := ParseMaster("https://example.com/master/playlist.m3u8", content)
+	if err != nil {
+		t.Fatalf("ParseMaster error: %v", err)
+	}
+
+	expected := []Variant{
+		{
+			URL:        "https://cdn.example.com/chunked/index.m3u8",
+			Name:       "1080p60 (source)",
+			Resolution: "1920x1080",
+			Bandwidth:  6000000,
+		},
+		{
+			URL:        "https://example.com/360p/index.m3u8",
+			Name:       "640x360",
+			Resolution: "640x360",
+			Bandwidth:  1000000,
+		},
+	}
+
+	if diff := pretty.Compare(variants, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestRecordLive(t *testing.T) {
+	defer func(wait time.Duration) { minReloadWait = wait }(minReloadWait)
+	minReloadWait = time.Millisecond
+
+	var polls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".ts") {
+			fmt.Fprint(w, strings.TrimSuffix(r.URL.Path[1:], ".ts"))
+			return
+		}
+
+		// sliding window of 2 segments, moving by one every poll
+		fmt.Fprintf(w, "#EXTM3U\n#EXT-X-TARGETDURATION:0.01\n#EXT-X-MEDIA-SEQUENCE:%d\n", polls)
+		for i := polls; i < polls+2; i++ {
+			fmt.Fprintf(w, "#EXTINF:1.0,\n%d.ts\n", i)
+		}
+		if polls == 3 {
+			fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+		}
+		polls++
+	}))
+	defer ts.Close()
+
+	tests := map[string]struct {
+		limit    time.Duration
+		expected string
+	}{
+		"until end":  {0, "01234"},
+		"with limit": {time.Second * 3, "012"},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			polls = 0
+			reader, writer := io.Pipe()
+			go Record(ts.URL+"/live.m3u8", tt.limit, writer)
+
+			got, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Record error: %v", err)
+			}
+
+			if string(got) != tt.expected {
+				t.Errorf("Wrong output, got: %s, expected: %s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRecordRetriesReload(t *testing.T) {
+	defer func(wait time.Duration) { minReloadWait = wait }(minReloadWait)
+	minReloadWait = time.Millisecond
+
+	var polls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".ts") {
+			fmt.Fprint(w, strings.TrimSuffix(r.URL.Path[1:], ".ts"))
+			return
+		}
+
+		polls++
+		// reloads fail twice in a row, without a target duration
+		switch polls {
+		case 1:
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1.0,\n0.ts\n")
+		case 2, 3:
+			w.WriteHeader(503)
+		default:
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:1.0,\n0.ts\n#EXTINF:1.0,\n1.ts\n#EXT-X-ENDLIST\n")
+		}
+	}))
+	defer ts.Close()
+
+	reader, writer := io.Pipe()
+	go Record(ts.URL+"/live.m3u8", 0, writer)
+
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Record error: %v", err)
+	}
+
+	if string(got) != "01" {
+		t.Errorf("Wrong output, got: %s, expected: 01", got)
+	}
+}
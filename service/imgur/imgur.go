@@ -17,24 +17,56 @@
 package imgur
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 
-	"github.com/PuerkitoBio/goquery"
 	"github.com/mlvzk/piko/service"
 )
 
-type Imgur struct{}
+type image struct {
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	Animated    bool   `json:"animated"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+	Link        string `json:"link"`
+}
+
+// galleryItem is either an album or an image, depending on IsAlbum
+type galleryItem struct {
+	image
+	IsAlbum     bool    `json:"is_album"`
+	ImagesCount int     `json:"images_count"`
+	Images      []image `json:"images"`
+}
+
+type Imgur struct {
+	clientID string
+}
 type ImgurIterator struct {
-	url  string
+	clientID   string
+	baseApiURL string
+	url        string
+	// page of user submissions and tags, starts at 0
 	page int
 	end  bool
 }
 
-func New() Imgur {
-	return Imgur{}
+func New(clientID string) Imgur {
+	return Imgur{
+		clientID: clientID,
+	}
 }
 
 type output struct {
@@ -52,17 +84,26 @@ func (s Imgur) IsValidTarget(target string) bool {
 
 func (s Imgur) FetchItems(target string) (service.ServiceIterator, error) {
 	return &ImgurIterator{
-		url:  target,
-		page: 1,
-		end:  false,
+		clientID:   s.clientID,
+		baseApiURL: "https://api.imgur.com",
+		url:        target,
 	}, nil
 }
 
 func (s Imgur) Download(meta, options map[string]string) (io.Reader, error) {
-	resp, err := http.Get(fmt.Sprintf("https://i.imgur.com/%s.%s", meta["id"], meta["ext"]))
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		downloadURL = fmt.Sprintf("https://i.imgur.com/%s.%s", meta["id"], meta["ext"])
+	}
+
+	resp, err := http.Get(downloadURL)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
 
 	if resp.ContentLength == -1 {
 		return resp.Body, nil
@@ -75,49 +116,223 @@ func (s Imgur) Download(meta, options map[string]string) (io.Reader, error) {
 }
 
 func (i *ImgurIterator) Next() ([]service.Item, error) {
-	i.end = true
-
-	resp, err := http.Get(i.url)
+	kind, name, err := parseTarget(i.url)
 	if err != nil {
+		i.end = true
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", i.url, resp.StatusCode)
-	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
-	if err != nil {
-		return nil, err
+	switch kind {
+	case "album":
+		i.end = true
+		album := galleryItem{}
+		if err := i.getJSON("/3/album/"+name, &album); err != nil {
+			return nil, err
+		}
+		album.IsAlbum = true
+
+		return i.galleryItems(album)
+	case "gallery":
+		i.end = true
+		item := galleryItem{}
+		if err := i.getJSON("/3/gallery/"+name, &item); err != nil {
+			return nil, err
+		}
+
+		return i.galleryItems(item)
+	case "image":
+		i.end = true
+		img := image{}
+		if err := i.getJSON("/3/image/"+name, &img); err != nil {
+			return nil, err
+		}
+
+		return []service.Item{newItem(img, galleryItem{}, 0)}, nil
+	case "user":
+		submissions := []galleryItem{}
+		err := i.getJSON(fmt.Sprintf("/3/account/%s/submissions/%d", name, i.page), &submissions)
+		if err != nil {
+			i.end = true
+			return nil, err
+		}
+
+		return i.pageItems(submissions)
+	case "tag":
+		tag := struct {
+			Items []galleryItem `json:"items"`
+		}{}
+		err := i.getJSON(fmt.Sprintf("/3/gallery/t/%s/time/all/%d", name, i.page), &tag)
+		if err != nil {
+			i.end = true
+			return nil, err
+		}
+
+		return i.pageItems(tag.Items)
 	}
 
-	albumTitle := doc.Find("div.post-title-container h1").Text()
+	i.end = true
+	return nil, errors.New("Unsupported imgur url: " + i.url)
+}
+
+func (i ImgurIterator) HasEnded() bool {
+	return i.end
+}
+
+// pageItems returns items of every gallery item from a page,
+// an empty page ends the iteration
+func (i *ImgurIterator) pageItems(galleryItems []galleryItem) ([]service.Item, error) {
+	i.page++
+	if len(galleryItems) == 0 {
+		i.end = true
+	}
 
 	items := []service.Item{}
-	doc.Find("div.post-images div.post-image-container").Each(func(_ int, sel *goquery.Selection) {
-		itemType, itExists := sel.Attr("itemtype")
-		id, _ := sel.Attr("id")
+	for _, galleryItem := range galleryItems {
+		newItems, err := i.galleryItems(galleryItem)
+		if err != nil {
+			return items, err
+		}
+
+		items = append(items, newItems...)
+	}
 
-		ext := "png"
-		if itExists && strings.Contains(itemType, "VideoObject") {
-			ext = "mp4"
+	return items, nil
+}
+
+func (i *ImgurIterator) galleryItems(item galleryItem) ([]service.Item, error) {
+	if !item.IsAlbum {
+		return []service.Item{newItem(item.image, item, 0)}, nil
+	}
+
+	// galleries and listings cut albums short
+	if len(item.Images) < item.ImagesCount {
+		album := galleryItem{}
+		if err := i.getJSON("/3/album/"+item.ID, &album); err != nil {
+			return nil, err
 		}
+		item.Images = album.Images
+	}
 
-		// TODO: take src from meta[@contentURL] if available
-		items = append(items, service.Item{
-			Meta: map[string]string{
-				"id":         id,
-				"ext":        ext,
-				"itemType":   itemType,
-				"albumTitle": albumTitle,
-			},
-			DefaultName: "%[id].%[ext]",
-		})
-	})
+	items := make([]service.Item, 0, len(item.Images))
+	for index, img := range item.Images {
+		items = append(items, newItem(img, item, index))
+	}
 
 	return items, nil
 }
 
-func (i ImgurIterator) HasEnded() bool {
-	return i.end
+func newItem(img image, album galleryItem, index int) service.Item {
+	// a single image post has its own title, it's not an album's
+	albumID, albumTitle := "", ""
+	if album.IsAlbum {
+		albumID, albumTitle = album.ID, album.Title
+	}
+
+	return service.Item{
+		Meta: map[string]string{
+			"id":          img.ID,
+			"ext":         extension(img),
+			"mimeType":    img.Type,
+			"title":       img.Title,
+			"description": img.Description,
+			"albumID":     albumID,
+			"albumTitle":  albumTitle,
+			"index":       strconv.Itoa(index),
+			"width":       strconv.Itoa(img.Width),
+			"height":      strconv.Itoa(img.Height),
+			"size":        strconv.Itoa(img.Size),
+			"downloadURL": img.Link,
+		},
+		DefaultName: "%[id].%[ext]",
+	}
+}
+
+var mimeExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+	"video/mp4":  "mp4",
+	"video/webm": "webm",
+}
+
+func extension(img image) string {
+	if ext, found := mimeExtensions[img.Type]; found {
+		return ext
+	}
+
+	if ext := path.Ext(img.Link); len(ext) > 1 {
+		// cut the dot
+		return ext[1:]
+	}
+
+	return "jpg"
+}
+
+// parseTarget returns kind of the target and name of the resource, kinds are:
+// album - imgur.com/a/<id>
+// gallery - imgur.com/gallery/<id>, imgur.com/t/<tag>/<id>
+// image - imgur.com/<id>, i.imgur.com/<id>.<ext>
+// user - imgur.com/user/<name>/submitted
+// tag - imgur.com/t/<tag>
+func parseTarget(target string) (kind, name string, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case len(pathParts) >= 2 && pathParts[0] == "a":
+		return "album", pathParts[1], nil
+	case len(pathParts) >= 2 && pathParts[0] == "gallery":
+		return "gallery", pathParts[1], nil
+	case len(pathParts) >= 3 && pathParts[0] == "t":
+		return "gallery", pathParts[2], nil
+	case len(pathParts) == 2 && pathParts[0] == "t":
+		return "tag", pathParts[1], nil
+	case len(pathParts) >= 2 && pathParts[0] == "user":
+		return "user", pathParts[1], nil
+	case len(pathParts) == 1 && pathParts[0] != "":
+		return "image", strings.TrimSuffix(pathParts[0], path.Ext(pathParts[0])), nil
+	}
+
+	return "", "", errors.New("Unsupported imgur url: " + target)
+}
+
+// getJSON unwraps the data field of imgur's api responses into v
+func (i *ImgurIterator) getJSON(apiPath string, v interface{}) error {
+	u := i.baseApiURL + apiPath
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Client-ID "+i.clientID)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	data := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data.Data, v)
 }
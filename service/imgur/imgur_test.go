@@ -18,6 +18,7 @@ package imgur
 
 import (
 	"flag"
+	"net/http"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
@@ -25,16 +26,17 @@ import (
 	"github.com/mlvzk/piko/service/testutil"
 )
 
-const base = "https://imgur.com"
+const base = "https://api.imgur.com"
 
 var update = flag.Bool("update", false, "update .golden files")
 
 func TestIsValidTarget(t *testing.T) {
 	tests := map[string]bool{
-		"https://imgur.com/gallery/kgIfZrm":     true,
-		"imgur.com/gallery/kgIfZrm":             true,
-		"https://imgur.com/t/article13/y2Vp0nZ": true,
-		"https://youtube.com/":                  false,
+		"https://imgur.com/gallery/kgIfZrm":        true,
+		"imgur.com/gallery/kgIfZrm":                true,
+		"https://imgur.com/t/article13/y2Vp0nZ":    true,
+		"https://imgur.com/user/someone/submitted": true,
+		"https://youtube.com/":                     false,
 	}
 
 	for target, expected := range tests {
@@ -49,7 +51,8 @@ func TestIteratorNext(t *testing.T) {
 	defer ts.Close()
 
 	iterator := ImgurIterator{
-		url: ts.URL + "/t/article13/EfY6CxU",
+		url:        "https://imgur.com/t/article13/EfY6CxU",
+		baseApiURL: ts.URL,
 	}
 
 	items, err := iterator.Next()
@@ -60,10 +63,18 @@ func TestIteratorNext(t *testing.T) {
 	expected := []service.Item{
 		{
 			Meta: map[string]string{
-				"id":         "o2nusiZ",
-				"ext":        "png",
-				"itemType":   "http://schema.org/ImageObject",
-				"albumTitle": "Some advice for those of you in the EU",
+				"id":          "o2nusiZ",
+				"ext":         "jpg",
+				"mimeType":    "image/jpeg",
+				"title":       "",
+				"description": "",
+				"albumID":     "EfY6CxU",
+				"albumTitle":  "Some advice for those of you in the EU",
+				"index":       "0",
+				"width":       "1079",
+				"height":      "603",
+				"size":        "66267",
+				"downloadURL": "https://i.imgur.com/o2nusiZ.jpg",
 			},
 			DefaultName: "%[id].%[ext]",
 		},
@@ -73,3 +84,55 @@ func TestIteratorNext(t *testing.T) {
 		t.Errorf("%s diff:\n%s", t.Name(), diff)
 	}
 }
+
+func TestIteratorNextUserSubmissions(t *testing.T) {
+	responses := map[string]string{
+		"/3/account/someone/submissions/0": `{"data":[
+			{"id":"Single1","title":"single","type":"image/gif","animated":true,"link":"https://i.imgur.com/Single1.gif","is_album":false},
+			{"id":"Album1","title":"album","is_album":true,"images_count":2,"images":[{"id":"Img1","type":"image/png","link":"https://i.imgur.com/Img1.png"}]}
+		],"success":true,"status":200}`,
+		"/3/album/Album1": `{"data":{"id":"Album1","title":"album","images_count":2,"images":[
+			{"id":"Img1","type":"image/png","link":"https://i.imgur.com/Img1.png"},
+			{"id":"Img2","type":"video/mp4","link":"https://i.imgur.com/Img2.mp4"}
+		]},"success":true,"status":200}`,
+		"/3/account/someone/submissions/1": `{"data":[],"success":true,"status":200}`,
+	}
+
+	ts := testutil.ServeResponsesBy(responses, func(r *http.Request) string {
+		if r.Header.Get("Authorization") != "Client-ID test" {
+			t.Errorf("Wrong Authorization header: %v", r.Header.Get("Authorization"))
+		}
+
+		return r.URL.Path
+	})
+	defer ts.Close()
+
+	iterator := ImgurIterator{
+		clientID:   "test",
+		baseApiURL: ts.URL,
+		url:        "https://imgur.com/user/someone/submitted",
+	}
+
+	got := [][]string{}
+	for !iterator.HasEnded() {
+		items, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+
+		page := []string{}
+		for _, item := range items {
+			page = append(page, item.Meta["albumTitle"]+":"+item.Meta["albumID"]+"/"+item.Meta["id"]+"."+item.Meta["ext"])
+		}
+		got = append(got, page)
+	}
+
+	expected := [][]string{
+		{":/Single1.gif", "album:Album1/Img1.png", "album:Album1/Img2.mp4"},
+		{},
+	}
+
+	if diff := pretty.Compare(got, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
@@ -17,14 +17,23 @@
 package instagram
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/generic"
 )
 
 type schema struct {
@@ -69,14 +78,161 @@ type schema struct {
 	Name        string `json:"name"`
 }
 
-type Instagram struct{}
+// postMedia is a partial structure of graphql's shortcode_media,
+// carousel children have the same structure
+type postMedia struct {
+	Typename   string `json:"__typename"`
+	Shortcode  string `json:"shortcode"`
+	DisplayURL string `json:"display_url"`
+	VideoURL   string `json:"video_url"`
+	IsVideo    bool   `json:"is_video"`
+	TakenAt    int64  `json:"taken_at_timestamp"`
+	Dimensions struct {
+		Height int `json:"height"`
+		Width  int `json:"width"`
+	} `json:"dimensions"`
+	Owner struct {
+		Username string `json:"username"`
+	} `json:"owner"`
+	EdgeMediaToCaption struct {
+		Edges []struct {
+			Node struct {
+				Text string `json:"text"`
+			} `json:"node"`
+		} `json:"edges"`
+	} `json:"edge_media_to_caption"`
+	EdgeSidecarToChildren struct {
+		Edges []struct {
+			Node postMedia `json:"node"`
+		} `json:"edges"`
+	} `json:"edge_sidecar_to_children"`
+}
+
+type postPage struct {
+	Graphql struct {
+		ShortcodeMedia postMedia `json:"shortcode_media"`
+	} `json:"graphql"`
+}
+
+type timelineMedia struct {
+	PageInfo struct {
+		HasNextPage bool   `json:"has_next_page"`
+		EndCursor   string `json:"end_cursor"`
+	} `json:"page_info"`
+	Edges []struct {
+		Node postMedia `json:"node"`
+	} `json:"edges"`
+}
+
+type profileUser struct {
+	ID                       string        `json:"id"`
+	Username                 string        `json:"username"`
+	EdgeOwnerToTimelineMedia timelineMedia `json:"edge_owner_to_timeline_media"`
+}
+
+type hashtag struct {
+	Name               string        `json:"name"`
+	EdgeHashtagToMedia timelineMedia `json:"edge_hashtag_to_media"`
+}
+
+type sharedData struct {
+	EntryData struct {
+		PostPage    []postPage `json:"PostPage"`
+		ProfilePage []struct {
+			Graphql struct {
+				User profileUser `json:"user"`
+			} `json:"graphql"`
+		} `json:"ProfilePage"`
+		TagPage []struct {
+			Graphql struct {
+				Hashtag hashtag `json:"hashtag"`
+			} `json:"graphql"`
+		} `json:"TagPage"`
+	} `json:"entry_data"`
+}
+
+type timelineResponse struct {
+	Data struct {
+		User struct {
+			EdgeOwnerToTimelineMedia timelineMedia `json:"edge_owner_to_timeline_media"`
+		} `json:"user"`
+	} `json:"data"`
+}
+
+type hashtagResponse struct {
+	Data struct {
+		Hashtag hashtag `json:"hashtag"`
+	} `json:"data"`
+}
+
+type highlightsResponse struct {
+	Data struct {
+		User struct {
+			EdgeHighlightReels struct {
+				Edges []struct {
+					Node struct {
+						ID    string `json:"id"`
+						Title string `json:"title"`
+					} `json:"node"`
+				} `json:"edges"`
+			} `json:"edge_highlight_reels"`
+		} `json:"user"`
+	} `json:"data"`
+}
+
+type reelsMediaResponse struct {
+	Data struct {
+		ReelsMedia []struct {
+			ID    string `json:"id"`
+			Items []struct {
+				ID             string `json:"id"`
+				DisplayURL     string `json:"display_url"`
+				IsVideo        bool   `json:"is_video"`
+				TakenAt        int64  `json:"taken_at_timestamp"`
+				VideoResources []struct {
+					Src          string `json:"src"`
+					ConfigWidth  int    `json:"config_width"`
+					ConfigHeight int    `json:"config_height"`
+				} `json:"video_resources"`
+				Dimensions struct {
+					Height int `json:"height"`
+					Width  int `json:"width"`
+				} `json:"dimensions"`
+			} `json:"items"`
+		} `json:"reels_media"`
+	} `json:"data"`
+}
+
+type Instagram struct {
+	// client of requests to instagram, with cookies of the session if logged in
+	client *http.Client
+}
 type InstagramIterator struct {
-	url string
-	end bool
+	url    string
+	end    bool
+	client *http.Client
+
+	// profile paging state, userID is empty until the profile page is fetched
+	userID     string
+	username   string
+	cursor     string
+	highlights bool
+	// tag paging state, tag is empty until the tag page is fetched
+	tag string
 }
 
 func New() Instagram {
-	return Instagram{}
+	return Instagram{
+		client: http.DefaultClient,
+	}
+}
+
+// NewWithCookies sends cookies of jar with requests to instagram,
+// media is downloaded without them
+func NewWithCookies(jar http.CookieJar) Instagram {
+	return Instagram{
+		client: &http.Client{Jar: jar},
+	}
 }
 
 type output struct {
@@ -88,18 +244,28 @@ func (o output) Size() uint64 {
 	return o.length
 }
 
+// IsValidTarget accepts posts, profiles and tags, other pages like /explore/locations/ aren't supported
 func (s Instagram) IsValidTarget(target string) bool {
-	return strings.Contains(target, "instagram.com/")
+	return strings.Contains(target, "instagram.com/") && (isPostURL(target) || isProfileURL(target) || isTagURL(target))
 }
 
 func (s Instagram) FetchItems(target string) (service.ServiceIterator, error) {
 	return &InstagramIterator{
-		url: target,
+		url:    target,
+		client: s.client,
 	}, nil
 }
 
 func (s Instagram) Download(meta, options map[string]string) (io.Reader, error) {
-	resp, err := http.Get(meta["imgURL"])
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		downloadURL, hasDownloadURL = meta["imgURL"]
+	}
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
 	if err != nil {
 		return nil, err
 	}
@@ -114,66 +280,537 @@ func (s Instagram) Download(meta, options map[string]string) (io.Reader, error)
 	}, nil
 }
 
+var (
+	sharedDataRegexp     = regexp.MustCompile(`(?s)window\._sharedData = (.*?);</script>`)
+	additionalDataRegexp = regexp.MustCompile(`(?s)window\.__additionalDataLoaded\('[^']*',(.*?)\);</script>`)
+)
+
 func (i *InstagramIterator) Next() ([]service.Item, error) {
+	if isPostURL(i.url) {
+		i.end = true
+		return i.fetchPost(i.url)
+	}
+
+	if isProfileURL(i.url) {
+		if i.highlights {
+			i.end = true
+			return i.fetchHighlights()
+		}
+
+		return i.nextProfilePage()
+	}
+
+	if isTagURL(i.url) {
+		return i.nextTagPage()
+	}
+
 	i.end = true
+	return nil, errors.New("Unsupported instagram url: " + i.url)
+}
+
+func (i InstagramIterator) HasEnded() bool {
+	return i.end
+}
 
-	resp, err := http.Get(i.url)
+func (i *InstagramIterator) fetchPost(postURL string) ([]service.Item, error) {
+	resp, err := i.client.Get(postURL)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", i.url, resp.StatusCode)
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", postURL, resp.StatusCode)
 	}
 
-	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
-	if strings.Contains(i.url, "/p/") {
-		// single image
-		urlParts := strings.Split(i.url, "/p/")
-		id := urlParts[1][:len(urlParts[1])-1]
+	media, found := findPostMedia(body)
+	if !found {
+		// the post json is missing when instagram serves a different layout,
+		// the og:image is still better than nothing
+		return ogImageItems(postURL, body)
+	}
+
+	return postItems(media), nil
+}
+
+const (
+	timelineQueryHash   = "f2405b236d85e8296cf30347c9f08c2a"
+	hashtagQueryHash    = "9b498c08113f1e09617a1703c22b2f32"
+	highlightsQueryHash = "d4d88dc1500312af6f937f7b804c68c3"
+	reelsMediaQueryHash = "45246d3fe16ccc6577e0bd297a5db1ab"
+)
+
+// nextProfilePage returns items of one page of user's posts,
+// the first page is embedded in the profile page, next ones come from graphql
+func (i *InstagramIterator) nextProfilePage() ([]service.Item, error) {
+	var (
+		timeline timelineMedia
+		err      error
+	)
+	if i.userID == "" {
+		timeline, err = i.fetchProfile()
+	} else {
+		timeline, err = i.fetchTimeline()
+	}
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
 
-		title := doc.Find(`title`).Text()
-		imgURL, imgExists := doc.Find(`meta[property="og:image"]`).Attr("content")
-		if !imgExists {
-			return nil, errors.New("Couldn't find the image url in meta tags")
+	i.cursor = timeline.PageInfo.EndCursor
+	if !timeline.PageInfo.HasNextPage || i.cursor == "" {
+		// highlights are only visible when logged in
+		if i.loggedIn() {
+			i.highlights = true
+		} else {
+			i.end = true
 		}
+	}
 
-		var author string
-		canonicalURL, hasCanonical := doc.Find(`link[rel="canonical"]`).Attr("href")
-		if hasCanonical {
-			canonicalParts := strings.Split(canonicalURL, "/")
-			if len(canonicalParts) > 3 {
-				author = canonicalParts[3]
-			}
+	return i.timelineItems(timeline)
+}
+
+// nextTagPage returns items of one page of posts with the tag,
+// like with profiles the first page is embedded in the tag page
+func (i *InstagramIterator) nextTagPage() ([]service.Item, error) {
+	var (
+		timeline timelineMedia
+		err      error
+	)
+	if i.tag == "" {
+		timeline, err = i.fetchTag()
+	} else {
+		timeline, err = i.fetchTagTimeline()
+	}
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	i.cursor = timeline.PageInfo.EndCursor
+	if !timeline.PageInfo.HasNextPage || i.cursor == "" {
+		i.end = true
+	}
+
+	return i.timelineItems(timeline)
+}
+
+func (i *InstagramIterator) timelineItems(timeline timelineMedia) ([]service.Item, error) {
+	base := baseURL(i.url)
+	items := []service.Item{}
+	var errs []string
+	for _, edge := range timeline.Edges {
+		node := edge.Node
+		// posts of tags have no username, so they're fetched like videos
+		if node.Typename == "GraphImage" && node.DisplayURL != "" && i.username != "" {
+			node.Owner.Username = i.username
+			items = append(items, postItems(node)...)
+			continue
 		}
 
-		var caption string
-		titleQuoteParts := strings.Split(title, "“")
-		if len(titleQuoteParts) != 0 {
-			caption = strings.Split(titleQuoteParts[len(titleQuoteParts)-1], "”")[0]
+		// videos and carousels are missing their media in the timeline
+		fetched, err := i.fetchPost(base + "/p/" + node.Shortcode + "/")
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
 		}
+		items = append(items, fetched...)
+	}
+
+	if len(errs) != 0 {
+		return items, errors.New("Couldn't fetch some posts: " + strings.Join(errs, "; "))
+	}
+
+	return items, nil
+}
+
+func (i *InstagramIterator) fetchProfile() (timelineMedia, error) {
+	data, err := i.fetchSharedData()
+	if err != nil {
+		return timelineMedia{}, err
+	}
+	if len(data.EntryData.ProfilePage) == 0 || data.EntryData.ProfilePage[0].Graphql.User.ID == "" {
+		return timelineMedia{}, errors.New("Couldn't find the profile json, the account might be private")
+	}
+
+	user := data.EntryData.ProfilePage[0].Graphql.User
+	i.userID, i.username = user.ID, user.Username
+
+	return user.EdgeOwnerToTimelineMedia, nil
+}
+
+func (i *InstagramIterator) fetchTag() (timelineMedia, error) {
+	data, err := i.fetchSharedData()
+	if err != nil {
+		return timelineMedia{}, err
+	}
+	if len(data.EntryData.TagPage) == 0 || data.EntryData.TagPage[0].Graphql.Hashtag.Name == "" {
+		return timelineMedia{}, errors.New("Couldn't find the tag json")
+	}
+
+	tag := data.EntryData.TagPage[0].Graphql.Hashtag
+	i.tag = tag.Name
+
+	return tag.EdgeHashtagToMedia, nil
+}
+
+func (i *InstagramIterator) fetchSharedData() (sharedData, error) {
+	resp, err := i.client.Get(i.url)
+	if err != nil {
+		return sharedData{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return sharedData{}, fmt.Errorf("GET %v returned a wrong status code - %v", i.url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return sharedData{}, err
+	}
+
+	matches := sharedDataRegexp.FindSubmatch(body)
+	if len(matches) != 2 {
+		return sharedData{}, errors.New("Couldn't find the page json")
+	}
+
+	data := sharedData{}
+	json.Unmarshal(matches[1], &data)
+
+	return data, nil
+}
+
+func (i *InstagramIterator) fetchTimeline() (timelineMedia, error) {
+	variables := fmt.Sprintf(`{"id":%q,"first":50,"after":%q}`, i.userID, i.cursor)
+
+	timeline := timelineResponse{}
+	if err := i.graphql(timelineQueryHash, variables, &timeline); err != nil {
+		return timelineMedia{}, err
+	}
+
+	return timeline.Data.User.EdgeOwnerToTimelineMedia, nil
+}
+
+func (i *InstagramIterator) fetchTagTimeline() (timelineMedia, error) {
+	variables := fmt.Sprintf(`{"tag_name":%q,"first":50,"after":%q}`, i.tag, i.cursor)
+
+	tagTimeline := hashtagResponse{}
+	if err := i.graphql(hashtagQueryHash, variables, &tagTimeline); err != nil {
+		return timelineMedia{}, err
+	}
+
+	return tagTimeline.Data.Hashtag.EdgeHashtagToMedia, nil
+}
+
+func (i *InstagramIterator) fetchHighlights() ([]service.Item, error) {
+	variables := fmt.Sprintf(`{"user_id":%q,"include_chaining":false,"include_reel":false,"include_suggested_users":false,"include_logged_out_extras":false,"include_highlight_reels":true}`, i.userID)
+
+	highlights := highlightsResponse{}
+	if err := i.graphql(highlightsQueryHash, variables, &highlights); err != nil {
+		return nil, err
+	}
+
+	titles := map[string]string{}
+	reelIDs := []string{}
+	for _, edge := range highlights.Data.User.EdgeHighlightReels.Edges {
+		titles["highlight:"+edge.Node.ID] = edge.Node.Title
+		reelIDs = append(reelIDs, edge.Node.ID)
+	}
+	if len(reelIDs) == 0 {
+		return []service.Item{}, nil
+	}
+
+	reelIDsJSON, err := json.Marshal(reelIDs)
+	if err != nil {
+		return nil, err
+	}
+	variables = fmt.Sprintf(`{"reel_ids":[],"tag_names":[],"location_ids":[],"highlight_reel_ids":%s,"precomposed_overlay":false}`, reelIDsJSON)
 
-		return []service.Item{
-			{
+	reels := reelsMediaResponse{}
+	if err := i.graphql(reelsMediaQueryHash, variables, &reels); err != nil {
+		return nil, err
+	}
+
+	items := []service.Item{}
+	for _, reel := range reels.Data.ReelsMedia {
+		for index, reelItem := range reel.Items {
+			downloadURL, mediaType := reelItem.DisplayURL, "image"
+			if reelItem.IsVideo && len(reelItem.VideoResources) > 0 {
+				// the last resource has the highest resolution
+				downloadURL, mediaType = reelItem.VideoResources[len(reelItem.VideoResources)-1].Src, "video"
+			}
+
+			items = append(items, service.Item{
 				Meta: map[string]string{
-					"imgURL":  imgURL,
-					"caption": caption,
-					"author":  author,
-					"id":      id,
-					"ext":     "jpg",
+					"downloadURL": downloadURL,
+					"imgURL":      downloadURL,
+					"author":      i.username,
+					"id":          reelItem.ID,
+					"highlight":   titles[reel.ID],
+					"index":       strconv.Itoa(index),
+					"type":        mediaType,
+					"ext":         extension(downloadURL, mediaType == "video"),
+					"width":       strconv.Itoa(reelItem.Dimensions.Width),
+					"height":      strconv.Itoa(reelItem.Dimensions.Height),
+					"postDate":    formatTimestamp(reelItem.TakenAt),
 				},
-				DefaultName: "%[author]_%[id].%[ext]",
+				DefaultName: "%[author]_%[highlight]_%[id].%[ext]",
+			})
+		}
+	}
+
+	return items, nil
+}
+
+func (i *InstagramIterator) graphql(queryHash, variables string, v interface{}) error {
+	query := url.Values{}
+	query.Set("query_hash", queryHash)
+	query.Set("variables", variables)
+	u := baseURL(i.url) + "/graphql/query/?" + query.Encode()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Requested-With", "XMLHttpRequest")
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
+
+// loggedIn checks if a session cookie was loaded, see service.LoadCookies
+func (i *InstagramIterator) loggedIn() bool {
+	if i.client.Jar == nil {
+		return false
+	}
+
+	u, err := url.Parse(baseURL(i.url))
+	if err != nil {
+		return false
+	}
+
+	for _, cookie := range i.client.Jar.Cookies(u) {
+		if cookie.Name == "sessionid" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reserved are first path segments that are not usernames
+var reserved = map[string]bool{
+	"p": true, "reel": true, "tv": true, "explore": true, "accounts": true,
+	"stories": true, "direct": true, "about": true, "developer": true,
+}
+
+func isProfileURL(target string) bool {
+	pathParts := targetPath(target)
+
+	return len(pathParts) == 1 && pathParts[0] != "" && !reserved[pathParts[0]]
+}
+
+func isTagURL(target string) bool {
+	pathParts := targetPath(target)
+
+	return len(pathParts) == 3 && pathParts[0] == "explore" && pathParts[1] == "tags" && pathParts[2] != ""
+}
+
+// targetPath returns segments of the path of target, which might be without a scheme
+func targetPath(target string) []string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if strings.Contains(pathParts[0], "instagram.com") {
+		pathParts = pathParts[1:]
+	}
+
+	return pathParts
+}
+
+func baseURL(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "https://www.instagram.com"
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+func formatTimestamp(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+
+	return time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+}
+
+func isPostURL(target string) bool {
+	return strings.Contains(target, "/p/") ||
+		strings.Contains(target, "/reel/") ||
+		strings.Contains(target, "/tv/")
+}
+
+func findPostMedia(body []byte) (postMedia, bool) {
+	if matches := sharedDataRegexp.FindSubmatch(body); len(matches) == 2 {
+		data := sharedData{}
+		json.Unmarshal(matches[1], &data)
+
+		if len(data.EntryData.PostPage) > 0 {
+			media := data.EntryData.PostPage[0].Graphql.ShortcodeMedia
+			if media.Shortcode != "" {
+				return media, true
+			}
+		}
+	}
+
+	if matches := additionalDataRegexp.FindSubmatch(body); len(matches) == 2 {
+		page := postPage{}
+		json.Unmarshal(matches[1], &page)
+
+		media := page.Graphql.ShortcodeMedia
+		if media.Shortcode != "" {
+			return media, true
+		}
+	}
+
+	return postMedia{}, false
+}
+
+// postItems returns an item for every child of a carousel
+// or a single item if media is not a carousel
+func postItems(media postMedia) []service.Item {
+	nodes := []postMedia{media}
+	if len(media.EdgeSidecarToChildren.Edges) > 0 {
+		nodes = nodes[:0]
+		for _, edge := range media.EdgeSidecarToChildren.Edges {
+			nodes = append(nodes, edge.Node)
+		}
+	}
+
+	var caption string
+	if len(media.EdgeMediaToCaption.Edges) > 0 {
+		caption = media.EdgeMediaToCaption.Edges[0].Node.Text
+	}
+
+	defaultName := "%[author]_%[id].%[ext]"
+	if len(nodes) > 1 {
+		defaultName = "%[author]_%[id]_%[index].%[ext]"
+	}
+
+	items := make([]service.Item, 0, len(nodes))
+	for index, node := range nodes {
+		downloadURL, mediaType := node.DisplayURL, "image"
+		if node.IsVideo {
+			downloadURL, mediaType = node.VideoURL, "video"
+		}
+
+		// imgURL is the old name of downloadURL, it's kept for --format strings
+		items = append(items, service.Item{
+			Meta: map[string]string{
+				"downloadURL": downloadURL,
+				"imgURL":      downloadURL,
+				"caption":     caption,
+				"author":      media.Owner.Username,
+				"id":          media.Shortcode,
+				"index":       strconv.Itoa(index),
+				"type":        mediaType,
+				"ext":         extension(downloadURL, node.IsVideo),
+				"width":       strconv.Itoa(node.Dimensions.Width),
+				"height":      strconv.Itoa(node.Dimensions.Height),
+				"shortcode":   media.Shortcode,
+				"postDate":    formatTimestamp(media.TakenAt),
 			},
-		}, nil
+			DefaultName: defaultName,
+		})
 	}
 
-	return []service.Item{}, nil
+	return items
 }
 
-func (i InstagramIterator) HasEnded() bool {
-	return i.end
+func ogImageItems(target string, body []byte) ([]service.Item, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	// the shortcode is the last segment, query like ?igshid= isn't a part of it
+	id := path.Base(strings.TrimRight(u.Path, "/"))
+
+	title := doc.Find(`title`).Text()
+	og := generic.ParseOpenGraph(doc)
+	if len(og.Images) == 0 {
+		return nil, errors.New("Couldn't find the image url in meta tags")
+	}
+	imgURL := og.Images[0]
+
+	var author string
+	canonicalURL, hasCanonical := doc.Find(`link[rel="canonical"]`).Attr("href")
+	if hasCanonical {
+		canonicalParts := strings.Split(canonicalURL, "/")
+		if len(canonicalParts) > 3 {
+			author = canonicalParts[3]
+		}
+	}
+
+	var caption string
+	titleQuoteParts := strings.Split(title, "“")
+	if len(titleQuoteParts) != 0 {
+		caption = strings.Split(titleQuoteParts[len(titleQuoteParts)-1], "”")[0]
+	}
+
+	return []service.Item{
+		{
+			Meta: map[string]string{
+				"downloadURL": imgURL,
+				"imgURL":      imgURL,
+				"caption":     caption,
+				"author":      author,
+				"id":          id,
+				"shortcode":   id,
+				"index":       "0",
+				"type":        "image",
+				"ext":         "jpg",
+			},
+			DefaultName: "%[author]_%[id].%[ext]",
+		},
+	}, nil
+}
+
+func extension(downloadURL string, isVideo bool) string {
+	if u, err := url.Parse(downloadURL); err == nil {
+		if ext := path.Ext(u.Path); len(ext) > 1 {
+			// cut the dot
+			return ext[1:]
+		}
+	}
+
+	if isVideo {
+		return "mp4"
+	}
+	return "jpg"
 }
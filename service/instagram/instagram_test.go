@@ -17,7 +17,10 @@
 package instagram
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 
@@ -34,7 +37,10 @@ func TestIsValidTarget(t *testing.T) {
 	tests := map[string]bool{
 		"https://www.instagram.com/explore/tags/cat/": true,
 		"instagram.com/explore/tags/cat/":             true,
+		"https://www.instagram.com/explore/":          false,
+		"https://www.instagram.com/":                  false,
 		"https://www.instagram.com/p/Bv3X1rVBWm5/":    true,
+		"https://www.instagram.com/reel/CB1x2yZhQ3d/": true,
 		"https://www.instagram.com/newding2/?hl=en":   true,
 		"https://youtube.com/":                        false,
 	}
@@ -51,7 +57,8 @@ func TestIteratorNext(t *testing.T) {
 	defer ts.Close()
 
 	iterator := InstagramIterator{
-		url: ts.URL + "/p/BsOGulcndj-/",
+		url:    ts.URL + "/p/BsOGulcndj-/",
+		client: http.DefaultClient,
 	}
 
 	items, err := iterator.Next()
@@ -63,19 +70,30 @@ func TestIteratorNext(t *testing.T) {
 		t.Fatalf("Items array is empty")
 	}
 
-	if correctURL := strings.Contains(items[0].Meta["imgURL"], "cdninstagram.com"); !correctURL {
-		t.Fatalf("Incorrect imgURL")
+	if correctURL := strings.Contains(items[0].Meta["downloadURL"], "cdninstagram.com"); !correctURL {
+		t.Fatalf("Incorrect downloadURL")
 	}
+	if items[0].Meta["imgURL"] != items[0].Meta["downloadURL"] {
+		t.Errorf("imgURL should be an alias of downloadURL")
+	}
+	items[0].Meta["downloadURL"] = "ignore"
 	items[0].Meta["imgURL"] = "ignore"
 
 	expected := []service.Item{
 		{
 			Meta: map[string]string{
-				"imgURL":  "ignore",
-				"caption": "Let’s set a world record together and get the most liked post on Instagram. Beating the current world record held by Kylie Jenner (18…",
-				"author":  "world_record_egg",
-				"id":      "BsOGulcndj-",
-				"ext":     "jpg",
+				"downloadURL": "ignore",
+				"imgURL":      "ignore",
+				"caption":     "Let’s set a world record together and get the most liked post on Instagram. Beating the current world record held by Kylie Jenner (18 million)! We got this 🙌\n\n#LikeTheEgg #EggSoldiers #EggGang",
+				"author":      "world_record_egg",
+				"id":          "BsOGulcndj-",
+				"index":       "0",
+				"type":        "image",
+				"ext":         "jpg",
+				"width":       "640",
+				"height":      "640",
+				"shortcode":   "BsOGulcndj-",
+				"postDate":    "2019-01-04",
 			},
 			DefaultName: "%[author]_%[id].%[ext]",
 		},
@@ -85,3 +103,205 @@ func TestIteratorNext(t *testing.T) {
 		t.Errorf("%s diff:\n%s", t.Name(), diff)
 	}
 }
+
+func TestOgImageItemsID(t *testing.T) {
+	body := []byte(`<html><head><title>User on Instagram: “caption”</title>
+		<meta property="og:image" content="https://scontent.cdninstagram.com/a.jpg">
+		<link rel="canonical" href="https://www.instagram.com/user/"></head></html>`)
+
+	items, err := ogImageItems("https://www.instagram.com/p/Bv3X1rVBWm5/?igshid=abc&hl=en", body)
+	if err != nil {
+		t.Fatalf("ogImageItems error: %v", err)
+	}
+
+	if len(items) != 1 || items[0].Meta["id"] != "Bv3X1rVBWm5" {
+		t.Errorf("Unexpected items: %+v", items)
+	}
+}
+
+func TestPostItemsCarousel(t *testing.T) {
+	mediaJSON := `{
+		"__typename": "GraphSidecar",
+		"shortcode": "BxKcSFSgxyz",
+		"taken_at_timestamp": 1557000000,
+		"owner": {"username": "someone"},
+		"edge_media_to_caption": {"edges": [{"node": {"text": "two of them"}}]},
+		"edge_sidecar_to_children": {"edges": [
+			{"node": {
+				"__typename": "GraphImage",
+				"display_url": "https://scontent.cdninstagram.com/vp/1/image_n.jpg?_nc_ht=x",
+				"is_video": false,
+				"dimensions": {"height": 1350, "width": 1080}
+			}},
+			{"node": {
+				"__typename": "GraphVideo",
+				"display_url": "https://scontent.cdninstagram.com/vp/2/thumb_n.jpg",
+				"video_url": "https://scontent.cdninstagram.com/vp/2/video_n.mp4?_nc_ht=x",
+				"is_video": true,
+				"dimensions": {"height": 750, "width": 750}
+			}}
+		]}
+	}`
+
+	media := postMedia{}
+	if err := json.Unmarshal([]byte(mediaJSON), &media); err != nil {
+		t.Fatalf("Unmarshal error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"downloadURL": "https://scontent.cdninstagram.com/vp/1/image_n.jpg?_nc_ht=x",
+				"imgURL":      "https://scontent.cdninstagram.com/vp/1/image_n.jpg?_nc_ht=x",
+				"caption":     "two of them",
+				"author":      "someone",
+				"id":          "BxKcSFSgxyz",
+				"index":       "0",
+				"type":        "image",
+				"ext":         "jpg",
+				"width":       "1080",
+				"height":      "1350",
+				"shortcode":   "BxKcSFSgxyz",
+				"postDate":    "2019-05-04",
+			},
+			DefaultName: "%[author]_%[id]_%[index].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"downloadURL": "https://scontent.cdninstagram.com/vp/2/video_n.mp4?_nc_ht=x",
+				"imgURL":      "https://scontent.cdninstagram.com/vp/2/video_n.mp4?_nc_ht=x",
+				"caption":     "two of them",
+				"author":      "someone",
+				"id":          "BxKcSFSgxyz",
+				"index":       "1",
+				"type":        "video",
+				"ext":         "mp4",
+				"width":       "750",
+				"height":      "750",
+				"shortcode":   "BxKcSFSgxyz",
+				"postDate":    "2019-05-04",
+			},
+			DefaultName: "%[author]_%[id]_%[index].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(postItems(media), expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextProfile(t *testing.T) {
+	pages := map[string]string{
+		"/someone/": `{"entry_data":{"ProfilePage":[{"graphql":{"user":{"id":"42","username":"someone","edge_owner_to_timeline_media":{
+			"page_info":{"has_next_page":true,"end_cursor":"cursor1"},
+			"edges":[
+				{"node":{"__typename":"GraphImage","shortcode":"Image1","display_url":"https://cdn.example.com/image1.jpg","taken_at_timestamp":1557000000,"dimensions":{"height":1080,"width":1080},"edge_media_to_caption":{"edges":[{"node":{"text":"first"}}]}}},
+				{"node":{"__typename":"GraphVideo","shortcode":"Video1","display_url":"https://cdn.example.com/video1-thumb.jpg","is_video":true}}
+			]}}}}]}}`,
+		"/p/Video1/": `{"entry_data":{"PostPage":[{"graphql":{"shortcode_media":{"__typename":"GraphVideo","shortcode":"Video1","video_url":"https://cdn.example.com/video1.mp4","is_video":true,"taken_at_timestamp":1556000000,"dimensions":{"height":720,"width":1280},"owner":{"username":"someone"}}}}]}}`,
+	}
+	timelinePage := `{"data":{"user":{"edge_owner_to_timeline_media":{
+		"page_info":{"has_next_page":false,"end_cursor":null},
+		"edges":[{"node":{"__typename":"GraphImage","shortcode":"Image2","display_url":"https://cdn.example.com/image2.jpg","taken_at_timestamp":1555000000,"dimensions":{"height":600,"width":800}}}]
+	}}}}`
+
+	responses := map[string]string{"/graphql/query/": timelinePage}
+	for pagePath, data := range pages {
+		responses[pagePath] = `<html><body><script type="text/javascript">window._sharedData = ` + data + `;</script></body></html>`
+	}
+
+	ts := testutil.ServeResponsesBy(responses, func(r *http.Request) string {
+		if r.URL.Path == "/graphql/query/" && !strings.Contains(r.URL.Query().Get("variables"), `"after":"cursor1"`) {
+			t.Errorf("Wrong graphql variables: %v", r.URL.Query().Get("variables"))
+		}
+
+		return r.URL.Path
+	})
+	defer ts.Close()
+
+	iterator := InstagramIterator{
+		url:    ts.URL + "/someone/",
+		client: http.DefaultClient,
+	}
+
+	var pageSizes []int
+	ids := []string{}
+	for !iterator.HasEnded() {
+		items, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+
+		pageSizes = append(pageSizes, len(items))
+		for _, item := range items {
+			ids = append(ids, item.Meta["shortcode"]+" "+item.Meta["author"]+" "+item.Meta["postDate"]+" "+item.Meta["downloadURL"])
+		}
+	}
+
+	expected := []string{
+		"Image1 someone 2019-05-04 https://cdn.example.com/image1.jpg",
+		"Video1 someone 2019-04-23 https://cdn.example.com/video1.mp4",
+		"Image2 someone 2019-04-11 https://cdn.example.com/image2.jpg",
+	}
+
+	if diff := pretty.Compare(pageSizes, []int{2, 1}); diff != "" {
+		t.Errorf("%s page sizes diff:\n%s", t.Name(), diff)
+	}
+	if diff := pretty.Compare(ids, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextTag(t *testing.T) {
+	tagPage := `{"entry_data":{"TagPage":[{"graphql":{"hashtag":{"name":"cat","edge_hashtag_to_media":{
+		"page_info":{"has_next_page":true,"end_cursor":"cursor1"},
+		"edges":[{"node":{"__typename":"GraphImage","shortcode":"Cat1","display_url":"https://cdn.example.com/cat1-thumb.jpg"}}]}}}}]}}`
+	postPage := `{"entry_data":{"PostPage":[{"graphql":{"shortcode_media":{"__typename":"GraphImage","shortcode":"%s","display_url":"https://cdn.example.com/%s.jpg","owner":{"username":"%s"}}}}]}}`
+	tagTimeline := `{"data":{"hashtag":{"name":"cat","edge_hashtag_to_media":{
+		"page_info":{"has_next_page":false,"end_cursor":null},
+		"edges":[{"node":{"__typename":"GraphImage","shortcode":"Cat2","display_url":"https://cdn.example.com/cat2-thumb.jpg"}}]}}}}`
+
+	responses := map[string]string{"/graphql/query/": tagTimeline}
+	for pagePath, data := range map[string]string{
+		"/explore/tags/cat/": tagPage,
+		"/p/Cat1/":           fmt.Sprintf(postPage, "Cat1", "cat1", "someone"),
+		"/p/Cat2/":           fmt.Sprintf(postPage, "Cat2", "cat2", "other"),
+	} {
+		responses[pagePath] = `<html><body><script type="text/javascript">window._sharedData = ` + data + `;</script></body></html>`
+	}
+
+	ts := testutil.ServeResponsesBy(responses, func(r *http.Request) string {
+		if r.URL.Path == "/graphql/query/" && r.URL.Query().Get("variables") != `{"tag_name":"cat","first":50,"after":"cursor1"}` {
+			t.Errorf("Wrong graphql variables: %v", r.URL.Query().Get("variables"))
+		}
+
+		return r.URL.Path
+	})
+	defer ts.Close()
+
+	iterator := InstagramIterator{
+		url:    ts.URL + "/explore/tags/cat/",
+		client: http.DefaultClient,
+	}
+
+	ids := []string{}
+	for !iterator.HasEnded() {
+		items, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+
+		for _, item := range items {
+			ids = append(ids, item.Meta["shortcode"]+" "+item.Meta["author"]+" "+item.Meta["downloadURL"])
+		}
+	}
+
+	// posts of tags are fetched for their authors
+	expected := []string{
+		"Cat1 someone https://cdn.example.com/cat1.jpg",
+		"Cat2 other https://cdn.example.com/cat2.jpg",
+	}
+	if diff := pretty.Compare(ids, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
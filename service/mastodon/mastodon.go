@@ -0,0 +1,359 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package mastodon
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mlvzk/piko/service"
+)
+
+type attachment struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	RemoteURL   string `json:"remote_url"`
+	Description string `json:"description"`
+}
+
+type account struct {
+	ID   string `json:"id"`
+	Acct string `json:"acct"`
+}
+
+type status struct {
+	ID               string       `json:"id"`
+	URL              string       `json:"url"`
+	CreatedAt        string       `json:"created_at"`
+	Sensitive        bool         `json:"sensitive"`
+	Account          account      `json:"account"`
+	MediaAttachments []attachment `json:"media_attachments"`
+	Reblog           *status      `json:"reblog"`
+}
+
+// Mastodon supports every instance compatible with mastodon's api,
+// instances are recognized by probing /api/v1/instance
+type Mastodon struct {
+	prober *service.HostProber
+}
+type MastodonIterator struct {
+	baseURL string
+	url     string
+	// id of the account, resolved on the first Next of account urls
+	accountID string
+	maxID     string
+	end       bool
+}
+
+func New() Mastodon {
+	return Mastodon{
+		prober: service.NewHostProber(probeInstance),
+	}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Mastodon) IsValidTarget(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if _, _, err := parseTarget(u); err != nil {
+		return false
+	}
+
+	return s.prober.IsInstance(u)
+}
+
+func probeInstance(baseURL string) bool {
+	resp, err := service.ProbeClient.Get(baseURL + "/api/v1/instance")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false
+	}
+
+	instance := struct {
+		URI     string `json:"uri"`
+		Version string `json:"version"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&instance); err != nil {
+		return false
+	}
+
+	return instance.URI != "" && instance.Version != ""
+}
+
+func (s Mastodon) FetchItems(target string) (service.ServiceIterator, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MastodonIterator{
+		baseURL: u.Scheme + "://" + u.Host,
+		url:     target,
+	}, nil
+}
+
+func (s Mastodon) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+const pageSize = 40
+
+func (i *MastodonIterator) Next() ([]service.Item, error) {
+	u, err := url.Parse(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	kind, name, err := parseTarget(u)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if kind == "status" {
+		i.end = true
+
+		st := status{}
+		if err := i.getJSON("/api/v1/statuses/"+name, &st); err != nil {
+			return nil, err
+		}
+
+		return statusItems(st), nil
+	}
+
+	if i.accountID == "" {
+		acc, err := i.lookupAccount(name)
+		if err != nil {
+			i.end = true
+			return nil, err
+		}
+		i.accountID = acc.ID
+	}
+
+	query := url.Values{}
+	query.Set("only_media", "true")
+	query.Set("limit", strconv.Itoa(pageSize))
+	if i.maxID != "" {
+		query.Set("max_id", i.maxID)
+	}
+
+	statuses := []status{}
+	err = i.getJSON("/api/v1/accounts/"+i.accountID+"/statuses?"+query.Encode(), &statuses)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if len(statuses) == 0 {
+		i.end = true
+		return []service.Item{}, nil
+	}
+	// newest first, max_id excludes the given one
+	i.maxID = statuses[len(statuses)-1].ID
+
+	items := []service.Item{}
+	for _, st := range statuses {
+		items = append(items, statusItems(st)...)
+	}
+
+	return items, nil
+}
+
+func (i MastodonIterator) HasEnded() bool {
+	return i.end
+}
+
+// lookupAccount uses /accounts/lookup, available since mastodon 3.4,
+// and falls back to searching on older instances
+func (i *MastodonIterator) lookupAccount(acct string) (account, error) {
+	acc := account{}
+	err := i.getJSON("/api/v1/accounts/lookup?acct="+url.QueryEscape(acct), &acc)
+	if err == nil && acc.ID != "" {
+		return acc, nil
+	}
+
+	accounts := []account{}
+	err = i.getJSON("/api/v1/accounts/search?limit=1&q="+url.QueryEscape(acct), &accounts)
+	if err != nil {
+		return acc, err
+	}
+	if len(accounts) == 0 {
+		return acc, errors.New("Couldn't find the account " + acct)
+	}
+
+	return accounts[0], nil
+}
+
+func statusItems(st status) []service.Item {
+	// media of boosts is in the boosted status
+	source := st
+	if st.Reblog != nil {
+		source = *st.Reblog
+	}
+
+	date := source.CreatedAt
+	if len(date) >= len("2006-01-02") {
+		date = date[:len("2006-01-02")]
+	}
+
+	items := []service.Item{}
+	for index, media := range source.MediaAttachments {
+		downloadURL := media.URL
+		if downloadURL == "" {
+			downloadURL = media.RemoteURL
+		}
+		if downloadURL == "" {
+			continue
+		}
+
+		items = append(items, service.Item{
+			Meta: map[string]string{
+				"id":          media.ID,
+				"statusID":    source.ID,
+				"statusURL":   source.URL,
+				"account":     source.Account.Acct,
+				"index":       strconv.Itoa(index),
+				"type":        media.Type,
+				"description": media.Description,
+				"date":        date,
+				"sensitive":   strconv.FormatBool(source.Sensitive),
+				"ext":         extension(downloadURL, media.Type),
+				"downloadURL": downloadURL,
+			},
+			DefaultName: "%[account]-%[statusID]-%[index].%[ext]",
+		})
+	}
+
+	return items
+}
+
+func extension(downloadURL, mediaType string) string {
+	if u, err := url.Parse(downloadURL); err == nil {
+		if ext := path.Ext(u.Path); len(ext) > 1 {
+			// cut the dot
+			return ext[1:]
+		}
+	}
+
+	switch mediaType {
+	case "video", "gifv":
+		return "mp4"
+	case "audio":
+		return "mp3"
+	}
+
+	return "jpg"
+}
+
+var (
+	// mastodon ids are numbers, pleroma's /notice/ ones aren't
+	statusIDRegexp = regexp.MustCompile(`^\d+$`)
+	noticeIDRegexp = regexp.MustCompile(`^[0-9A-Za-z]+$`)
+	userRegexp     = regexp.MustCompile(`^@[\w.\-]+(@[\w.\-]+)?$`)
+)
+
+// parseTarget returns kind of the target and its name, kinds are:
+// status - /@<user>/<id>, /users/<user>/statuses/<id>, /web/statuses/<id>, /notice/<id>, name is the id
+// account - /@<user>, /users/<user>, /web/@<user>, name is the acct without the @
+func parseTarget(u *url.URL) (kind, name string, err error) {
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if pathParts[0] == "web" {
+		pathParts = pathParts[1:]
+	}
+
+	switch {
+	case len(pathParts) == 2 && userRegexp.MatchString(pathParts[0]) && statusIDRegexp.MatchString(pathParts[1]):
+		return "status", pathParts[1], nil
+	case len(pathParts) == 4 && pathParts[0] == "users" && pathParts[2] == "statuses":
+		return "status", pathParts[3], nil
+	case len(pathParts) == 2 && pathParts[0] == "statuses" && statusIDRegexp.MatchString(pathParts[1]):
+		return "status", pathParts[1], nil
+	case len(pathParts) == 2 && pathParts[0] == "notice" && noticeIDRegexp.MatchString(pathParts[1]):
+		return "status", pathParts[1], nil
+	case len(pathParts) == 1 && userRegexp.MatchString(pathParts[0]):
+		return "account", pathParts[0][1:], nil
+	case len(pathParts) == 2 && pathParts[0] == "users":
+		return "account", pathParts[1], nil
+	}
+
+	return "", "", errors.New("Unsupported mastodon url: " + u.String())
+}
+
+func (i *MastodonIterator) getJSON(apiPath string, v interface{}) error {
+	u := i.baseURL + apiPath
+
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
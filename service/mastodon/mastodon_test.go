@@ -0,0 +1,146 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package mastodon
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/api/v1/instance": `{"uri":"social.example","title":"Example social","version":"3.0.1"}`,
+	"/api/v1/statuses/102030": `{"id":"102030","url":"https://social.example/@someone/102030","created_at":"2019-05-10T12:00:00.000Z","sensitive":true,
+		"account":{"id":"7","acct":"someone"},
+		"media_attachments":[
+			{"id":"501","type":"image","url":"https://files.social.example/media_attachments/files/000/000/501/original/cat.png","description":"A cat sleeping on a keyboard"},
+			{"id":"502","type":"gifv","url":"https://files.social.example/media_attachments/files/000/000/502/original/dog","remote_url":"https://other.example/dog.mp4","description":null}
+		]}`,
+	"/api/v1/accounts/lookup?acct=someone": `{"id":"7","acct":"someone"}`,
+	"/api/v1/accounts/7/statuses?limit=40&only_media=true": `[
+		{"id":"300","created_at":"2019-05-12T12:00:00.000Z","account":{"id":"7","acct":"someone"},"media_attachments":[],
+			"reblog":{"id":"299","url":"https://other.example/@artist/299","created_at":"2019-05-11T12:00:00.000Z","account":{"id":"9","acct":"artist@other.example"},
+				"media_attachments":[{"id":"601","type":"image","url":"https://files.social.example/cache/601.jpg","description":"Boosted drawing"}]}},
+		{"id":"200","created_at":"2019-05-01T12:00:00.000Z","account":{"id":"7","acct":"someone"},
+			"media_attachments":[{"id":"602","type":"audio","url":"https://files.social.example/602.mp3","description":""}]}
+	]`,
+	"/api/v1/accounts/7/statuses?limit=40&max_id=200&only_media=true": `[]`,
+}
+
+func TestIsValidTarget(t *testing.T) {
+	instance := testutil.ServeResponses(responses)
+	defer instance.Close()
+	notInstance := httptest.NewServer(http.NotFoundHandler())
+	defer notInstance.Close()
+
+	tests := map[string]bool{
+		instance.URL + "/@someone/102030":               true,
+		instance.URL + "/@someone":                      true,
+		instance.URL + "/users/someone/statuses/102030": true,
+		instance.URL + "/web/statuses/102030":           true,
+		instance.URL + "/about":                         false,
+		notInstance.URL + "/@someone/102030":            false,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ":   false,
+	}
+
+	s := New()
+	for target, expected := range tests {
+		if s.IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator, _ := New().FetchItems(ts.URL + "/@someone/102030")
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "501",
+				"statusID":    "102030",
+				"statusURL":   "https://social.example/@someone/102030",
+				"account":     "someone",
+				"index":       "0",
+				"type":        "image",
+				"description": "A cat sleeping on a keyboard",
+				"date":        "2019-05-10",
+				"sensitive":   "true",
+				"ext":         "png",
+				"downloadURL": "https://files.social.example/media_attachments/files/000/000/501/original/cat.png",
+			},
+			DefaultName: "%[account]-%[statusID]-%[index].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "502",
+				"statusID":    "102030",
+				"statusURL":   "https://social.example/@someone/102030",
+				"account":     "someone",
+				"index":       "1",
+				"type":        "gifv",
+				"description": "",
+				"date":        "2019-05-10",
+				"sensitive":   "true",
+				"ext":         "mp4",
+				"downloadURL": "https://files.social.example/media_attachments/files/000/000/502/original/dog",
+			},
+			DefaultName: "%[account]-%[statusID]-%[index].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextAccount(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator, _ := New().FetchItems(ts.URL + "/@someone")
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		pageItems, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		items = append(items, pageItems...)
+	}
+
+	summary := []string{}
+	for _, item := range items {
+		summary = append(summary, item.Meta["account"]+" "+item.Meta["statusID"]+" "+item.Meta["description"])
+	}
+
+	expected := []string{"artist@other.example 299 Boosted drawing", "someone 200 "}
+	if diff := pretty.Compare(summary, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
@@ -0,0 +1,375 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package peertube
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mlvzk/piko/service"
+)
+
+type videoFile struct {
+	Resolution struct {
+		ID    int    `json:"id"`
+		Label string `json:"label"`
+	} `json:"resolution"`
+	FileURL string `json:"fileUrl"`
+	Size    int64  `json:"size"`
+}
+
+type video struct {
+	UUID        string `json:"uuid"`
+	ShortUUID   string `json:"shortUUID"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Duration    int    `json:"duration"`
+	PublishedAt string `json:"publishedAt"`
+	Channel     struct {
+		DisplayName string `json:"displayName"`
+	} `json:"channel"`
+	Account struct {
+		DisplayName string `json:"displayName"`
+	} `json:"account"`
+	Files              []videoFile `json:"files"`
+	StreamingPlaylists []struct {
+		PlaylistURL string      `json:"playlistUrl"`
+		Files       []videoFile `json:"files"`
+	} `json:"streamingPlaylists"`
+}
+
+type videoList struct {
+	Total int `json:"total"`
+	// videos of channels and accounts
+	Data []json.RawMessage `json:"data"`
+}
+
+// PeerTube supports every instance, they are recognized by probing /api/v1/config
+type PeerTube struct {
+	prober *service.HostProber
+}
+type PeerTubeIterator struct {
+	baseURL string
+	url     string
+	start   int
+	end     bool
+}
+
+func New() PeerTube {
+	return PeerTube{
+		prober: service.NewHostProber(probeInstance),
+	}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s PeerTube) IsValidTarget(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if _, _, err := parseTarget(u); err != nil {
+		return false
+	}
+
+	return s.prober.IsInstance(u)
+}
+
+func probeInstance(baseURL string) bool {
+	resp, err := service.ProbeClient.Get(baseURL + "/api/v1/config")
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return false
+	}
+
+	config := struct {
+		ServerVersion string `json:"serverVersion"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&config); err != nil {
+		return false
+	}
+
+	return config.ServerVersion != ""
+}
+
+func (s PeerTube) FetchItems(target string) (service.ServiceIterator, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PeerTubeIterator{
+		baseURL: u.Scheme + "://" + u.Host,
+		url:     target,
+	}, nil
+}
+
+func (s PeerTube) Download(meta, options map[string]string) (io.Reader, error) {
+	sources := map[string]string{}
+	json.Unmarshal([]byte(meta["_sources"]), &sources)
+
+	quality := options["quality"]
+	if quality == "best" {
+		// name of the best quality, resolved in Next
+		quality = meta["_best"]
+	}
+
+	downloadURL, found := sources[quality]
+	if !found {
+		return nil, fmt.Errorf("Quality %s is not available", quality)
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+const pageSize = 50
+
+func (i *PeerTubeIterator) Next() ([]service.Item, error) {
+	u, err := url.Parse(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	kind, name, err := parseTarget(u)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if kind == "video" {
+		i.end = true
+
+		item, err := i.videoItem(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return []service.Item{item}, nil
+	}
+
+	list := videoList{}
+	listPath := fmt.Sprintf("/api/v1/%s/%s/videos?start=%d&count=%d", kind, url.PathEscape(name), i.start, pageSize)
+	if err := i.getJSON(listPath, &list); err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	i.start += len(list.Data)
+	if len(list.Data) == 0 || i.start >= list.Total {
+		i.end = true
+	}
+
+	// keep going on errors, one broken video shouldn't stop the whole list
+	var firstErr error
+	items := []service.Item{}
+	for _, data := range list.Data {
+		listed := struct {
+			UUID string `json:"uuid"`
+			// playlist elements wrap the video
+			Video *struct {
+				UUID string `json:"uuid"`
+			} `json:"video"`
+		}{}
+		json.Unmarshal(data, &listed)
+
+		uuid := listed.UUID
+		if listed.Video != nil {
+			uuid = listed.Video.UUID
+		}
+		if uuid == "" {
+			// deleted videos of playlists
+			continue
+		}
+
+		// lists don't have files, so every video is fetched
+		item, err := i.videoItem(uuid)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		items = append(items, item)
+	}
+
+	return items, firstErr
+}
+
+func (i PeerTubeIterator) HasEnded() bool {
+	return i.end
+}
+
+func (i *PeerTubeIterator) videoItem(id string) (service.Item, error) {
+	v := video{}
+	if err := i.getJSON("/api/v1/videos/"+url.PathEscape(id), &v); err != nil {
+		return service.Item{}, err
+	}
+
+	sources := map[string]string{}
+	qualities := []string{"best"}
+	best, bestResolution := "", -1
+	addFile := func(prefix string, file videoFile) {
+		if file.FileURL == "" {
+			return
+		}
+
+		quality := prefix + qualityName(file)
+		if _, exists := sources[quality]; exists {
+			return
+		}
+		sources[quality] = file.FileURL
+		qualities = append(qualities, quality)
+
+		if file.Resolution.ID > bestResolution {
+			best, bestResolution = quality, file.Resolution.ID
+		}
+	}
+
+	for _, file := range v.Files {
+		addFile("", file)
+	}
+	// files of HLS playlists are fragmented mp4s, they can be downloaded as they are
+	for _, playlist := range v.StreamingPlaylists {
+		for _, file := range playlist.Files {
+			addFile("hls-", file)
+		}
+	}
+
+	if best == "" {
+		return service.Item{}, errors.New("Couldn't find any files of the video " + id)
+	}
+
+	sourcesJSON, _ := json.Marshal(sources)
+
+	date := v.PublishedAt
+	if len(date) >= len("2006-01-02") {
+		date = date[:len("2006-01-02")]
+	}
+
+	return service.Item{
+		Meta: map[string]string{
+			"id":          v.UUID,
+			"shortUUID":   v.ShortUUID,
+			"title":       v.Name,
+			"description": v.Description,
+			"channel":     v.Channel.DisplayName,
+			"account":     v.Account.DisplayName,
+			"duration":    strconv.Itoa(v.Duration),
+			"date":        date,
+			"ext":         "mp4",
+			"_best":       best,
+			"_sources":    string(sourcesJSON),
+		},
+		DefaultName: "%[channel]-%[title].%[ext]",
+		AvailableOptions: map[string]([]string){
+			"quality": qualities,
+		},
+		DefaultOptions: map[string]string{
+			"quality": "best",
+		},
+	}, nil
+}
+
+// qualityName is the resolution like 720p, audio only files have resolution 0
+func qualityName(file videoFile) string {
+	if file.Resolution.ID == 0 {
+		return "audio"
+	}
+
+	return strconv.Itoa(file.Resolution.ID) + "p"
+}
+
+// parseTarget returns the api collection of the target and its name, kinds are:
+// video - /w/<id>, /videos/watch/<uuid>, /videos/embed/<uuid>
+// video-playlists - /w/p/<id>, /videos/watch/playlist/<uuid>
+// video-channels - /c/<name>, /video-channels/<name>
+// accounts - /a/<name>, /accounts/<name>
+func parseTarget(u *url.URL) (kind, name string, err error) {
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	switch {
+	case len(pathParts) == 3 && pathParts[0] == "w" && pathParts[1] == "p":
+		return "video-playlists", pathParts[2], nil
+	case len(pathParts) == 4 && pathParts[0] == "videos" && pathParts[1] == "watch" && pathParts[2] == "playlist":
+		return "video-playlists", pathParts[3], nil
+	case len(pathParts) == 2 && pathParts[0] == "w":
+		return "video", pathParts[1], nil
+	case len(pathParts) == 3 && pathParts[0] == "videos" && (pathParts[1] == "watch" || pathParts[1] == "embed"):
+		return "video", pathParts[2], nil
+	case len(pathParts) >= 2 && (pathParts[0] == "c" || pathParts[0] == "video-channels"):
+		return "video-channels", pathParts[1], nil
+	case len(pathParts) >= 2 && (pathParts[0] == "a" || pathParts[0] == "accounts"):
+		return "accounts", pathParts[1], nil
+	}
+
+	return "", "", errors.New("Unsupported peertube url: " + u.String())
+}
+
+func (i *PeerTubeIterator) getJSON(apiPath string, v interface{}) error {
+	u := i.baseURL + apiPath
+
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package peertube
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/api/v1/config": `{"instance":{"name":"Talks"},"serverVersion":"4.1.0"}`,
+	"/api/v1/videos/kkGMgK9ZtnKfYAgnEtQxbv": `{"uuid":"9c9de5e8-0a1e-484a-b099-e80766180a6d","shortUUID":"kkGMgK9ZtnKfYAgnEtQxbv",
+		"name":"Keynote","description":"Opening talk","duration":1800,"publishedAt":"2019-05-10T12:00:00.000Z",
+		"channel":{"displayName":"Conference"},"account":{"displayName":"Organizers"},
+		"files":[
+			{"resolution":{"id":720,"label":"720p"},"fileUrl":"https://talks.example/static/webseed/9c9d-720.mp4","size":1000},
+			{"resolution":{"id":0,"label":"Audio"},"fileUrl":"https://talks.example/static/webseed/9c9d-0.mp4","size":100}
+		],
+		"streamingPlaylists":[{"playlistUrl":"https://talks.example/static/streaming-playlists/hls/9c9d/master.m3u8","files":[
+			{"resolution":{"id":1080,"label":"1080p"},"fileUrl":"https://talks.example/static/streaming-playlists/hls/9c9d/9c9d-1080-fragmented.mp4","size":2000},
+			{"resolution":{"id":720,"label":"720p"},"fileUrl":"https://talks.example/static/streaming-playlists/hls/9c9d/9c9d-720-fragmented.mp4","size":1000}
+		]}]}`,
+	"/api/v1/video-channels/conference/videos?start=0&count=50": `{"total":2,"data":[{"uuid":"kkGMgK9ZtnKfYAgnEtQxbv"},{"uuid":"private-video"}]}`,
+	"/api/v1/video-playlists/abc/videos?start=0&count=50":       `{"total":2,"data":[{"position":1,"video":{"uuid":"kkGMgK9ZtnKfYAgnEtQxbv"}},{"position":2,"video":null}]}`,
+}
+
+func TestIsValidTarget(t *testing.T) {
+	instance := testutil.ServeResponses(responses)
+	defer instance.Close()
+	notInstance := httptest.NewServer(http.NotFoundHandler())
+	defer notInstance.Close()
+
+	tests := map[string]bool{
+		instance.URL + "/w/kkGMgK9ZtnKfYAgnEtQxbv":                          true,
+		instance.URL + "/videos/watch/9c9de5e8-0a1e-484a-b099-e80766180a6d": true,
+		instance.URL + "/w/p/abc":                                           true,
+		instance.URL + "/c/conference/videos":                               true,
+		instance.URL + "/about/instance":                                    false,
+		notInstance.URL + "/w/kkGMgK9ZtnKfYAgnEtQxbv":                       false,
+	}
+
+	s := New()
+	for target, expected := range tests {
+		if s.IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator, _ := New().FetchItems(ts.URL + "/w/kkGMgK9ZtnKfYAgnEtQxbv")
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %v", len(items))
+	}
+	items[0].Meta["_sources"] = "ignore"
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "9c9de5e8-0a1e-484a-b099-e80766180a6d",
+				"shortUUID":   "kkGMgK9ZtnKfYAgnEtQxbv",
+				"title":       "Keynote",
+				"description": "Opening talk",
+				"channel":     "Conference",
+				"account":     "Organizers",
+				"duration":    "1800",
+				"date":        "2019-05-10",
+				"ext":         "mp4",
+				"_best":       "hls-1080p",
+				"_sources":    "ignore",
+			},
+			DefaultName: "%[channel]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "720p", "audio", "hls-1080p", "hls-720p"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextLists(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	tests := map[string]bool{
+		"/c/conference/videos": true,
+		"/w/p/abc":             false,
+	}
+
+	for target, expectError := range tests {
+		iterator, _ := New().FetchItems(ts.URL + target)
+
+		items, err := iterator.Next()
+		if (err != nil) != expectError {
+			t.Errorf("Wrong error for %v: %v", target, err)
+		}
+		if !iterator.HasEnded() {
+			t.Errorf("Iterator of %v should end after the last page", target)
+		}
+		if len(items) != 1 || items[0].Meta["title"] != "Keynote" {
+			t.Errorf("Wrong items of %v: %v", target, items)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package pixiv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mlvzk/piko/service"
+)
+
+// images are served only with the website as the referer
+const referer = "https://www.pixiv.net/"
+
+type ajaxResponse struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Body    json.RawMessage `json:"body"`
+}
+
+type illust struct {
+	IllustID    string `json:"illustId"`
+	IllustTitle string `json:"illustTitle"`
+	UserID      string `json:"userId"`
+	UserName    string `json:"userName"`
+	CreateDate  string `json:"createDate"`
+	Tags        struct {
+		Tags []struct {
+			Tag string `json:"tag"`
+		} `json:"tags"`
+	} `json:"tags"`
+}
+
+type page struct {
+	URLs struct {
+		Original string `json:"original"`
+	} `json:"urls"`
+}
+
+// workIDs is an object with ids of works as keys and null values,
+// or an empty array if there are none
+type workIDs map[string]json.RawMessage
+
+func (ids *workIDs) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '[' {
+		return nil
+	}
+
+	return json.Unmarshal(data, (*map[string]json.RawMessage)(ids))
+}
+
+type profile struct {
+	Illusts workIDs `json:"illusts"`
+	Manga   workIDs `json:"manga"`
+}
+
+const worksPerPage = 20
+
+type Pixiv struct {
+	// client of ajax requests, with cookies of the session if logged in
+	client *http.Client
+}
+type PixivIterator struct {
+	baseURL string
+	url     string
+	client  *http.Client
+	// ids of remaining works of a user, fetched on the first Next
+	works   []string
+	fetched bool
+	end     bool
+}
+
+func New() Pixiv {
+	return Pixiv{
+		client: http.DefaultClient,
+	}
+}
+
+// NewWithCookies sends cookies of jar with ajax requests, for R-18 works
+func NewWithCookies(jar http.CookieJar) Pixiv {
+	return Pixiv{
+		client: &http.Client{Jar: jar},
+	}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Pixiv) IsValidTarget(target string) bool {
+	return strings.Contains(target, "pixiv.net/")
+}
+
+func (s Pixiv) FetchItems(target string) (service.ServiceIterator, error) {
+	return &PixivIterator{
+		baseURL: "https://www.pixiv.net",
+		url:     target,
+		client:  s.client,
+	}, nil
+}
+
+func (s Pixiv) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	req, err := http.NewRequest("GET", downloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Referer", referer)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *PixivIterator) Next() ([]service.Item, error) {
+	kind, id, err := parseTarget(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if kind == "work" {
+		i.end = true
+		return i.workItems(id)
+	}
+
+	if !i.fetched {
+		i.fetched = true
+
+		p := profile{}
+		if err := i.getAjax("/ajax/user/"+id+"/profile/all", &p); err != nil {
+			i.end = true
+			return nil, err
+		}
+
+		for workID := range p.Illusts {
+			i.works = append(i.works, workID)
+		}
+		for workID := range p.Manga {
+			i.works = append(i.works, workID)
+		}
+
+		// newest first
+		sort.Slice(i.works, func(a, b int) bool {
+			idA, _ := strconv.Atoi(i.works[a])
+			idB, _ := strconv.Atoi(i.works[b])
+			return idA > idB
+		})
+	}
+
+	batch := i.works
+	if len(batch) > worksPerPage {
+		batch = batch[:worksPerPage]
+	}
+	i.works = i.works[len(batch):]
+	if len(i.works) == 0 {
+		i.end = true
+	}
+
+	// keep going on errors, one deleted work shouldn't stop the whole profile
+	var firstErr error
+	items := []service.Item{}
+	for _, workID := range batch {
+		workItems, err := i.workItems(workID)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		items = append(items, workItems...)
+	}
+
+	return items, firstErr
+}
+
+func (i PixivIterator) HasEnded() bool {
+	return i.end
+}
+
+// workItems returns an item per page of the work
+func (i *PixivIterator) workItems(id string) ([]service.Item, error) {
+	work := illust{}
+	if err := i.getAjax("/ajax/illust/"+id, &work); err != nil {
+		return nil, err
+	}
+
+	pages := []page{}
+	if err := i.getAjax("/ajax/illust/"+id+"/pages", &pages); err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for _, tag := range work.Tags.Tags {
+		tags = append(tags, tag.Tag)
+	}
+
+	items := []service.Item{}
+	for index, p := range pages {
+		ext := path.Ext(p.URLs.Original)
+		if len(ext) > 1 {
+			// cut the dot
+			ext = ext[1:]
+		}
+
+		items = append(items, service.Item{
+			Meta: map[string]string{
+				"id":          id,
+				"title":       work.IllustTitle,
+				"artist":      work.UserName,
+				"artistID":    work.UserID,
+				"tags":        strings.Join(tags, ", "),
+				"date":        strings.Split(work.CreateDate, "T")[0],
+				"page":        strconv.Itoa(index),
+				"pageCount":   strconv.Itoa(len(pages)),
+				"ext":         ext,
+				"downloadURL": p.URLs.Original,
+			},
+			DefaultName: "%[artist]-%[id]_p%[page].%[ext]",
+		})
+	}
+
+	return items, nil
+}
+
+// getAjax unmarshals the body of an ajax endpoint response into v
+func (i *PixivIterator) getAjax(ajaxPath string, v interface{}) error {
+	u := i.baseURL + ajaxPath
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Referer", referer)
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	// errors come with a json message, like for works requiring login
+	response := ajaxResponse{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		if resp.StatusCode != 200 {
+			return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+		}
+		return err
+	}
+	if response.Error {
+		return fmt.Errorf("GET %v failed: %s", u, response.Message)
+	}
+
+	return json.Unmarshal(response.Body, v)
+}
+
+var (
+	artworkRegexp = regexp.MustCompile(`/artworks/(\d+)`)
+	userRegexp    = regexp.MustCompile(`/users/(\d+)`)
+)
+
+// parseTarget returns kind of the target and its id, kinds are:
+// work - pixiv.net/artworks/<id>, pixiv.net/en/artworks/<id>, pixiv.net/member_illust.php?illust_id=<id>
+// user - pixiv.net/users/<id>, pixiv.net/en/users/<id>/artworks, pixiv.net/member.php?id=<id>
+func parseTarget(target string) (kind, id string, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	if matches := artworkRegexp.FindStringSubmatch(u.Path); len(matches) == 2 {
+		return "work", matches[1], nil
+	}
+	if illustID := u.Query().Get("illust_id"); illustID != "" {
+		return "work", illustID, nil
+	}
+	if matches := userRegexp.FindStringSubmatch(u.Path); len(matches) == 2 {
+		return "user", matches[1], nil
+	}
+	if userID := u.Query().Get("id"); strings.HasSuffix(u.Path, "/member.php") && userID != "" {
+		return "user", userID, nil
+	}
+
+	return "", "", errors.New("Unsupported pixiv url: " + target)
+}
@@ -0,0 +1,151 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package pixiv
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/ajax/illust/75000000": `{"error":false,"message":"","body":{"illustId":"75000000","illustTitle":"Spring","userId":"11","userName":"painter",
+		"createDate":"2019-06-20T15:00:00+00:00","tags":{"tags":[{"tag":"landscape"},{"tag":"sakura"}]}}}`,
+	"/ajax/illust/75000000/pages": `{"error":false,"message":"","body":[
+		{"urls":{"original":"https://i.pximg.net/img-original/img/2019/06/20/15/00/00/75000000_p0.png"}},
+		{"urls":{"original":"https://i.pximg.net/img-original/img/2019/06/20/15/00/00/75000000_p1.jpg"}}]}`,
+	"/ajax/illust/74000000":     `{"error":true,"message":"Work has been deleted or the ID does not exist.","body":[]}`,
+	"/ajax/user/11/profile/all": `{"error":false,"message":"","body":{"illusts":{"74000000":null,"75000000":null},"manga":[]}}`,
+}
+
+func responseKey(t *testing.T) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if r.Referer() != referer {
+			t.Errorf("Wrong referer: %v", r.Referer())
+		}
+		return r.URL.Path
+	}
+}
+func TestParseTarget(t *testing.T) {
+	tests := map[string][2]string{
+		"https://www.pixiv.net/en/artworks/75000000":                             {"work", "75000000"},
+		"www.pixiv.net/artworks/75000000":                                        {"work", "75000000"},
+		"https://www.pixiv.net/member_illust.php?mode=medium&illust_id=75000000": {"work", "75000000"},
+		"https://www.pixiv.net/en/users/11/artworks":                             {"user", "11"},
+		"https://www.pixiv.net/member.php?id=11":                                 {"user", "11"},
+	}
+
+	for target, expected := range tests {
+		kind, id, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget error: %v, target: %v", err, target)
+			continue
+		}
+		if result := [2]string{kind, id}; result != expected {
+			t.Errorf("Invalid result, target: %v, got: %v, expected: %v", target, result, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+	defer ts.Close()
+
+	iterator := &PixivIterator{
+		baseURL: ts.URL,
+		client:  http.DefaultClient,
+		url:     "https://www.pixiv.net/en/artworks/75000000",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	meta := func(page, ext string) map[string]string {
+		return map[string]string{
+			"id":          "75000000",
+			"title":       "Spring",
+			"artist":      "painter",
+			"artistID":    "11",
+			"tags":        "landscape, sakura",
+			"date":        "2019-06-20",
+			"page":        page,
+			"pageCount":   "2",
+			"ext":         ext,
+			"downloadURL": "https://i.pximg.net/img-original/img/2019/06/20/15/00/00/75000000_p" + page + "." + ext,
+		}
+	}
+
+	expected := []service.Item{
+		{
+			Meta:        meta("0", "png"),
+			DefaultName: "%[artist]-%[id]_p%[page].%[ext]",
+		},
+		{
+			Meta:        meta("1", "jpg"),
+			DefaultName: "%[artist]-%[id]_p%[page].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextUser(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+	defer ts.Close()
+
+	iterator := &PixivIterator{
+		baseURL: ts.URL,
+		client:  http.DefaultClient,
+		url:     "https://www.pixiv.net/en/users/11",
+	}
+
+	items, err := iterator.Next()
+	if err == nil {
+		t.Errorf("Expected an error for the deleted work")
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+	if len(items) != 2 || items[0].Meta["id"] != "75000000" {
+		t.Errorf("Unexpected items: %+v", items)
+	}
+}
+
+func TestDownloadSendsReferer(t *testing.T) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+	defer ts.Close()
+
+	reader, err := New().Download(map[string]string{"downloadURL": ts.URL + "/ajax/illust/75000000"}, nil)
+	if err != nil {
+		t.Fatalf("Download error: %v", err)
+	}
+
+	if body, _ := ioutil.ReadAll(reader); len(body) == 0 {
+		t.Errorf("Expected a body")
+	}
+}
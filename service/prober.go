@@ -0,0 +1,70 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HostProber remembers which hosts run a given software,
+// for services supporting every instance of it, like mastodon
+type HostProber struct {
+	mutex sync.Mutex
+	hosts map[string]*hostProbe
+	probe func(baseURL string) bool
+}
+
+// hostProbe is the result of probing one host,
+// others wait only for the probes of their own hosts
+type hostProbe struct {
+	once       sync.Once
+	isInstance bool
+}
+
+// ProbeClient has a timeout, so unresponsive hosts don't block IsValidTarget
+var ProbeClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+// NewHostProber takes a probe function which gets the scheme and host of the target,
+// it's called at most once per host
+func NewHostProber(probe func(baseURL string) bool) *HostProber {
+	return &HostProber{
+		hosts: map[string]*hostProbe{},
+		probe: probe,
+	}
+}
+
+func (p *HostProber) IsInstance(u *url.URL) bool {
+	p.mutex.Lock()
+	host, found := p.hosts[u.Host]
+	if !found {
+		host = &hostProbe{}
+		p.hosts[u.Host] = host
+	}
+	p.mutex.Unlock()
+
+	// probed outside of the lock, so a slow host doesn't block the other ones
+	host.once.Do(func() {
+		host.isInstance = p.probe(u.Scheme + "://" + u.Host)
+	})
+
+	return host.isInstance
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package service
+
+import (
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHostProber(t *testing.T) {
+	var probes int32
+	release := make(chan struct{})
+	prober := NewHostProber(func(baseURL string) bool {
+		atomic.AddInt32(&probes, 1)
+		if baseURL == "https://slow.example.com" {
+			<-release
+		}
+		return baseURL == "https://fast.example.com"
+	})
+
+	slow, _ := url.Parse("https://slow.example.com/@someone")
+	fast, _ := url.Parse("https://fast.example.com/@someone")
+
+	slowDone := make(chan bool)
+	go func() { slowDone <- prober.IsInstance(slow) }()
+
+	fastDone := make(chan bool)
+	go func() { fastDone <- prober.IsInstance(fast) }()
+	select {
+	case isInstance := <-fastDone:
+		if !isInstance {
+			t.Errorf("fast.example.com should be an instance")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Probing of a slow host blocked another host")
+	}
+
+	close(release)
+	if <-slowDone {
+		t.Errorf("slow.example.com shouldn't be an instance")
+	}
+
+	prober.IsInstance(fast)
+	prober.IsInstance(slow)
+	if probes := atomic.LoadInt32(&probes); probes != 2 {
+		t.Errorf("Expected 2 probes, got %v", probes)
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package reddit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/dash"
+)
+
+type post struct {
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Author     string  `json:"author"`
+	Subreddit  string  `json:"subreddit"`
+	CreatedUTC float64 `json:"created_utc"`
+	URL        string  `json:"url"`
+	Domain     string  `json:"domain"`
+	IsVideo    bool    `json:"is_video"`
+	IsGallery  bool    `json:"is_gallery"`
+	Media      *struct {
+		RedditVideo *struct {
+			DashURL     string `json:"dash_url"`
+			FallbackURL string `json:"fallback_url"`
+			IsGif       bool   `json:"is_gif"`
+		} `json:"reddit_video"`
+	} `json:"media"`
+	GalleryData *struct {
+		Items []struct {
+			MediaID string `json:"media_id"`
+		} `json:"items"`
+	} `json:"gallery_data"`
+	MediaMetadata map[string]struct {
+		Status string `json:"status"`
+		Mime   string `json:"m"`
+		Source struct {
+			URL string `json:"u"`
+			Gif string `json:"gif"`
+			Mp4 string `json:"mp4"`
+		} `json:"s"`
+	} `json:"media_metadata"`
+	CrosspostParentList []post `json:"crosspost_parent_list"`
+}
+
+type listing struct {
+	Data struct {
+		After    string `json:"after"`
+		Children []struct {
+			Kind string `json:"kind"`
+			Data post   `json:"data"`
+		} `json:"children"`
+	} `json:"data"`
+}
+
+type Reddit struct {
+	imgur service.Service
+}
+type RedditIterator struct {
+	baseURL string
+	url     string
+	imgur   service.Service
+	// listing pagination token
+	after string
+	end   bool
+}
+
+// New takes the imgur service, links to imgur are delegated to it
+func New(imgur service.Service) Reddit {
+	return Reddit{
+		imgur: imgur,
+	}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Reddit) IsValidTarget(target string) bool {
+	u, err := parseURL(target)
+	if err != nil {
+		return false
+	}
+
+	// i.redd.it and preview.redd.it are direct media, not posts
+	return u.Host == "redd.it" || u.Host == "v.redd.it" ||
+		u.Host == "reddit.com" || strings.HasSuffix(u.Host, ".reddit.com")
+}
+
+func (s Reddit) FetchItems(target string) (service.ServiceIterator, error) {
+	return &RedditIterator{
+		baseURL: "https://www.reddit.com",
+		url:     target,
+		imgur:   s.imgur,
+	}, nil
+}
+
+func (s Reddit) Download(meta, options map[string]string) (io.Reader, error) {
+	if meta["_delegate"] == "imgur" {
+		return s.imgur.Download(meta, options)
+	}
+
+	if dashURL, hasDashURL := meta["_dashURL"]; hasDashURL {
+		return downloadDash(dashURL, options["quality"])
+	}
+
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	return download(downloadURL)
+}
+
+// downloadDash merges the video with its audio track,
+// without ffmpeg the video is downloaded without sound
+func downloadDash(dashURL, quality string) (io.Reader, error) {
+	content, err := dash.Fetch(dashURL)
+	if err != nil {
+		return nil, err
+	}
+
+	representations, err := dash.Parse(dashURL, content)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		video dash.Representation
+		found bool
+	)
+	if quality == "worst" {
+		video, found = dash.WorstVideo(representations)
+	} else {
+		video, found = dash.BestVideo(representations)
+	}
+	if !found {
+		return nil, errors.New("Couldn't find the video in the manifest")
+	}
+
+	audio, hasAudio := dash.BestAudio(representations)
+	if _, err := exec.LookPath("ffmpeg"); err != nil || !hasAudio {
+		return download(video.URL)
+	}
+
+	return dash.Merge(video.URL, audio.URL)
+}
+
+func download(downloadURL string) (io.Reader, error) {
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *RedditIterator) Next() ([]service.Item, error) {
+	kind, name, err := parseTarget(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	switch kind {
+	case "post":
+		i.end = true
+		listings := []listing{}
+		if err := i.getJSON("/comments/"+name+".json", url.Values{}, &listings); err != nil {
+			return nil, err
+		}
+		if len(listings) == 0 || len(listings[0].Data.Children) == 0 {
+			return nil, errors.New("Couldn't find the post " + name)
+		}
+
+		return i.postItems(listings[0].Data.Children[0].Data)
+	case "video":
+		i.end = true
+		return []service.Item{
+			newVideoItem(post{ID: name}, "https://v.redd.it/"+name+"/DASHPlaylist.mpd"),
+		}, nil
+	case "listing":
+		// keeps the sorting options, ex: ?t=week
+		u, err := parseURL(i.url)
+		if err != nil {
+			i.end = true
+			return nil, err
+		}
+		query := u.Query()
+		query.Set("limit", "100")
+		if i.after != "" {
+			query.Set("after", i.after)
+		}
+
+		page := listing{}
+		if err := i.getJSON(name+".json", query, &page); err != nil {
+			i.end = true
+			return nil, err
+		}
+
+		i.after = page.Data.After
+		if i.after == "" {
+			i.end = true
+		}
+
+		// keep going on errors, one broken post shouldn't stop the whole listing
+		var firstErr error
+		items := []service.Item{}
+		for _, child := range page.Data.Children {
+			if child.Kind != "t3" {
+				continue
+			}
+
+			postItems, err := i.postItems(child.Data)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			items = append(items, postItems...)
+		}
+
+		return items, firstErr
+	}
+
+	i.end = true
+	return nil, errors.New("Unsupported reddit url: " + i.url)
+}
+
+func (i RedditIterator) HasEnded() bool {
+	return i.end
+}
+
+// postItems returns media of the post,
+// posts without media like text posts and links to other sites have no items
+func (i *RedditIterator) postItems(p post) ([]service.Item, error) {
+	// media of crossposts is in the original post
+	source := p
+	if len(p.CrosspostParentList) > 0 {
+		source = p.CrosspostParentList[0]
+	}
+
+	switch {
+	case source.IsGallery && source.GalleryData != nil:
+		items := []service.Item{}
+		for index, galleryItem := range source.GalleryData.Items {
+			media, found := source.MediaMetadata[galleryItem.MediaID]
+			if !found || media.Status != "valid" {
+				continue
+			}
+
+			downloadURL := media.Source.URL
+			if media.Source.Mp4 != "" {
+				downloadURL = media.Source.Mp4
+			} else if media.Source.Gif != "" {
+				downloadURL = media.Source.Gif
+			}
+
+			item := newItem(p, downloadURL)
+			item.Meta["id"] = galleryItem.MediaID
+			item.Meta["index"] = strconv.Itoa(index)
+			items = append(items, item)
+		}
+
+		return items, nil
+	case source.Media != nil && source.Media.RedditVideo != nil:
+		return []service.Item{newVideoItem(p, source.Media.RedditVideo.DashURL)}, nil
+	case strings.HasSuffix(source.Domain, "imgur.com") && i.imgur != nil:
+		return i.imgurItems(p, source.URL)
+	case isDirectMedia(source.URL):
+		return []service.Item{newItem(p, source.URL)}, nil
+	}
+
+	return []service.Item{}, nil
+}
+
+func (i *RedditIterator) imgurItems(p post, target string) ([]service.Item, error) {
+	iterator, err := i.imgur.FetchItems(target)
+	if err != nil {
+		return nil, err
+	}
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		imgurItems, err := iterator.Next()
+		if err != nil {
+			return items, err
+		}
+
+		for _, item := range imgurItems {
+			for key, value := range postMeta(p) {
+				// imgur's own meta takes precedence
+				if _, exists := item.Meta[key]; !exists {
+					item.Meta[key] = value
+				}
+			}
+			item.Meta["_delegate"] = "imgur"
+
+			items = append(items, item)
+		}
+	}
+
+	return items, nil
+}
+
+func newItem(p post, downloadURL string) service.Item {
+	meta := postMeta(p)
+	meta["ext"] = extension(downloadURL)
+	switch meta["ext"] {
+	case "mp4":
+		meta["type"] = "video"
+	case "gif":
+		meta["type"] = "gif"
+	default:
+		meta["type"] = "image"
+	}
+	meta["downloadURL"] = downloadURL
+
+	return service.Item{
+		Meta:        meta,
+		DefaultName: "%[subreddit]-%[id].%[ext]",
+	}
+}
+
+func newVideoItem(p post, dashURL string) service.Item {
+	meta := postMeta(p)
+	meta["ext"] = "mp4"
+	meta["type"] = "video"
+	meta["_dashURL"] = dashURL
+
+	return service.Item{
+		Meta:        meta,
+		DefaultName: "%[subreddit]-%[id].%[ext]",
+		AvailableOptions: map[string]([]string){
+			"quality": {"best", "worst"},
+		},
+		DefaultOptions: map[string]string{
+			"quality": "best",
+		},
+	}
+}
+
+func postMeta(p post) map[string]string {
+	date := ""
+	if p.CreatedUTC != 0 {
+		date = time.Unix(int64(p.CreatedUTC), 0).UTC().Format("2006-01-02")
+	}
+
+	return map[string]string{
+		"id":        p.ID,
+		"postID":    p.ID,
+		"title":     p.Title,
+		"author":    p.Author,
+		"subreddit": p.Subreddit,
+		"date":      date,
+	}
+}
+
+var mediaExtensions = map[string]bool{
+	"jpg": true, "jpeg": true, "png": true, "gif": true, "webp": true, "mp4": true,
+}
+
+func isDirectMedia(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	return mediaExtensions[extension(target)] && u.Host != ""
+}
+
+func extension(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+
+	// previews have the real format in the query, ex: def.gif?format=mp4
+	switch format := u.Query().Get("format"); format {
+	case "":
+	case "pjpg":
+		return "jpg"
+	default:
+		return format
+	}
+
+	ext := strings.ToLower(path.Ext(u.Path))
+	if len(ext) > 1 {
+		// cut the dot
+		return ext[1:]
+	}
+
+	return ""
+}
+
+// parseTarget returns kind of the target and its name, kinds are:
+// post - reddit.com/r/<subreddit>/comments/<id>, redd.it/<id>, name is the id
+// video - v.redd.it/<id>, name is the id
+// listing - reddit.com/r/<subreddit>, reddit.com/user/<name>, name is the path of the listing
+func parseTarget(target string) (kind, name string, err error) {
+	u, err := parseURL(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch {
+	case u.Host == "v.redd.it" && pathParts[0] != "":
+		return "video", pathParts[0], nil
+	case u.Host == "redd.it" && pathParts[0] != "":
+		return "post", pathParts[0], nil
+	case len(pathParts) >= 4 && pathParts[0] == "r" && pathParts[2] == "comments":
+		return "post", pathParts[3], nil
+	case len(pathParts) >= 2 && pathParts[0] == "comments":
+		return "post", pathParts[1], nil
+	case len(pathParts) >= 2 && pathParts[0] == "r":
+		// keeps the sorting, ex: /r/pics/top
+		return "listing", "/" + strings.Join(pathParts, "/"), nil
+	case len(pathParts) >= 2 && (pathParts[0] == "user" || pathParts[0] == "u"):
+		return "listing", "/user/" + pathParts[1] + "/submitted", nil
+	}
+
+	return "", "", errors.New("Unsupported reddit url: " + target)
+}
+
+func parseURL(target string) (*url.URL, error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	return url.Parse(target)
+}
+
+// getJSON requests the .json endpoint of apiPath,
+// raw_json=1 stops reddit from escaping urls in the response
+func (i *RedditIterator) getJSON(apiPath string, query url.Values, v interface{}) error {
+	query.Set("raw_json", "1")
+	u := i.baseURL + apiPath + "?" + query.Encode()
+
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+	// reddit rate limits the default user agent of go
+	req.Header.Set("User-Agent", "piko")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
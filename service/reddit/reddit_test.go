@@ -0,0 +1,283 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package reddit
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/comments/bxyz12.json": `[{"kind":"Listing","data":{"after":null,"children":[{"kind":"t3","data":{
+		"id":"bxyz12","title":"My cats","author":"someone","subreddit":"aww","created_utc":1557000000.0,
+		"url":"https://www.reddit.com/gallery/bxyz12","domain":"reddit.com","is_gallery":true,
+		"gallery_data":{"items":[{"media_id":"abc"},{"media_id":"def"},{"media_id":"broken"}]},
+		"media_metadata":{
+			"abc":{"status":"valid","e":"Image","m":"image/jpg","s":{"u":"https://preview.redd.it/abc.jpg?width=1080&format=pjpg&s=1"}},
+			"def":{"status":"valid","e":"AnimatedImage","m":"image/gif","s":{"gif":"https://i.redd.it/def.gif","mp4":"https://preview.redd.it/def.gif?format=mp4&s=2"}},
+			"broken":{"status":"failed"}
+		}}}]}},{"kind":"Listing","data":{"children":[]}}]`,
+	"/r/aww.json?limit=100&raw_json=1": `{"kind":"Listing","data":{"after":"t3_page2","children":[
+		{"kind":"t3","data":{"id":"p1","title":"Direct image","author":"a","subreddit":"aww","created_utc":1557000000,"url":"https://i.redd.it/p1.jpg","domain":"i.redd.it"}},
+		{"kind":"t3","data":{"id":"p2","title":"Text post","author":"b","subreddit":"aww","created_utc":1557000000,"url":"https://www.reddit.com/r/aww/comments/p2/text_post/","domain":"self.aww"}},
+		{"kind":"t3","data":{"id":"p3","title":"Video","author":"c","subreddit":"aww","created_utc":1557000000,"url":"https://v.redd.it/vid3","domain":"v.redd.it","is_video":true,
+			"media":{"reddit_video":{"dash_url":"https://v.redd.it/vid3/DASHPlaylist.mpd","fallback_url":"https://v.redd.it/vid3/DASH_720.mp4"}}}}
+	]}}`,
+	"/r/aww.json?after=t3_page2&limit=100&raw_json=1": `{"kind":"Listing","data":{"after":null,"children":[
+		{"kind":"t3","data":{"id":"p4","title":"Crosspost","author":"d","subreddit":"aww","created_utc":1557000000,"url":"/r/pics/comments/p0/","domain":"self.pics",
+			"crosspost_parent_list":[{"id":"p0","subreddit":"pics","url":"https://imgur.com/a/album1","domain":"imgur.com"}]}}
+	]}}`,
+}
+
+type fakeImgur struct{}
+
+func (fakeImgur) IsValidTarget(target string) bool {
+	return true
+}
+
+func (fakeImgur) FetchItems(target string) (service.ServiceIterator, error) {
+	return &fakeImgurIterator{target: target}, nil
+}
+
+func (fakeImgur) Download(meta, options map[string]string) (io.Reader, error) {
+	return strings.NewReader(meta["id"]), nil
+}
+
+type fakeImgurIterator struct {
+	target string
+	end    bool
+}
+
+func (i *fakeImgurIterator) Next() ([]service.Item, error) {
+	i.end = true
+	return []service.Item{
+		{
+			Meta: map[string]string{
+				"id":    "img1",
+				"ext":   "png",
+				"title": "Imgur title",
+				"from":  i.target,
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+	}, nil
+}
+
+func (i *fakeImgurIterator) HasEnded() bool {
+	return i.end
+}
+
+func newTestIterator(target string) (*RedditIterator, *httptest.Server) {
+	ts := testutil.ServeResponses(responses)
+
+	return &RedditIterator{
+		baseURL: ts.URL,
+		url:     target,
+		imgur:   fakeImgur{},
+	}, ts
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://www.reddit.com/r/aww/comments/bxyz12/my_cats/": true,
+		"https://old.reddit.com/r/aww/":                         true,
+		"https://redd.it/bxyz12":                                true,
+		"https://v.redd.it/vid3":                                true,
+		"reddit.com/user/someone":                               true,
+		"https://i.redd.it/p1.jpg":                              false,
+		"https://preview.redd.it/p1.jpg?width=640":              false,
+		"https://notreddit.com/r/aww":                           false,
+		"https://imgur.com/a/album1":                            false,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ":           false,
+	}
+
+	for target, expected := range tests {
+		if (Reddit{}).IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := map[string][2]string{
+		"https://www.reddit.com/r/aww/comments/bxyz12/my_cats/": {"post", "bxyz12"},
+		"https://redd.it/bxyz12":                                {"post", "bxyz12"},
+		"https://v.redd.it/vid3":                                {"video", "vid3"},
+		"https://www.reddit.com/r/aww/":                         {"listing", "/r/aww"},
+		"https://www.reddit.com/r/aww/top/?t=all":               {"listing", "/r/aww/top"},
+		"https://www.reddit.com/u/someone":                      {"listing", "/user/someone/submitted"},
+	}
+
+	for target, expected := range tests {
+		kind, name, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget(%v) error: %v", target, err)
+			continue
+		}
+
+		if kind != expected[0] || name != expected[1] {
+			t.Errorf("Invalid result, target: %v, got: %v %v, expected: %v", target, kind, name, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	iterator, ts := newTestIterator("https://www.reddit.com/r/aww/comments/bxyz12/my_cats/")
+	defer ts.Close()
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "abc",
+				"postID":      "bxyz12",
+				"title":       "My cats",
+				"author":      "someone",
+				"subreddit":   "aww",
+				"date":        "2019-05-04",
+				"index":       "0",
+				"type":        "image",
+				"ext":         "jpg",
+				"downloadURL": "https://preview.redd.it/abc.jpg?width=1080&format=pjpg&s=1",
+			},
+			DefaultName: "%[subreddit]-%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":          "def",
+				"postID":      "bxyz12",
+				"title":       "My cats",
+				"author":      "someone",
+				"subreddit":   "aww",
+				"date":        "2019-05-04",
+				"index":       "1",
+				"type":        "video",
+				"ext":         "mp4",
+				"downloadURL": "https://preview.redd.it/def.gif?format=mp4&s=2",
+			},
+			DefaultName: "%[subreddit]-%[id].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator of a single post should end")
+	}
+}
+
+func TestIteratorNextListing(t *testing.T) {
+	iterator, ts := newTestIterator("https://www.reddit.com/r/aww/")
+	defer ts.Close()
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		pageItems, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		items = append(items, pageItems...)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":          "p1",
+				"postID":      "p1",
+				"title":       "Direct image",
+				"author":      "a",
+				"subreddit":   "aww",
+				"date":        "2019-05-04",
+				"type":        "image",
+				"ext":         "jpg",
+				"downloadURL": "https://i.redd.it/p1.jpg",
+			},
+			DefaultName: "%[subreddit]-%[id].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":        "p3",
+				"postID":    "p3",
+				"title":     "Video",
+				"author":    "c",
+				"subreddit": "aww",
+				"date":      "2019-05-04",
+				"type":      "video",
+				"ext":       "mp4",
+				"_dashURL":  "https://v.redd.it/vid3/DASHPlaylist.mpd",
+			},
+			DefaultName: "%[subreddit]-%[id].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "worst"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+		{
+			Meta: map[string]string{
+				"id":        "img1",
+				"ext":       "png",
+				"title":     "Imgur title",
+				"from":      "https://imgur.com/a/album1",
+				"postID":    "p4",
+				"author":    "d",
+				"subreddit": "aww",
+				"date":      "2019-05-04",
+				"_delegate": "imgur",
+			},
+			DefaultName: "%[id].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextListingQuery(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.RequestURI() != "/r/aww/top.json?limit=100&raw_json=1&t=week" {
+			t.Errorf("Unexpected request: %v", r.URL.RequestURI())
+		}
+		fmt.Fprint(w, `{"kind":"Listing","data":{"after":null,"children":[]}}`)
+	}))
+	defer ts.Close()
+
+	iterator := RedditIterator{
+		baseURL: ts.URL,
+		url:     "https://www.reddit.com/r/aww/top/?t=week",
+	}
+
+	if _, err := iterator.Next(); err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package streamable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mlvzk/piko/service"
+)
+
+type videoFile struct {
+	URL      string  `json:"url"`
+	Width    int     `json:"width"`
+	Height   int     `json:"height"`
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration"`
+}
+
+type video struct {
+	// 2 means the video is ready, lower values are still uploading or processing
+	Status int                  `json:"status"`
+	Title  string               `json:"title"`
+	Files  map[string]videoFile `json:"files"`
+	// missing for anonymous uploads
+	User *struct {
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+type Streamable struct{}
+type StreamableIterator struct {
+	baseAPIURL string
+	url        string
+	end        bool
+}
+
+func New() Streamable {
+	return Streamable{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Streamable) IsValidTarget(target string) bool {
+	return strings.Contains(target, "streamable.com/")
+}
+
+func (s Streamable) FetchItems(target string) (service.ServiceIterator, error) {
+	return &StreamableIterator{
+		baseAPIURL: "https://api.streamable.com",
+		url:        target,
+	}, nil
+}
+
+func (s Streamable) Download(meta, options map[string]string) (io.Reader, error) {
+	quality := options["quality"]
+	if quality == "best" {
+		// name of the best quality, resolved in Next
+		quality = meta["_best"]
+	}
+
+	files := map[string]string{}
+	json.Unmarshal([]byte(meta["_files"]), &files)
+
+	fileURL, found := files[quality]
+	if !found {
+		return nil, fmt.Errorf("Quality %s is not available", quality)
+	}
+
+	resp, err := http.Get(fileURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", fileURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *StreamableIterator) Next() ([]service.Item, error) {
+	i.end = true
+
+	shortcode, err := parseTarget(i.url)
+	if err != nil {
+		return nil, err
+	}
+
+	videoURL := i.baseAPIURL + "/videos/" + shortcode
+	resp, err := http.Get(videoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", videoURL, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	v := video{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+	if v.Status != 2 {
+		return nil, errors.New("Video " + shortcode + " is still being processed")
+	}
+
+	// highest first
+	names := []string{}
+	for name, file := range v.Files {
+		if file.URL != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("Couldn't find any files of the video " + shortcode)
+	}
+	sort.Slice(names, func(a, b int) bool {
+		if v.Files[names[a]].Height != v.Files[names[b]].Height {
+			return v.Files[names[a]].Height > v.Files[names[b]].Height
+		}
+		return names[a] < names[b]
+	})
+
+	files := map[string]string{}
+	for _, name := range names {
+		fileURL := v.Files[name].URL
+		// urls are protocol relative
+		if strings.HasPrefix(fileURL, "//") {
+			fileURL = "https:" + fileURL
+		}
+		files[name] = fileURL
+	}
+	filesJSON, _ := json.Marshal(files)
+
+	owner := ""
+	if v.User != nil {
+		owner = v.User.Username
+	}
+
+	return []service.Item{
+		{
+			Meta: map[string]string{
+				"id":       shortcode,
+				"title":    v.Title,
+				"owner":    owner,
+				"duration": strconv.Itoa(int(v.Files[names[0]].Duration)),
+				"ext":      "mp4",
+				"_best":    names[0],
+				"_files":   string(filesJSON),
+			},
+			DefaultName: "%[id]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": append([]string{"best"}, names...),
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}, nil
+}
+
+func (i StreamableIterator) HasEnded() bool {
+	return i.end
+}
+
+// parseTarget returns the shortcode of the video, supported urls:
+// streamable.com/<shortcode>, streamable.com/e/<shortcode>, streamable.com/o/<shortcode>
+func parseTarget(target string) (string, error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	shortcode := u.Path[strings.LastIndex(u.Path, "/")+1:]
+	if shortcode == "" {
+		return "", errors.New("Couldn't find the video shortcode in " + target)
+	}
+
+	return shortcode, nil
+}
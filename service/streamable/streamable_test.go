@@ -0,0 +1,109 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package streamable
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/videos/moo": `{"status":2,"title":"Goal","user":{"username":"fan"},"files":{
+		"mp4":{"url":"//cdn.streamable.com/video/mp4/moo.mp4","width":1280,"height":720,"size":1000,"duration":31.5},
+		"mp4-mobile":{"url":"//cdn.streamable.com/video/mp4-mobile/moo.mp4","width":568,"height":320,"size":300,"duration":31.5}
+	}}`,
+	"/videos/new": `{"status":1,"title":"","files":{}}`,
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := map[string]string{
+		"https://streamable.com/moo":        "moo",
+		"streamable.com/e/moo":              "moo",
+		"https://streamable.com/o/moo?t=10": "moo",
+	}
+
+	for target, expected := range tests {
+		shortcode, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget error: %v, target: %v", err, target)
+			continue
+		}
+		if shortcode != expected {
+			t.Errorf("Invalid shortcode, target: %v, got: %v, expected: %v", target, shortcode, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator := &StreamableIterator{
+		baseAPIURL: ts.URL,
+		url:        "https://streamable.com/moo",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should have ended")
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":       "moo",
+				"title":    "Goal",
+				"owner":    "fan",
+				"duration": "31",
+				"ext":      "mp4",
+				"_best":    "mp4",
+				"_files":   `{"mp4":"https://cdn.streamable.com/video/mp4/moo.mp4","mp4-mobile":"https://cdn.streamable.com/video/mp4-mobile/moo.mp4"}`,
+			},
+			DefaultName: "%[id]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "mp4", "mp4-mobile"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextProcessing(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator := &StreamableIterator{
+		baseAPIURL: ts.URL,
+		url:        "https://streamable.com/new",
+	}
+
+	if _, err := iterator.Next(); err == nil {
+		t.Errorf("Expected an error for a video that's still processing")
+	}
+}
@@ -17,6 +17,7 @@
 package testutil
 
 import (
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -62,3 +63,31 @@ func CacheHttpRequest(t *testing.T, base string, update bool) *httptest.Server {
 
 	return ts
 }
+
+// ServeResponses serves inline responses, by the request uri or, if there's none, by the path.
+// Unknown requests get a 404. {{server}} in responses is replaced with the url of the server
+func ServeResponses(responses map[string]string) *httptest.Server {
+	return ServeResponsesBy(responses, func(r *http.Request) string {
+		if _, ok := responses[r.URL.RequestURI()]; ok {
+			return r.URL.RequestURI()
+		}
+
+		return r.URL.Path
+	})
+}
+
+// ServeResponsesBy is like ServeResponses, but responses are looked up by the result of key
+func ServeResponsesBy(responses map[string]string, key func(r *http.Request) string) *httptest.Server {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, ok := responses[key(r)]
+		if !ok {
+			w.WriteHeader(404)
+			return
+		}
+
+		fmt.Fprint(w, strings.Replace(resp, "{{server}}", ts.URL, -1))
+	}))
+
+	return ts
+}
@@ -0,0 +1,372 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package tumblr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mlvzk/piko/service"
+)
+
+// flexString is a string the v1 api sometimes sends as a number
+type flexString string
+
+func (s *flexString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var str string
+		if err := json.Unmarshal(data, &str); err != nil {
+			return err
+		}
+		*s = flexString(str)
+		return nil
+	}
+
+	*s = flexString(data)
+	return nil
+}
+
+type photo struct {
+	URL1280 string `json:"photo-url-1280"`
+	URL500  string `json:"photo-url-500"`
+}
+
+type post struct {
+	ID                flexString `json:"id"`
+	URL               string     `json:"url"`
+	Type              string     `json:"type"`
+	UnixTimestamp     int64      `json:"unix-timestamp"`
+	Tags              []string   `json:"tags"`
+	RebloggedFromName string     `json:"reblogged-from-name"`
+	RebloggedRootName string     `json:"reblogged-root-name"`
+	photo
+	Photos      []photo `json:"photos"`
+	VideoPlayer string  `json:"video-player"`
+	AudioPlayer string  `json:"audio-player"`
+	AudioEmbed  string  `json:"audio-embed"`
+	RegularBody string  `json:"regular-body"`
+}
+
+type readResponse struct {
+	PostsTotal flexString `json:"posts-total"`
+	Posts      []post     `json:"posts"`
+}
+
+type Tumblr struct{}
+type TumblrIterator struct {
+	baseURL string
+	blog    string
+	// empty for whole blogs
+	postID string
+	start  int
+	end    bool
+}
+
+func New() Tumblr {
+	return Tumblr{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Tumblr) IsValidTarget(target string) bool {
+	_, _, err := parseTarget(target)
+	return err == nil
+}
+
+func (s Tumblr) FetchItems(target string) (service.ServiceIterator, error) {
+	blog, postID, err := parseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TumblrIterator{
+		baseURL: "https://" + blog + ".tumblr.com",
+		blog:    blog,
+		postID:  postID,
+	}, nil
+}
+
+func (s Tumblr) Download(meta, options map[string]string) (io.Reader, error) {
+	downloadURL, hasDownloadURL := meta["downloadURL"]
+	if !hasDownloadURL {
+		return nil, errors.New("Missing meta downloadURL")
+	}
+
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	// the upscaled variant doesn't exist for every image
+	if resp.StatusCode != 200 && meta["_fallbackURL"] != "" {
+		resp.Body.Close()
+		downloadURL = meta["_fallbackURL"]
+		resp, err = http.Get(downloadURL)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+const pageSize = 50
+
+func (i *TumblrIterator) Next() ([]service.Item, error) {
+	query := url.Values{}
+	if i.postID != "" {
+		i.end = true
+		query.Set("id", i.postID)
+	} else {
+		query.Set("start", strconv.Itoa(i.start))
+		query.Set("num", strconv.Itoa(pageSize))
+	}
+
+	page, err := i.read(query)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	i.start += pageSize
+	total, _ := strconv.Atoi(string(page.PostsTotal))
+	if len(page.Posts) == 0 || i.start >= total {
+		i.end = true
+	}
+
+	items := []service.Item{}
+	for _, p := range page.Posts {
+		items = append(items, i.postItems(p)...)
+	}
+
+	return items, nil
+}
+
+func (i TumblrIterator) HasEnded() bool {
+	return i.end
+}
+
+// read requests the v1 api, which doesn't need an api key
+// but wraps the json in javascript
+func (i *TumblrIterator) read(query url.Values) (readResponse, error) {
+	response := readResponse{}
+	u := i.baseURL + "/api/read/json?" + query.Encode()
+
+	resp, err := http.Get(u)
+	if err != nil {
+		return response, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return response, fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return response, err
+	}
+
+	// var tumblr_api_read = {...};
+	start, end := bytes.IndexByte(body, '{'), bytes.LastIndexByte(body, '}')
+	if start == -1 || end < start {
+		return response, errors.New("Couldn't find the json in the response of " + u)
+	}
+
+	err = json.Unmarshal(body[start:end+1], &response)
+	return response, err
+}
+
+var (
+	sourceRegexp    = regexp.MustCompile(`<source src="([^"]+)"`)
+	audioFileRegexp = regexp.MustCompile(`audio_file=([^&"]+)`)
+)
+
+func (i *TumblrIterator) postItems(p post) []service.Item {
+	mediaURLs := []string{}
+
+	switch p.Type {
+	case "photo":
+		photos := p.Photos
+		if len(photos) == 0 {
+			photos = []photo{p.photo}
+		}
+		for _, ph := range photos {
+			if ph.URL1280 != "" {
+				mediaURLs = append(mediaURLs, ph.URL1280)
+			} else if ph.URL500 != "" {
+				mediaURLs = append(mediaURLs, ph.URL500)
+			}
+		}
+	case "video":
+		// videos embedded from other sites have no source
+		if match := sourceRegexp.FindStringSubmatch(p.VideoPlayer); match != nil {
+			mediaURLs = append(mediaURLs, match[1])
+		}
+	case "audio":
+		if match := audioFileRegexp.FindStringSubmatch(p.AudioPlayer + p.AudioEmbed); match != nil {
+			if audioURL, err := url.QueryUnescape(match[1]); err == nil {
+				mediaURLs = append(mediaURLs, audioURL)
+			}
+		}
+	case "regular":
+		// text posts can have images inline
+		if doc, err := goquery.NewDocumentFromReader(strings.NewReader(p.RegularBody)); err == nil {
+			doc.Find("img[src]").Each(func(_ int, sel *goquery.Selection) {
+				mediaURLs = append(mediaURLs, sel.AttrOr("src", ""))
+			})
+		}
+	}
+
+	date := ""
+	if p.UnixTimestamp != 0 {
+		date = time.Unix(p.UnixTimestamp, 0).UTC().Format("2006-01-02")
+	}
+
+	items := make([]service.Item, 0, len(mediaURLs))
+	for index, mediaURL := range mediaURLs {
+		downloadURL, fallbackURL := highestResolution(mediaURL), ""
+		if downloadURL != mediaURL {
+			fallbackURL = mediaURL
+		}
+
+		items = append(items, service.Item{
+			Meta: map[string]string{
+				"id":            string(p.ID),
+				"blog":          i.blog,
+				"index":         strconv.Itoa(index),
+				"type":          p.Type,
+				"postURL":       p.URL,
+				"tags":          strings.Join(p.Tags, ","),
+				"timestamp":     strconv.FormatInt(p.UnixTimestamp, 10),
+				"date":          date,
+				"rebloggedFrom": p.RebloggedFromName,
+				"rebloggedRoot": p.RebloggedRootName,
+				"ext":           extension(downloadURL, p.Type),
+				"downloadURL":   downloadURL,
+				"_fallbackURL":  fallbackURL,
+			},
+			DefaultName: "%[blog]-%[id]-%[index].%[ext]",
+		})
+	}
+
+	return items
+}
+
+var sizeDirRegexp = regexp.MustCompile(`/s\d+x\d+/`)
+
+// highestResolution asks for a bigger variant than the api gives,
+// new style urls have the size in a directory like /s1280x1920/,
+// old style ones ending with _1280.jpg are already the biggest
+func highestResolution(imageURL string) string {
+	if !strings.Contains(imageURL, "media.tumblr.com") {
+		return imageURL
+	}
+
+	return sizeDirRegexp.ReplaceAllString(imageURL, "/s2048x3072/")
+}
+
+func extension(mediaURL, postType string) string {
+	if u, err := url.Parse(mediaURL); err == nil {
+		if ext := path.Ext(u.Path); len(ext) > 1 {
+			// cut the dot
+			return ext[1:]
+		}
+	}
+
+	switch postType {
+	case "video":
+		return "mp4"
+	case "audio":
+		return "mp3"
+	}
+
+	return "jpg"
+}
+
+// parseTarget returns the blog name and the post id, empty for whole blogs.
+// Supported urls: <blog>.tumblr.com, <blog>.tumblr.com/post/<id>,
+// tumblr.com/<blog>, tumblr.com/<blog>/<id>, tumblr.com/blog/view/<blog>/<id>
+func parseTarget(target string) (blog, postID string, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	host := strings.TrimPrefix(u.Host, "www.")
+
+	// direct links to media, ex: 64.media.tumblr.com/abc/tumblr_xyz_1280.jpg
+	if host == "media.tumblr.com" || strings.HasSuffix(host, ".media.tumblr.com") || host == "vt.tumblr.com" {
+		return "", "", errors.New("Not a tumblr blog or post url: " + target)
+	}
+
+	if host != "tumblr.com" && strings.HasSuffix(host, ".tumblr.com") {
+		blog = strings.TrimSuffix(host, ".tumblr.com")
+		if len(pathParts) >= 2 && pathParts[0] == "post" {
+			postID = pathParts[1]
+		}
+
+		return blog, postID, nil
+	}
+
+	if len(pathParts) >= 3 && pathParts[0] == "blog" && pathParts[1] == "view" {
+		pathParts = pathParts[2:]
+	}
+	if host == "tumblr.com" && pathParts[0] != "" {
+		blog = pathParts[0]
+		if len(pathParts) >= 2 {
+			postID = pathParts[1]
+		}
+
+		return blog, postID, nil
+	}
+
+	return "", "", errors.New("Unsupported tumblr url: " + target)
+}
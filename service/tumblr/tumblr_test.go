@@ -0,0 +1,215 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package tumblr
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+var responses = map[string]string{
+	"/api/read/json?num=50&start=0": `var tumblr_api_read = {"posts-start":0,"posts-total":"51","posts":[
+		{"id":"1001","url":"https://someblog.tumblr.com/post/1001","type":"photo","unix-timestamp":1557000000,"tags":["art","sketch"],
+			"reblogged-from-name":"friend","reblogged-root-name":"artist",
+			"photo-url-1280":"https://66.media.tumblr.com/abc/tumblr_first_1280.jpg",
+			"photos":[
+				{"photo-url-1280":"https://66.media.tumblr.com/abc/tumblr_first_1280.jpg"},
+				{"photo-url-1280":"https://64.media.tumblr.com/hash/hash2-d4/s1280x1920/second.png"}
+			]},
+		{"id":1002,"url":"https://someblog.tumblr.com/post/1002","type":"video","unix-timestamp":1556000000,
+			"video-player":"<video controls><source src=\"https://ve.media.tumblr.com/tumblr_video.mp4\" type=\"video/mp4\"></video>"},
+		{"id":"1003","url":"https://someblog.tumblr.com/post/1003","type":"quote","unix-timestamp":1555000000}
+	]};`,
+	"/api/read/json?num=50&start=50": `var tumblr_api_read = {"posts-start":50,"posts-total":"51","posts":[
+		{"id":"1004","url":"https://someblog.tumblr.com/post/1004","type":"audio","unix-timestamp":1554000000,
+			"audio-player":"<embed src=\"https://assets.tumblr.com/swf/audio_player.swf?audio_file=https%3A%2F%2Fwww.tumblr.com%2Faudio_file%2Fsomeblog%2F1004%2Ftumblr_song&color=FFFFFF\">"}
+	]};`,
+	"/api/read/json?id=1005": `var tumblr_api_read = {"posts-start":0,"posts-total":"1","posts":[
+		{"id":"1005","url":"https://someblog.tumblr.com/post/1005","type":"regular","unix-timestamp":1553000000,
+			"regular-body":"<p>Look</p><img src=\"https://66.media.tumblr.com/def/tumblr_inline_500.gif\">"}
+	]};`,
+}
+
+func newTestIterator(t *testing.T, postID string) (*TumblrIterator, *httptest.Server) {
+	ts := testutil.ServeResponsesBy(responses, func(r *http.Request) string {
+		if _, ok := responses[r.URL.RequestURI()]; !ok {
+			t.Errorf("Unexpected request: %v", r.URL)
+		}
+
+		return r.URL.RequestURI()
+	})
+
+	return &TumblrIterator{
+		baseURL: ts.URL,
+		blog:    "someblog",
+		postID:  postID,
+	}, ts
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://someblog.tumblr.com/post/1001":                       true,
+		"www.tumblr.com/someblog":                                     true,
+		"https://64.media.tumblr.com/abc/tumblr_xyz_1280.jpg":         false,
+		"https://vt.tumblr.com/tumblr_xyz.mp4":                        false,
+		"https://www.tumblr.com/":                                     false,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=tumblr.com": false,
+	}
+
+	for target, expected := range tests {
+		if (Tumblr{}).IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := map[string][2]string{
+		"https://someblog.tumblr.com/":                    {"someblog", ""},
+		"https://someblog.tumblr.com/post/1001/some-slug": {"someblog", "1001"},
+		"https://www.tumblr.com/someblog":                 {"someblog", ""},
+		"https://www.tumblr.com/someblog/1001":            {"someblog", "1001"},
+		"https://www.tumblr.com/blog/view/someblog/1001":  {"someblog", "1001"},
+	}
+
+	for target, expected := range tests {
+		blog, postID, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget(%v) error: %v", target, err)
+			continue
+		}
+
+		if blog != expected[0] || postID != expected[1] {
+			t.Errorf("Invalid result, target: %v, got: %v %v, expected: %v", target, blog, postID, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	iterator, ts := newTestIterator(t, "")
+	defer ts.Close()
+
+	items := []service.Item{}
+	for !iterator.HasEnded() {
+		pageItems, err := iterator.Next()
+		if err != nil {
+			t.Fatalf("iterator.Next() error: %v", err)
+		}
+		items = append(items, pageItems...)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":            "1001",
+				"blog":          "someblog",
+				"index":         "0",
+				"type":          "photo",
+				"postURL":       "https://someblog.tumblr.com/post/1001",
+				"tags":          "art,sketch",
+				"timestamp":     "1557000000",
+				"date":          "2019-05-04",
+				"rebloggedFrom": "friend",
+				"rebloggedRoot": "artist",
+				"ext":           "jpg",
+				"downloadURL":   "https://66.media.tumblr.com/abc/tumblr_first_1280.jpg",
+				"_fallbackURL":  "",
+			},
+			DefaultName: "%[blog]-%[id]-%[index].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":            "1001",
+				"blog":          "someblog",
+				"index":         "1",
+				"type":          "photo",
+				"postURL":       "https://someblog.tumblr.com/post/1001",
+				"tags":          "art,sketch",
+				"timestamp":     "1557000000",
+				"date":          "2019-05-04",
+				"rebloggedFrom": "friend",
+				"rebloggedRoot": "artist",
+				"ext":           "png",
+				"downloadURL":   "https://64.media.tumblr.com/hash/hash2-d4/s2048x3072/second.png",
+				"_fallbackURL":  "https://64.media.tumblr.com/hash/hash2-d4/s1280x1920/second.png",
+			},
+			DefaultName: "%[blog]-%[id]-%[index].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":            "1002",
+				"blog":          "someblog",
+				"index":         "0",
+				"type":          "video",
+				"postURL":       "https://someblog.tumblr.com/post/1002",
+				"tags":          "",
+				"timestamp":     "1556000000",
+				"date":          "2019-04-23",
+				"rebloggedFrom": "",
+				"rebloggedRoot": "",
+				"ext":           "mp4",
+				"downloadURL":   "https://ve.media.tumblr.com/tumblr_video.mp4",
+				"_fallbackURL":  "",
+			},
+			DefaultName: "%[blog]-%[id]-%[index].%[ext]",
+		},
+		{
+			Meta: map[string]string{
+				"id":            "1004",
+				"blog":          "someblog",
+				"index":         "0",
+				"type":          "audio",
+				"postURL":       "https://someblog.tumblr.com/post/1004",
+				"tags":          "",
+				"timestamp":     "1554000000",
+				"date":          "2019-03-31",
+				"rebloggedFrom": "",
+				"rebloggedRoot": "",
+				"ext":           "mp3",
+				"downloadURL":   "https://www.tumblr.com/audio_file/someblog/1004/tumblr_song",
+				"_fallbackURL":  "",
+			},
+			DefaultName: "%[blog]-%[id]-%[index].%[ext]",
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextPost(t *testing.T) {
+	iterator, ts := newTestIterator(t, "1005")
+	defer ts.Close()
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator of a single post should end")
+	}
+
+	if len(items) != 1 || items[0].Meta["downloadURL"] != "https://66.media.tumblr.com/def/tumblr_inline_500.gif" || items[0].Meta["ext"] != "gif" {
+		t.Errorf("Wrong items: %v", items)
+	}
+}
@@ -0,0 +1,547 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package twitch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/hls"
+)
+
+// twitch's public api doesn't give out playlists,
+// so everything goes through the gql api of the website
+const (
+	videoQuery = `query VideoMetadata($id: ID!) {
+	video(id: $id) { title createdAt owner { displayName } }
+}`
+	streamQuery = `query StreamMetadata($login: String!) {
+	user(login: $login) { displayName broadcastSettings { title } stream { createdAt } }
+}`
+	clipQuery = `query ClipMetadata($slug: ID!) {
+	clip(slug: $slug) {
+		title createdAt broadcaster { displayName }
+		videoQualities { quality frameRate sourceURL }
+		playbackAccessToken(params: {platform: "web", playerBackend: "mediaplayer", playerType: "site"}) { value signature }
+	}
+}`
+	playbackAccessTokenQuery = `query PlaybackAccessToken($login: String!, $isLive: Boolean!, $vodID: ID!, $isVod: Boolean!) {
+	streamPlaybackAccessToken(channelName: $login, params: {platform: "web", playerBackend: "mediaplayer", playerType: "site"}) @include(if: $isLive) { value signature }
+	videoPlaybackAccessToken(id: $vodID, params: {platform: "web", playerBackend: "mediaplayer", playerType: "site"}) @include(if: $isVod) { value signature }
+}`
+)
+
+type gqlRequest struct {
+	OperationName string                 `json:"operationName"`
+	Query         string                 `json:"query"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+type gqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+type accessToken struct {
+	Value     string `json:"value"`
+	Signature string `json:"signature"`
+}
+
+type videoData struct {
+	Video *struct {
+		Title     string `json:"title"`
+		CreatedAt string `json:"createdAt"`
+		Owner     struct {
+			DisplayName string `json:"displayName"`
+		} `json:"owner"`
+	} `json:"video"`
+}
+
+type streamData struct {
+	User *struct {
+		DisplayName       string `json:"displayName"`
+		BroadcastSettings struct {
+			Title string `json:"title"`
+		} `json:"broadcastSettings"`
+		Stream *struct {
+			CreatedAt string `json:"createdAt"`
+		} `json:"stream"`
+	} `json:"user"`
+}
+
+type clipData struct {
+	Clip *struct {
+		Title       string `json:"title"`
+		CreatedAt   string `json:"createdAt"`
+		Broadcaster struct {
+			DisplayName string `json:"displayName"`
+		} `json:"broadcaster"`
+		VideoQualities []struct {
+			Quality   string  `json:"quality"`
+			FrameRate float64 `json:"frameRate"`
+			SourceURL string  `json:"sourceURL"`
+		} `json:"videoQualities"`
+		PlaybackAccessToken accessToken `json:"playbackAccessToken"`
+	} `json:"clip"`
+}
+
+type playbackAccessTokenData struct {
+	StreamPlaybackAccessToken *accessToken `json:"streamPlaybackAccessToken"`
+	VideoPlaybackAccessToken  *accessToken `json:"videoPlaybackAccessToken"`
+}
+
+type Twitch struct {
+	clientID string
+}
+type TwitchIterator struct {
+	clientID     string
+	baseGqlURL   string
+	baseUsherURL string
+	url          string
+	end          bool
+}
+
+func New(clientID string) Twitch {
+	return Twitch{
+		clientID: clientID,
+	}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+type targetKind int
+
+const (
+	liveTarget targetKind = iota
+	vodTarget
+	clipTarget
+)
+
+var (
+	channelRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]{2,25}$`)
+	vodRegexp     = regexp.MustCompile(`^[0-9]+$`)
+	clipRegexp    = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// pages of the website which look like channels
+var reservedPaths = map[string]bool{
+	"directory": true, "settings": true, "search": true, "downloads": true, "jobs": true,
+	"p": true, "store": true, "turbo": true, "prime": true, "subscriptions": true,
+	"inventory": true, "wallet": true, "friends": true, "messages": true, "payments": true,
+	"login": true, "signup": true, "logout": true, "videos": true, "following": true,
+}
+
+// parseTarget returns the kind of the target and the channel name, vod id or clip slug.
+// Only channels, /videos/<id> and clips are supported
+func parseTarget(target string) (targetKind, string, error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return 0, "", err
+	}
+
+	unsupported := errors.New("Unsupported twitch url: " + target)
+
+	host := strings.TrimPrefix(strings.TrimPrefix(u.Hostname(), "www."), "m.")
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if host == "clips.twitch.tv" {
+		if len(pathParts) == 1 && clipRegexp.MatchString(pathParts[0]) {
+			return clipTarget, pathParts[0], nil
+		}
+
+		return 0, "", unsupported
+	}
+	if host != "twitch.tv" {
+		return 0, "", unsupported
+	}
+
+	switch {
+	case len(pathParts) == 2 && pathParts[0] == "videos" && vodRegexp.MatchString(pathParts[1]):
+		return vodTarget, pathParts[1], nil
+	case len(pathParts) == 3 && pathParts[1] == "clip" && clipRegexp.MatchString(pathParts[2]):
+		return clipTarget, pathParts[2], nil
+	case len(pathParts) == 1 && channelRegexp.MatchString(pathParts[0]) && !reservedPaths[strings.ToLower(pathParts[0])]:
+		return liveTarget, strings.ToLower(pathParts[0]), nil
+	}
+
+	return 0, "", unsupported
+}
+
+func (s Twitch) IsValidTarget(target string) bool {
+	_, _, err := parseTarget(target)
+	return err == nil
+}
+
+func (s Twitch) FetchItems(target string) (service.ServiceIterator, error) {
+	return &TwitchIterator{
+		clientID:     s.clientID,
+		baseGqlURL:   "https://gql.twitch.tv/gql",
+		baseUsherURL: "https://usher.ttvnw.net",
+		url:          target,
+	}, nil
+}
+
+func (s Twitch) Download(meta, options map[string]string) (io.Reader, error) {
+	quality := options["quality"]
+
+	if meta["type"] == "clip" {
+		sources := map[string]string{}
+		json.Unmarshal([]byte(meta["_sources"]), &sources)
+
+		source, hasSource := sources[quality]
+		if !hasSource {
+			return nil, fmt.Errorf("Quality %s is not available", quality)
+		}
+
+		resp, err := http.Get(source)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %v returned a wrong status code - %v", source, resp.StatusCode)
+		}
+
+		if resp.ContentLength == -1 {
+			return resp.Body, nil
+		}
+
+		return output{
+			ReadCloser: resp.Body,
+			length:     uint64(resp.ContentLength),
+		}, nil
+	}
+
+	variants := []hls.Variant{}
+	json.Unmarshal([]byte(meta["_variants"]), &variants)
+	if len(variants) == 0 {
+		return nil, errors.New("Missing meta _variants")
+	}
+
+	var variant hls.Variant
+	switch quality {
+	case "best":
+		variant = hls.Best(variants)
+	case "worst":
+		variant = hls.Worst(variants)
+	default:
+		for _, v := range variants {
+			if qualityName(v) == quality {
+				variant = v
+			}
+		}
+	}
+	if variant.URL == "" {
+		return nil, fmt.Errorf("Quality %s is not available", quality)
+	}
+
+	limit, _ := time.ParseDuration(options["duration"])
+
+	reader, writer := io.Pipe()
+	go hls.Record(variant.URL, limit, writer)
+
+	return reader, nil
+}
+
+func (i *TwitchIterator) Next() ([]service.Item, error) {
+	i.end = true
+
+	kind, name, err := parseTarget(i.url)
+	if err != nil {
+		return nil, err
+	}
+
+	switch kind {
+	case vodTarget:
+		return i.vod(name)
+	case clipTarget:
+		return i.clip(name)
+	}
+
+	return i.live(name)
+}
+
+func (i TwitchIterator) HasEnded() bool {
+	return i.end
+}
+
+func (i *TwitchIterator) vod(id string) ([]service.Item, error) {
+	data := videoData{}
+	err := i.gql("VideoMetadata", videoQuery, map[string]interface{}{"id": id}, &data)
+	if err != nil {
+		return nil, err
+	}
+	if data.Video == nil {
+		return nil, fmt.Errorf("Video %s doesn't exist", id)
+	}
+
+	token, err := i.playbackAccessToken("", id)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("token", token.Value)
+	query.Set("sig", token.Signature)
+	query.Set("allow_source", "true")
+	query.Set("allow_audio_only", "true")
+
+	variants, err := fetchVariants(i.baseUsherURL + "/vod/" + id + ".m3u8?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return []service.Item{
+		newHlsItem(map[string]string{
+			"id":      id,
+			"type":    "vod",
+			"title":   data.Video.Title,
+			"channel": data.Video.Owner.DisplayName,
+			"date":    data.Video.CreatedAt,
+		}, variants),
+	}, nil
+}
+
+func (i *TwitchIterator) live(channel string) ([]service.Item, error) {
+	data := streamData{}
+	err := i.gql("StreamMetadata", streamQuery, map[string]interface{}{"login": channel}, &data)
+	if err != nil {
+		return nil, err
+	}
+	if data.User == nil {
+		return nil, fmt.Errorf("Channel %s doesn't exist", channel)
+	}
+	if data.User.Stream == nil {
+		return nil, fmt.Errorf("Channel %s is offline", channel)
+	}
+
+	token, err := i.playbackAccessToken(channel, "")
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("token", token.Value)
+	query.Set("sig", token.Signature)
+	query.Set("allow_source", "true")
+	query.Set("allow_audio_only", "true")
+	query.Set("type", "any")
+
+	variants, err := fetchVariants(i.baseUsherURL + "/api/channel/hls/" + channel + ".m3u8?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return []service.Item{
+		newHlsItem(map[string]string{
+			"id":      channel,
+			"type":    "live",
+			"title":   data.User.BroadcastSettings.Title,
+			"channel": data.User.DisplayName,
+			"date":    data.User.Stream.CreatedAt,
+		}, variants),
+	}, nil
+}
+
+func (i *TwitchIterator) clip(slug string) ([]service.Item, error) {
+	data := clipData{}
+	err := i.gql("ClipMetadata", clipQuery, map[string]interface{}{"slug": slug}, &data)
+	if err != nil {
+		return nil, err
+	}
+	if data.Clip == nil {
+		return nil, fmt.Errorf("Clip %s doesn't exist", slug)
+	}
+	if len(data.Clip.VideoQualities) == 0 {
+		return nil, errors.New("Couldn't find any sources of the clip")
+	}
+
+	// sources only play with the token of the clip
+	tokenQuery := url.Values{}
+	tokenQuery.Set("token", data.Clip.PlaybackAccessToken.Value)
+	tokenQuery.Set("sig", data.Clip.PlaybackAccessToken.Signature)
+
+	sources := map[string]string{}
+	qualities := []string{}
+	for _, option := range data.Clip.VideoQualities {
+		quality := option.Quality + "p" + strconv.Itoa(int(option.FrameRate))
+		sources[quality] = option.SourceURL + "?" + tokenQuery.Encode()
+		qualities = append(qualities, quality)
+	}
+	sourcesJSON, err := json.Marshal(sources)
+	if err != nil {
+		return nil, err
+	}
+
+	return []service.Item{
+		{
+			Meta: map[string]string{
+				"id":       slug,
+				"type":     "clip",
+				"title":    data.Clip.Title,
+				"channel":  data.Clip.Broadcaster.DisplayName,
+				"date":     data.Clip.CreatedAt,
+				"ext":      "mp4",
+				"_sources": string(sourcesJSON),
+			},
+			DefaultName: "%[channel]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": qualities,
+			},
+			DefaultOptions: map[string]string{
+				// twitch lists the best quality first
+				"quality": qualities[0],
+			},
+		},
+	}, nil
+}
+
+// playbackAccessToken returns the token of the live stream of channel or of the vod
+func (i *TwitchIterator) playbackAccessToken(channel, vodID string) (accessToken, error) {
+	data := playbackAccessTokenData{}
+	err := i.gql("PlaybackAccessToken", playbackAccessTokenQuery, map[string]interface{}{
+		"login":  channel,
+		"isLive": channel != "",
+		"vodID":  vodID,
+		"isVod":  vodID != "",
+	}, &data)
+	if err != nil {
+		return accessToken{}, err
+	}
+
+	token := data.StreamPlaybackAccessToken
+	if vodID != "" {
+		token = data.VideoPlaybackAccessToken
+	}
+	if token == nil {
+		return accessToken{}, errors.New("Couldn't get a playback access token")
+	}
+
+	return *token, nil
+}
+
+func (i *TwitchIterator) gql(operationName, query string, variables map[string]interface{}, v interface{}) error {
+	body, err := json.Marshal(gqlRequest{
+		OperationName: operationName,
+		Query:         query,
+		Variables:     variables,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", i.baseGqlURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Client-ID", i.clientID)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("POST %v returned a wrong status code - %v", i.baseGqlURL, resp.StatusCode)
+	}
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	gqlResp := gqlResponse{}
+	if err := json.Unmarshal(respBody, &gqlResp); err != nil {
+		return err
+	}
+	if len(gqlResp.Errors) != 0 {
+		return fmt.Errorf("%s query failed: %s", operationName, gqlResp.Errors[0].Message)
+	}
+
+	return json.Unmarshal(gqlResp.Data, v)
+}
+
+func fetchVariants(playlistURL string) ([]hls.Variant, error) {
+	content, err := hls.Fetch(playlistURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return hls.ParseMaster(playlistURL, content)
+}
+
+func newHlsItem(meta map[string]string, variants []hls.Variant) service.Item {
+	variantsJSON, _ := json.Marshal(variants)
+	meta["ext"] = "ts"
+	meta["_variants"] = string(variantsJSON)
+
+	qualities := []string{"best", "worst"}
+	for _, v := range variants {
+		qualities = append(qualities, qualityName(v))
+	}
+
+	return service.Item{
+		Meta:        meta,
+		DefaultName: "%[channel]-%[title].%[ext]",
+		AvailableOptions: map[string]([]string){
+			"quality": qualities,
+		},
+		DefaultOptions: map[string]string{
+			"quality": "best",
+		},
+	}
+}
+
+// qualityName turns names like "1080p60 (source)" into "1080p60"
+// and "Audio Only" into "audio_only"
+func qualityName(v hls.Variant) string {
+	name := v.Name
+	if paren := strings.Index(name, "("); paren != -1 {
+		name = name[:paren]
+	}
+
+	name = strings.Join(strings.Fields(strings.ToLower(name)), "_")
+	if name == "" {
+		return strconv.Itoa(v.Bandwidth)
+	}
+
+	return name
+}
@@ -0,0 +1,230 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+const masterPlaylist = `#EXTM3U
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="chunked",NAME="1080p60 (source)",AUTOSELECT=YES,DEFAULT=YES
+#EXT-X-STREAM-INF:BANDWIDTH=6000000,RESOLUTION=1920x1080,VIDEO="chunked"
+https://vod.example.com/chunked/index-dvr.m3u8
+#EXT-X-MEDIA:TYPE=VIDEO,GROUP-ID="audio_only",NAME="Audio Only",AUTOSELECT=NO,DEFAULT=NO
+#EXT-X-STREAM-INF:BANDWIDTH=160000,VIDEO="audio_only"
+https://vod.example.com/audio_only/index-dvr.m3u8
+`
+
+// gql responses are keyed by the operation and its id, login or slug
+var responses = map[string]string{
+	"VideoMetadata:411281213":       `{"data":{"video":{"title":"Speedrun practice","createdAt":"2019-05-10T18:00:00Z","owner":{"displayName":"Someone"}}}}`,
+	"PlaybackAccessToken:411281213": `{"data":{"videoPlaybackAccessToken":{"value":"{}","signature":"abc"}}}`,
+	"/vod/411281213.m3u8":           masterPlaylist,
+	"StreamMetadata:someone":        `{"data":{"user":{"displayName":"Someone","broadcastSettings":{"title":"Live speedruns"},"stream":{"createdAt":"2019-05-12T10:00:00Z"}}}}`,
+	"PlaybackAccessToken:someone":   `{"data":{"streamPlaybackAccessToken":{"value":"{}","signature":"abc"}}}`,
+	"/api/channel/hls/someone.m3u8": masterPlaylist,
+	"StreamMetadata:sleeping":       `{"data":{"user":{"displayName":"Sleeping","broadcastSettings":{"title":""},"stream":null}}}`,
+	"VideoMetadata:1":               `{"data":{"video":null}}`,
+	"ClipMetadata:FunnyClipSlug": `{"data":{"clip":{"title":"Funny moment","createdAt":"2019-05-11T12:00:00Z","broadcaster":{"displayName":"Someone"},
+		"videoQualities":[{"quality":"1080","frameRate":60,"sourceURL":"https://clips.example.com/1080.mp4"},{"quality":"720","frameRate":30,"sourceURL":"https://clips.example.com/720.mp4"}],
+		"playbackAccessToken":{"value":"{}","signature":"abc"}}}}`,
+}
+
+func responseKey(t *testing.T) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		if r.URL.Path != "/gql" {
+			if query := r.URL.Query(); query.Get("token") != "{}" || query.Get("sig") != "abc" {
+				t.Errorf("Wrong playback access token: %v", r.URL.RawQuery)
+			}
+			return r.URL.Path
+		}
+
+		if r.Method != "POST" || r.Header.Get("Client-ID") != "testid" {
+			t.Errorf("Wrong gql request: %v %v", r.Method, r.Header)
+		}
+
+		req := gqlRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("Invalid gql request: %v", err)
+		}
+
+		key := req.OperationName + ":"
+		for _, variable := range []string{"id", "login", "vodID", "slug"} {
+			if value, ok := req.Variables[variable].(string); ok && value != "" {
+				return key + value
+			}
+		}
+
+		return key
+	}
+}
+
+func newTestIterator(t *testing.T, target string) (*TwitchIterator, *httptest.Server) {
+	ts := testutil.ServeResponsesBy(responses, responseKey(t))
+
+	return &TwitchIterator{
+		clientID:     "testid",
+		baseGqlURL:   ts.URL + "/gql",
+		baseUsherURL: ts.URL,
+		url:          target,
+	}, ts
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://www.twitch.tv/videos/411281213":                  true,
+		"https://www.twitch.tv/someone":                           true,
+		"https://clips.twitch.tv/FunnyClipSlug":                   true,
+		"https://www.twitch.tv/someone/clip/FunnyClipSlug":        true,
+		"twitch.tv/someone":                                       true,
+		"https://m.twitch.tv/someone/":                            true,
+		"https://www.twitch.tv/directory":                         false,
+		"https://www.twitch.tv/settings/profile":                  false,
+		"https://www.twitch.tv/someone/videos":                    false,
+		"https://www.twitch.tv/videos/abc":                        false,
+		"https://www.twitch.tv/":                                  false,
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ":             false,
+		"https://soundcloud.com/musicpromouser/mac-miller-ok-ft-": false,
+	}
+
+	for target, expected := range tests {
+		if (Twitch{}).IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	iterator, ts := newTestIterator(t, "https://www.twitch.tv/videos/411281213")
+	defer ts.Close()
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	if len(items) < 1 {
+		t.Fatalf("Items array is empty")
+	}
+	items[0].Meta["_variants"] = "ignore"
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":        "411281213",
+				"type":      "vod",
+				"title":     "Speedrun practice",
+				"channel":   "Someone",
+				"date":      "2019-05-10T18:00:00Z",
+				"ext":       "ts",
+				"_variants": "ignore",
+			},
+			DefaultName: "%[channel]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "worst", "1080p60", "audio_only"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextClip(t *testing.T) {
+	iterator, ts := newTestIterator(t, "https://www.twitch.tv/someone/clip/FunnyClipSlug")
+	defer ts.Close()
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":       "FunnyClipSlug",
+				"type":     "clip",
+				"title":    "Funny moment",
+				"channel":  "Someone",
+				"date":     "2019-05-11T12:00:00Z",
+				"ext":      "mp4",
+				"_sources": `{"1080p60":"https://clips.example.com/1080.mp4?sig=abc\u0026token=%7B%7D","720p30":"https://clips.example.com/720.mp4?sig=abc\u0026token=%7B%7D"}`,
+			},
+			DefaultName: "%[channel]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"1080p60", "720p30"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "1080p60",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextLive(t *testing.T) {
+	iterator, ts := newTestIterator(t, "https://www.twitch.tv/Someone")
+	defer ts.Close()
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Expected one item, got: %v", items)
+	}
+
+	meta := items[0].Meta
+	if meta["type"] != "live" || meta["title"] != "Live speedruns" || meta["channel"] != "Someone" || meta["date"] != "2019-05-12T10:00:00Z" {
+		t.Errorf("Unexpected meta: %v", meta)
+	}
+}
+
+func TestIteratorNextUnavailable(t *testing.T) {
+	tests := map[string]string{
+		"https://www.twitch.tv/sleeping":  "Channel sleeping is offline",
+		"https://www.twitch.tv/videos/1":  "Video 1 doesn't exist",
+		"https://www.twitch.tv/nobody123": "StreamMetadata query failed: unknown",
+	}
+	responses["StreamMetadata:nobody123"] = `{"errors":[{"message":"unknown"}]}`
+	defer delete(responses, "StreamMetadata:nobody123")
+
+	for target, expected := range tests {
+		iterator, ts := newTestIterator(t, target)
+		_, err := iterator.Next()
+		ts.Close()
+
+		if fmt.Sprint(err) != expected {
+			t.Errorf("Unexpected error for %v: %v, expected: %v", target, err, expected)
+		}
+	}
+}
@@ -30,6 +30,7 @@ import (
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/generic"
 )
 
 type videoTweet struct {
@@ -204,20 +205,16 @@ func (i *TwitterIterator) Next() ([]service.Item, error) {
 		return nil, err
 	}
 
-	description, hasDescription := doc.Find(`meta[property="og:description"]`).Attr("content")
-	if hasDescription {
-		runes := []rune(description)
+	description := generic.ParseOpenGraph(doc).Description
+	if runes := []rune(description); len(runes) >= 2 {
+		// cut the quotes
 		description = string(runes[1 : len(runes)-1])
 	}
 
 	items := []service.Item{}
 
-	doc.Find(`meta[property="og:video:url"]`).Each(func(index int, videoSel *goquery.Selection) {
-		videoURL, hasVideo := videoSel.Attr("content")
-		if !hasVideo {
-			return
-		}
-
+	// og:video and the other og:image tags of twitter are players and thumbnails
+	for index, videoURL := range generic.MetaContents(doc, `meta[property="og:video:url"]`) {
 		items = append(items, service.Item{
 			Meta: map[string]string{
 				"index":       strconv.Itoa(index),
@@ -230,14 +227,9 @@ func (i *TwitterIterator) Next() ([]service.Item, error) {
 			},
 			DefaultName: "%[author]-%[id]-%[index].%[ext]",
 		})
-	})
-
-	doc.Find(`meta[property="og:image"]`).Each(func(index int, imageSel *goquery.Selection) {
-		imageURL, hasImage := imageSel.Attr("content")
-		if !hasImage || len(imageURL) == 0 {
-			return
-		}
+	}
 
+	for index, imageURL := range generic.MetaContents(doc, `meta[property="og:image"]`) {
 		items = append(items, service.Item{
 			Meta: map[string]string{
 				"index":       strconv.Itoa(index),
@@ -250,7 +242,7 @@ func (i *TwitterIterator) Next() ([]service.Item, error) {
 			},
 			DefaultName: "%[author]-%[id]-%[index].%[ext]",
 		})
-	})
+	}
 
 	return items, nil
 }
@@ -18,6 +18,9 @@ package twitter
 
 import (
 	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 
@@ -86,3 +89,40 @@ func TestIteratorNext(t *testing.T) {
 		t.Errorf("%s diff:\n%s", t.Name(), diff)
 	}
 }
+
+func TestIteratorNextSkipsPlayers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><head>
+			<meta property="og:description" content="“a video”">
+			<meta property="og:video" content="https://twitter.com/i/videos/1001">
+			<meta property="og:video:url" content="https://video.twimg.com/1001.mp4">
+			<meta property="og:video:secure_url" content="https://twitter.com/i/videos/1001?secure">
+			<meta property="og:image" content="https://pbs.twimg.com/1001.jpg">
+			<meta property="og:image:url" content="https://pbs.twimg.com/profile.jpg">
+		</head></html>`)
+	}))
+	defer ts.Close()
+
+	iterator := TwitterIterator{
+		url: ts.URL + "/someone/status/1001",
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+
+	downloadURLs := []string{}
+	for _, item := range items {
+		downloadURLs = append(downloadURLs, item.Meta["type"]+" "+item.Meta["downloadURL"])
+	}
+
+	expected := []string{
+		"video https://video.twimg.com/1001.mp4",
+		"image https://pbs.twimg.com/1001.jpg",
+	}
+
+	if diff := pretty.Compare(downloadURLs, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
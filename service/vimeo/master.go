@@ -0,0 +1,205 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package vimeo
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/mlvzk/piko/service/dash"
+)
+
+// masterJSON is vimeo's own format of segmented DASH,
+// video and audio are separate streams
+type masterJSON struct {
+	BaseURL string   `json:"base_url"`
+	Video   []stream `json:"video"`
+	Audio   []stream `json:"audio"`
+}
+
+type stream struct {
+	ID      string `json:"id"`
+	BaseURL string `json:"base_url"`
+	Bitrate int    `json:"bitrate"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	// base64 encoded
+	InitSegment string `json:"init_segment"`
+	Segments    []struct {
+		URL string `json:"url"`
+	} `json:"segments"`
+}
+
+func (s stream) name() string {
+	if s.Height == 0 {
+		return s.ID
+	}
+
+	return strconv.Itoa(s.Height) + "p"
+}
+
+// fetchMaster returns the master with base urls of streams resolved to absolute ones
+func fetchMaster(masterURL string) (masterJSON, error) {
+	master := masterJSON{}
+	if err := getJSON(masterURL, &master); err != nil {
+		return master, err
+	}
+	if len(master.Video) == 0 {
+		return master, errors.New("Couldn't find any streams in the master json")
+	}
+
+	base, err := url.Parse(masterURL)
+	if err != nil {
+		return master, err
+	}
+	base, err = base.Parse(master.BaseURL)
+	if err != nil {
+		return master, err
+	}
+	master.BaseURL = base.String()
+
+	for _, streams := range [][]stream{master.Video, master.Audio} {
+		for i := range streams {
+			streamBase, err := base.Parse(streams[i].BaseURL)
+			if err != nil {
+				return master, err
+			}
+			streams[i].BaseURL = streamBase.String()
+		}
+	}
+
+	return master, nil
+}
+
+func (m masterJSON) bestVideo() (stream, bool) {
+	return find(m.Video, true)
+}
+
+func (m masterJSON) worstVideo() (stream, bool) {
+	return find(m.Video, false)
+}
+
+func (m masterJSON) bestAudio() (stream, bool) {
+	return find(m.Audio, true)
+}
+
+// find returns the stream with the highest bitrate if best is true, the lowest otherwise
+func find(streams []stream, best bool) (stream, bool) {
+	var (
+		found stream
+		ok    bool
+	)
+
+	for _, s := range streams {
+		if !ok || (best && s.Bitrate > found.Bitrate) || (!best && s.Bitrate < found.Bitrate) {
+			found, ok = s, true
+		}
+	}
+
+	return found, ok
+}
+
+// downloadDash writes segments of the video to ffmpeg, merging it with the audio,
+// the audio is downloaded to a temporary file first, like in the youtube service
+func downloadDash(master masterJSON, video stream) (io.Reader, error) {
+	audio, hasAudio := master.bestAudio()
+	if _, err := exec.LookPath("ffmpeg"); err != nil || !hasAudio {
+		videoReader, videoWriter := io.Pipe()
+		go writeSegments(video, videoWriter)
+
+		return videoReader, nil
+	}
+
+	tmpAudioFile, err := ioutil.TempFile("", "audio*.mp4")
+	if err != nil {
+		return nil, err
+	}
+	audioReader, audioWriter := io.Pipe()
+	go writeSegments(audio, audioWriter)
+	_, err = io.Copy(tmpAudioFile, audioReader)
+	// stops writeSegments if writing to the file failed
+	audioReader.CloseWithError(err)
+	tmpAudioFile.Close()
+	if err != nil {
+		os.Remove(tmpAudioFile.Name())
+		return nil, err
+	}
+
+	// the video is started after the audio is on disk,
+	// so nothing is left writing to it if the audio fails
+	videoReader, videoWriter := io.Pipe()
+	go writeSegments(video, videoWriter)
+
+	return dash.MergeStream(videoReader, tmpAudioFile.Name(), func() {
+		os.Remove(tmpAudioFile.Name())
+	})
+}
+
+func writeSegments(s stream, writer *io.PipeWriter) {
+	initSegment, err := base64.StdEncoding.DecodeString(s.InitSegment)
+	if err != nil {
+		writer.CloseWithError(err)
+		return
+	}
+	if _, err := writer.Write(initSegment); err != nil {
+		writer.CloseWithError(err)
+		return
+	}
+
+	base, err := url.Parse(s.BaseURL)
+	if err != nil {
+		writer.CloseWithError(err)
+		return
+	}
+
+	for _, segment := range s.Segments {
+		segmentURL, err := base.Parse(segment.URL)
+		if err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+
+		if err := copySegment(segmentURL.String(), writer); err != nil {
+			writer.CloseWithError(err)
+			return
+		}
+	}
+
+	writer.Close()
+}
+
+func copySegment(segmentURL string, writer io.Writer) error {
+	resp, err := http.Get(segmentURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", segmentURL, resp.StatusCode)
+	}
+
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
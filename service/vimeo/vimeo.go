@@ -0,0 +1,462 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package vimeo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/hls"
+)
+
+type playerConfig struct {
+	Request struct {
+		Files struct {
+			Progressive []progressiveFile `json:"progressive"`
+			Hls         cdns              `json:"hls"`
+			Dash        cdns              `json:"dash"`
+		} `json:"files"`
+	} `json:"request"`
+	Video struct {
+		ID       int    `json:"id"`
+		Title    string `json:"title"`
+		Duration int    `json:"duration"`
+		Owner    struct {
+			Name string `json:"name"`
+		} `json:"owner"`
+	} `json:"video"`
+}
+
+type progressiveFile struct {
+	URL     string `json:"url"`
+	Quality string `json:"quality"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+}
+
+type cdns struct {
+	DefaultCDN string `json:"default_cdn"`
+	CDNs       map[string]struct {
+		URL string `json:"url"`
+	} `json:"cdns"`
+}
+
+func (c cdns) url() string {
+	if cdn, found := c.CDNs[c.DefaultCDN]; found {
+		return cdn.URL
+	}
+
+	for _, cdn := range c.CDNs {
+		return cdn.URL
+	}
+
+	return ""
+}
+
+// videoInfo is a video of the simple api, used for the upload date and listings
+type videoInfo struct {
+	ID         int    `json:"id"`
+	Title      string `json:"title"`
+	UploadDate string `json:"upload_date"`
+	UserName   string `json:"user_name"`
+	Duration   int    `json:"duration"`
+}
+
+type Vimeo struct{}
+type VimeoIterator struct {
+	baseURL       string
+	basePlayerURL string
+	url           string
+	// page of listings, starts at 1
+	page int
+	end  bool
+}
+
+func New() Vimeo {
+	return Vimeo{}
+}
+
+type output struct {
+	io.ReadCloser
+	length uint64
+}
+
+func (o output) Size() uint64 {
+	return o.length
+}
+
+func (s Vimeo) IsValidTarget(target string) bool {
+	return strings.Contains(target, "vimeo.com/")
+}
+
+func (s Vimeo) FetchItems(target string) (service.ServiceIterator, error) {
+	return &VimeoIterator{
+		baseURL:       "https://vimeo.com",
+		basePlayerURL: "https://player.vimeo.com",
+		url:           target,
+		page:          1,
+	}, nil
+}
+
+func (s Vimeo) Download(meta, options map[string]string) (io.Reader, error) {
+	var r renditions
+	if configURL, found := meta["_config"]; found {
+		// videos of listings are resolved only now, so listing them doesn't fetch every rendition up front
+		config := playerConfig{}
+		if err := getJSON(configURL, &config); err != nil {
+			return nil, err
+		}
+
+		var err error
+		r, err = fetchRenditions(config, meta["id"])
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		r.best = meta["_best"]
+		json.Unmarshal([]byte(meta["_progressive"]), &r.progressive)
+		json.Unmarshal([]byte(meta["_hls"]), &r.variants)
+		json.Unmarshal([]byte(meta["_dash"]), &r.master)
+	}
+
+	quality := options["quality"]
+	switch quality {
+	case "best":
+		quality = r.best
+	case "worst":
+		quality = r.worst
+	}
+
+	switch {
+	case strings.HasPrefix(quality, "hls-"):
+		for _, v := range r.variants {
+			if "hls-"+qualityName(v) == quality {
+				limit, _ := time.ParseDuration(options["duration"])
+
+				reader, writer := io.Pipe()
+				go hls.Record(v.URL, limit, writer)
+
+				return reader, nil
+			}
+		}
+	case strings.HasPrefix(quality, "dash-"):
+		for _, stream := range r.master.Video {
+			if "dash-"+stream.name() == quality {
+				return downloadDash(r.master, stream)
+			}
+		}
+	default:
+		if progressiveURL, found := r.progressive[quality]; found {
+			return download(progressiveURL)
+		}
+	}
+
+	return nil, fmt.Errorf("Quality %s is not available", quality)
+}
+
+func download(downloadURL string) (io.Reader, error) {
+	resp, err := http.Get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %v returned a wrong status code - %v", downloadURL, resp.StatusCode)
+	}
+
+	if resp.ContentLength == -1 {
+		return resp.Body, nil
+	}
+
+	return output{
+		ReadCloser: resp.Body,
+		length:     uint64(resp.ContentLength),
+	}, nil
+}
+
+func (i *VimeoIterator) Next() ([]service.Item, error) {
+	kind, name, err := parseTarget(i.url)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	if kind == "video" {
+		i.end = true
+
+		infos := []videoInfo{}
+		if err := getJSON(i.baseURL+"/api/v2/video/"+name+".json", &infos); err != nil {
+			return nil, err
+		}
+		if len(infos) == 0 {
+			return nil, errors.New("Couldn't find the video " + name)
+		}
+
+		item, err := i.videoItem(infos[0])
+		if err != nil {
+			return nil, err
+		}
+
+		return []service.Item{item}, nil
+	}
+
+	// the simple api returns at most 3 pages of 20 videos
+	infos := []videoInfo{}
+	err = getJSON(fmt.Sprintf("%s/api/v2/%s/videos.json?page=%d", i.baseURL, name, i.page), &infos)
+	if err != nil {
+		i.end = true
+		return nil, err
+	}
+
+	i.page++
+	truncated := len(infos) == 20 && i.page > 3
+	if len(infos) < 20 || i.page > 3 {
+		i.end = true
+	}
+
+	items := []service.Item{}
+	for _, info := range infos {
+		items = append(items, i.listedItem(info))
+	}
+	if truncated {
+		return items, fmt.Errorf("Vimeo lists only the latest 60 videos of %s, older ones were skipped", name)
+	}
+
+	return items, nil
+}
+
+func (i VimeoIterator) HasEnded() bool {
+	return i.end
+}
+
+// renditions are the downloadable versions of a video, by names of the qualities
+type renditions struct {
+	best, worst string
+	// all of the names, progressive ones first
+	qualities   []string
+	progressive map[string]string
+	variants    []hls.Variant
+	master      masterJSON
+}
+
+// fetchRenditions resolves the HLS variants and DASH streams of the player config
+func fetchRenditions(config playerConfig, id string) (renditions, error) {
+	r := renditions{
+		progressive: map[string]string{},
+	}
+
+	// highest first
+	files := config.Request.Files.Progressive
+	sort.SliceStable(files, func(a, b int) bool {
+		return files[a].Height > files[b].Height
+	})
+	for _, file := range files {
+		r.progressive[file.Quality] = file.URL
+		r.qualities = append(r.qualities, file.Quality)
+	}
+	if len(files) > 0 {
+		r.best = files[0].Quality
+		r.worst = files[len(files)-1].Quality
+	}
+
+	if hlsURL := config.Request.Files.Hls.url(); hlsURL != "" {
+		content, err := hls.Fetch(hlsURL)
+		if err == nil {
+			r.variants, _ = hls.ParseMaster(hlsURL, content)
+		}
+	}
+	for _, v := range r.variants {
+		r.qualities = append(r.qualities, "hls-"+qualityName(v))
+	}
+
+	if dashURL := config.Request.Files.Dash.url(); dashURL != "" {
+		r.master, _ = fetchMaster(dashURL)
+	}
+	for _, stream := range r.master.Video {
+		r.qualities = append(r.qualities, "dash-"+stream.name())
+	}
+	if bestStream, found := r.master.bestVideo(); found && r.best == "" {
+		r.best = "dash-" + bestStream.name()
+	}
+	if worstStream, found := r.master.worstVideo(); found && r.worst == "" {
+		r.worst = "dash-" + worstStream.name()
+	}
+
+	if r.best == "" && len(r.variants) > 0 {
+		r.best = "hls-" + qualityName(hls.Best(r.variants))
+		r.worst = "hls-" + qualityName(hls.Worst(r.variants))
+	}
+	if r.best == "" {
+		return r, errors.New("Couldn't find any renditions of the video " + id)
+	}
+
+	return r, nil
+}
+
+// videoItem is a video with all of its qualities, each one can be chosen
+func (i *VimeoIterator) videoItem(info videoInfo) (service.Item, error) {
+	id := strconv.Itoa(info.ID)
+
+	config := playerConfig{}
+	if err := getJSON(i.configURL(id), &config); err != nil {
+		return service.Item{}, err
+	}
+
+	r, err := fetchRenditions(config, id)
+	if err != nil {
+		return service.Item{}, err
+	}
+
+	progressiveJSON, _ := json.Marshal(r.progressive)
+	variantsJSON, _ := json.Marshal(r.variants)
+	dashJSON, _ := json.Marshal(r.master)
+
+	owner := config.Video.Owner.Name
+	if owner == "" {
+		owner = info.UserName
+	}
+	title := config.Video.Title
+	if title == "" {
+		title = info.Title
+	}
+	duration := config.Video.Duration
+	if duration == 0 {
+		duration = info.Duration
+	}
+
+	return service.Item{
+		Meta: map[string]string{
+			"id":           id,
+			"title":        title,
+			"owner":        owner,
+			"duration":     strconv.Itoa(duration),
+			"uploadDate":   strings.Split(info.UploadDate, " ")[0],
+			"ext":          "mp4",
+			"_best":        r.best,
+			"_progressive": string(progressiveJSON),
+			"_hls":         string(variantsJSON),
+			"_dash":        string(dashJSON),
+		},
+		DefaultName: "%[owner]-%[title].%[ext]",
+		AvailableOptions: map[string]([]string){
+			"quality": append([]string{"best"}, r.qualities...),
+		},
+		DefaultOptions: map[string]string{
+			"quality": "best",
+		},
+	}, nil
+}
+
+// listedItem is a video of a listing, its renditions are fetched in Download,
+// so only the relative qualities can be chosen
+func (i *VimeoIterator) listedItem(info videoInfo) service.Item {
+	id := strconv.Itoa(info.ID)
+
+	return service.Item{
+		Meta: map[string]string{
+			"id":         id,
+			"title":      info.Title,
+			"owner":      info.UserName,
+			"duration":   strconv.Itoa(info.Duration),
+			"uploadDate": strings.Split(info.UploadDate, " ")[0],
+			"ext":        "mp4",
+			"_config":    i.configURL(id),
+		},
+		DefaultName: "%[owner]-%[title].%[ext]",
+		AvailableOptions: map[string]([]string){
+			"quality": {"best", "worst"},
+		},
+		DefaultOptions: map[string]string{
+			"quality": "best",
+		},
+	}
+}
+
+func (i *VimeoIterator) configURL(id string) string {
+	return i.basePlayerURL + "/video/" + id + "/config"
+}
+
+// qualityName is the height of the variant like 720p
+func qualityName(v hls.Variant) string {
+	if parts := strings.Split(v.Resolution, "x"); len(parts) == 2 {
+		return parts[1] + "p"
+	}
+
+	return strconv.Itoa(v.Bandwidth)
+}
+
+var digitsRegexp = regexp.MustCompile(`^\d+$`)
+
+// parseTarget returns kind of the target and its name, kinds are:
+// video - vimeo.com/<id>, player.vimeo.com/video/<id>, vimeo.com/channels/<name>/<id>, name is the id
+// listing - vimeo.com/showcase/<id>, vimeo.com/channels/<name>, vimeo.com/<user>,
+// name is the path of the simple api
+func parseTarget(target string) (kind, name string, err error) {
+	if !strings.Contains(target, "://") {
+		target = "https://" + target
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", err
+	}
+
+	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	last := pathParts[len(pathParts)-1]
+	isListing := pathParts[0] == "showcase" || pathParts[0] == "album"
+	switch {
+	case digitsRegexp.MatchString(last) && !(isListing && len(pathParts) == 2):
+		return "video", last, nil
+	case isListing && len(pathParts) >= 2:
+		return "listing", "album/" + pathParts[1], nil
+	case pathParts[0] == "channels" && len(pathParts) >= 2:
+		return "listing", "channel/" + pathParts[1], nil
+	case pathParts[0] != "" && (len(pathParts) == 1 || pathParts[1] == "videos"):
+		return "listing", pathParts[0], nil
+	}
+
+	return "", "", errors.New("Unsupported vimeo url: " + target)
+}
+
+func getJSON(u string, v interface{}) error {
+	resp, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("GET %v returned a wrong status code - %v", u, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,317 @@
+// Copyright 2019 mlvzk
+// This file is part of the piko library.
+//
+// The piko library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The piko library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the piko library. If not, see <http://www.gnu.org/licenses/>.
+
+package vimeo
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/testutil"
+)
+
+// {{server}} is replaced with the url of the test server
+var responses = map[string]string{
+	"/api/v2/video/123456.json": `[{"id":123456,"title":"Talk","upload_date":"2019-05-10 12:00:00","user_name":"Conf","duration":1800}]`,
+	"/video/123456/config": `{"video":{"id":123456,"title":"Keynote talk","duration":1805,"owner":{"name":"Some Conference"}},
+		"request":{"files":{
+			"progressive":[
+				{"url":"https://vod.example.com/360.mp4","quality":"360p","width":640,"height":360},
+				{"url":"https://vod.example.com/1080.mp4","quality":"1080p","width":1920,"height":1080},
+				{"url":"https://vod.example.com/720.mp4","quality":"720p","width":1280,"height":720}
+			],
+			"hls":{"default_cdn":"akfire","cdns":{"akfire":{"url":"{{server}}/hls/master.m3u8"}}},
+			"dash":{"default_cdn":"akfire","cdns":{"akfire":{"url":"{{server}}/dash/sep/video/master.json?base64_init=1"}}}
+		}}}`,
+	"/hls/master.m3u8": `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080
+1080/prog_index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360
+360/prog_index.m3u8
+`,
+	"/dash/sep/video/master.json": `{"base_url":"../","video":[
+			{"id":"v720","base_url":"v720/chop/","bitrate":2000000,"width":1280,"height":720,"init_segment":"aW5pdA==","segments":[{"url":"segment-1.m4s"},{"url":"segment-2.m4s"}]},
+			{"id":"v1080","base_url":"v1080/chop/","bitrate":4000000,"width":1920,"height":1080,"init_segment":"aW5pdA==","segments":[{"url":"segment-1.m4s"}]}
+		],"audio":[
+			{"id":"a128","base_url":"../audio/a128/chop/","bitrate":128000,"init_segment":"aW5pdA==","segments":[{"url":"segment-1.m4s"}]}
+		]}`,
+	"/dash/sep/v720/chop/segment-1.m4s":    "-first",
+	"/dash/sep/v720/chop/segment-2.m4s":    "-second",
+	"/api/v2/album/777/videos.json?page=1": `[{"id":111,"title":"First","upload_date":"2019-01-01 10:00:00","user_name":"Conf","duration":60},{"id":222,"title":"Private","upload_date":"2019-01-02 10:00:00","user_name":"Conf","duration":60}]`,
+	"/video/111/config": `{"video":{"id":111,"title":"First","duration":60,"owner":{"name":"Some Conference"}},"request":{"files":{"progressive":[
+			{"url":"{{server}}/111-360.mp4","quality":"360p","width":640,"height":360},
+			{"url":"{{server}}/111-540.mp4","quality":"540p","width":960,"height":540}
+		]}}}`,
+	"/111-360.mp4": "360p video",
+	"/111-540.mp4": "540p video",
+}
+
+func TestIsValidTarget(t *testing.T) {
+	tests := map[string]bool{
+		"https://vimeo.com/123456":                   true,
+		"https://player.vimeo.com/video/123456":      true,
+		"vimeo.com/showcase/777":                     true,
+		"https://www.youtube.com/watch?v=dQw4w9WgXc": false,
+	}
+
+	for target, expected := range tests {
+		if (Vimeo{}).IsValidTarget(target) != expected {
+			t.Errorf("Invalid result, target: %v, expected: %v", target, expected)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	tests := map[string][2]string{
+		"https://vimeo.com/123456":                     {"video", "123456"},
+		"https://player.vimeo.com/video/123456":        {"video", "123456"},
+		"https://vimeo.com/channels/staffpicks/123456": {"video", "123456"},
+		"https://vimeo.com/showcase/777/video/123456":  {"video", "123456"},
+		"https://vimeo.com/showcase/777":               {"listing", "album/777"},
+		"https://vimeo.com/album/777":                  {"listing", "album/777"},
+		"https://vimeo.com/channels/staffpicks":        {"listing", "channel/staffpicks"},
+		"https://vimeo.com/someconference":             {"listing", "someconference"},
+		"https://vimeo.com/someconference/videos":      {"listing", "someconference"},
+	}
+
+	for target, expected := range tests {
+		kind, name, err := parseTarget(target)
+		if err != nil {
+			t.Errorf("parseTarget(%v) error: %v", target, err)
+			continue
+		}
+
+		if kind != expected[0] || name != expected[1] {
+			t.Errorf("Invalid result, target: %v, got: %v %v, expected: %v", target, kind, name, expected)
+		}
+	}
+}
+
+func TestIteratorNext(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator := VimeoIterator{
+		baseURL:       ts.URL,
+		basePlayerURL: ts.URL,
+		url:           "https://vimeo.com/123456",
+		page:          1,
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Expected 1 item, got %v", len(items))
+	}
+
+	for _, key := range []string{"_progressive", "_hls", "_dash"} {
+		if !strings.Contains(items[0].Meta[key], "http") {
+			t.Errorf("Missing urls in meta %v: %v", key, items[0].Meta[key])
+		}
+		items[0].Meta[key] = "ignore"
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":           "123456",
+				"title":        "Keynote talk",
+				"owner":        "Some Conference",
+				"duration":     "1805",
+				"uploadDate":   "2019-05-10",
+				"ext":          "mp4",
+				"_best":        "1080p",
+				"_progressive": "ignore",
+				"_hls":         "ignore",
+				"_dash":        "ignore",
+			},
+			DefaultName: "%[owner]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "1080p", "720p", "360p", "hls-1080p", "hls-360p", "dash-720p", "dash-1080p"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestIteratorNextShowcase(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	iterator := VimeoIterator{
+		baseURL:       ts.URL,
+		basePlayerURL: ts.URL,
+		url:           "https://vimeo.com/showcase/777",
+		page:          1,
+	}
+
+	items, err := iterator.Next()
+	if err != nil {
+		t.Fatalf("iterator.Next() error: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should end after a short page")
+	}
+
+	expected := []service.Item{
+		{
+			Meta: map[string]string{
+				"id":         "111",
+				"title":      "First",
+				"owner":      "Conf",
+				"duration":   "60",
+				"uploadDate": "2019-01-01",
+				"ext":        "mp4",
+				"_config":    ts.URL + "/video/111/config",
+			},
+			DefaultName: "%[owner]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "worst"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+		{
+			Meta: map[string]string{
+				"id":         "222",
+				"title":      "Private",
+				"owner":      "Conf",
+				"duration":   "60",
+				"uploadDate": "2019-01-02",
+				"ext":        "mp4",
+				"_config":    ts.URL + "/video/222/config",
+			},
+			DefaultName: "%[owner]-%[title].%[ext]",
+			AvailableOptions: map[string]([]string){
+				"quality": {"best", "worst"},
+			},
+			DefaultOptions: map[string]string{
+				"quality": "best",
+			},
+		},
+	}
+
+	if diff := pretty.Compare(items, expected); diff != "" {
+		t.Errorf("%s diff:\n%s", t.Name(), diff)
+	}
+}
+
+func TestDownloadListed(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	tests := map[string]string{
+		"best":  "540p video",
+		"worst": "360p video",
+		"1080p": "",
+	}
+
+	meta := map[string]string{"id": "111", "_config": ts.URL + "/video/111/config"}
+	for quality, expected := range tests {
+		reader, err := (Vimeo{}).Download(meta, map[string]string{"quality": quality})
+		if expected == "" {
+			if err == nil {
+				t.Errorf("Expected an error for quality %v", quality)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Download(%v) error: %v", quality, err)
+			continue
+		}
+
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("Read error: %v", err)
+		}
+		if string(content) != expected {
+			t.Errorf("Wrong content of quality %v: %q", quality, content)
+		}
+	}
+
+	// private videos fail only when they're downloaded
+	_, err := (Vimeo{}).Download(map[string]string{"id": "222", "_config": ts.URL + "/video/222/config"}, map[string]string{"quality": "best"})
+	if err == nil {
+		t.Errorf("Expected an error for the private video")
+	}
+}
+
+func TestIteratorNextTruncated(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	fullPage := strings.TrimSuffix(strings.Repeat(`{"id":111,"title":"First","upload_date":"2019-01-01 10:00:00","user_name":"Conf","duration":60},`, 20), ",")
+	responses["/api/v2/album/778/videos.json?page=3"] = "[" + fullPage + "]"
+	defer delete(responses, "/api/v2/album/778/videos.json?page=3")
+
+	iterator := VimeoIterator{
+		baseURL:       ts.URL,
+		basePlayerURL: ts.URL,
+		url:           "https://vimeo.com/showcase/778",
+		page:          3,
+	}
+
+	items, err := iterator.Next()
+	if err == nil || !strings.Contains(err.Error(), "60") {
+		t.Errorf("Expected an error about the skipped videos, got: %v", err)
+	}
+	if !iterator.HasEnded() {
+		t.Errorf("Iterator should end after the third page")
+	}
+	if len(items) != 20 {
+		t.Errorf("Expected 20 items, got %v", len(items))
+	}
+}
+
+func TestDownloadDash(t *testing.T) {
+	ts := testutil.ServeResponses(responses)
+	defer ts.Close()
+
+	master, err := fetchMaster(ts.URL + "/dash/sep/video/master.json?base64_init=1")
+	if err != nil {
+		t.Fatalf("fetchMaster error: %v", err)
+	}
+	if master.Audio[0].BaseURL != ts.URL+"/dash/audio/a128/chop/" {
+		t.Errorf("Wrong audio base url: %v", master.Audio[0].BaseURL)
+	}
+
+	// without audio the video is returned as is, no ffmpeg needed
+	master.Audio = nil
+	reader, err := downloadDash(master, master.Video[0])
+	if err != nil {
+		t.Fatalf("downloadDash error: %v", err)
+	}
+
+	content, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	if string(content) != "init-first-second" {
+		t.Errorf("Wrong content: %q", content)
+	}
+}
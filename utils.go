@@ -17,24 +17,94 @@
 package piko
 
 import (
+	"net/http"
+
 	"github.com/mlvzk/piko/service"
+	"github.com/mlvzk/piko/service/archiveorg"
+	"github.com/mlvzk/piko/service/bandcamp"
+	"github.com/mlvzk/piko/service/dailymotion"
+	"github.com/mlvzk/piko/service/deviantart"
 	"github.com/mlvzk/piko/service/facebook"
+	"github.com/mlvzk/piko/service/feed"
+	"github.com/mlvzk/piko/service/flickr"
 	"github.com/mlvzk/piko/service/fourchan"
+	"github.com/mlvzk/piko/service/generic"
 	"github.com/mlvzk/piko/service/imgur"
 	"github.com/mlvzk/piko/service/instagram"
+	"github.com/mlvzk/piko/service/mastodon"
+	"github.com/mlvzk/piko/service/peertube"
+	"github.com/mlvzk/piko/service/pixiv"
+	"github.com/mlvzk/piko/service/reddit"
 	"github.com/mlvzk/piko/service/soundcloud"
+	"github.com/mlvzk/piko/service/streamable"
+	"github.com/mlvzk/piko/service/tumblr"
+	"github.com/mlvzk/piko/service/twitch"
 	"github.com/mlvzk/piko/service/twitter"
+	"github.com/mlvzk/piko/service/vimeo"
 	"github.com/mlvzk/piko/service/youtube"
 )
 
+// Settings are given only to services which use them
+type Settings struct {
+	// Cookies are sent by services supporting login, nil if not logged in
+	Cookies http.CookieJar
+	// FeedArchive is the path of the archive of downloaded podcast episodes,
+	// an empty path disables it
+	FeedArchive string
+	// FourchanArchives are archive sites by board for dead 4chan threads,
+	// they replace fourchan.DefaultArchives of the same boards
+	FourchanArchives map[string][]string
+}
+
 func GetAllServices() []service.Service {
+	return GetAllServicesWith(Settings{
+		FeedArchive: feed.DefaultArchivePath(),
+	})
+}
+
+func GetAllServicesWith(settings Settings) []service.Service {
+	imgurService := imgur.New("546c25a59c58ad7")
+	genericService := generic.New()
+
+	instagramService, facebookService, pixivService := instagram.New(), facebook.New(), pixiv.New()
+	if settings.Cookies != nil {
+		instagramService = instagram.NewWithCookies(settings.Cookies)
+		facebookService = facebook.NewWithCookies(settings.Cookies)
+		pixivService = pixiv.NewWithCookies(settings.Cookies)
+	}
+
+	fourchanArchives := map[string][]string{}
+	for board, archives := range fourchan.DefaultArchives {
+		fourchanArchives[board] = archives
+	}
+	for board, archives := range settings.FourchanArchives {
+		fourchanArchives[board] = archives
+	}
+
 	return []service.Service{
 		youtube.New(),
-		imgur.New(),
-		instagram.New(),
-		fourchan.New(),
+		imgurService,
+		instagramService,
+		fourchan.NewWithArchives(fourchanArchives),
 		soundcloud.New("a3e059563d7fd3372b49b37f00a00bcf"),
 		twitter.New("AAAAAAAAAAAAAAAAAAAAAIK1zgAAAAAA2tUWuhGZ2JceoId5GwYWU5GspY4%3DUq7gzFoCZs1QfwGoVdvSac3IniczZEYXIcDyumCauIXpcAPorE"),
-		facebook.New(),
+		facebookService,
+		twitch.New("kimne78kx3ncx6brgo4mv6wki5h1ko"),
+		reddit.New(imgurService),
+		vimeo.New(),
+		bandcamp.New(),
+		feed.New(settings.FeedArchive, genericService),
+		tumblr.New(),
+		archiveorg.New(),
+		dailymotion.New(),
+		streamable.New(),
+		flickr.New(),
+		pixivService,
+		deviantart.New(),
+		// these probe the host, so they're after services matching known hosts
+		mastodon.New(),
+		peertube.New(),
+		// accepts every url, must be last
+		genericService,
 	}
 }